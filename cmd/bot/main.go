@@ -1,9 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"github.com/getsentry/sentry-go"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/w32blaster/bot-weather-watcher/structs"
@@ -16,12 +21,35 @@ import (
 
 func main() {
 
+	// "bot migrate status|up" prepares the database and "bot restore <file>" loads a backup, both exit then
+	if len(os.Args) > 1 && (os.Args[1] == "migrate" || os.Args[1] == "restore") {
+		if path := os.Getenv("SITE_LIST_PATH"); len(path) > 0 {
+			command.SiteListPath = path
+		}
+
+		run := runMigrate
+		if os.Args[1] == "restore" {
+			run = runRestore
+		}
+		if err := run(os.Args[2:]); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	// get ENV VAR
 	var opts = structs.Opts{}
 	if err := env.Parse(&opts); err != nil {
 		panic("Can't parse ENV VARS: " + err.Error())
 	}
 
+	if len(opts.ProfilesPath) > 0 {
+		if err := command.LoadProfiles(opts.ProfilesPath); err != nil {
+			panic("Can't load activity profiles: " + err.Error())
+		}
+	}
+
 	bot, err := tgbotapi.NewBotAPI(opts.BotToken)
 	if err != nil {
 		panic("Bot doesn't work. Reason: " + err.Error())
@@ -34,52 +62,117 @@ func main() {
 		Debug: opts.IsDebug,
 	})
 
+	// one database handle for the whole life of the bot, shared by the handlers and the scheduler
+	app, err := command.NewApp(bot, &opts, command.DbPath)
+	if err != nil {
+		panic("Can't open the database: " + err.Error())
+	}
+	defer app.Close()
+
 	// run scheduler
-	gocron.Every(1).Day().At("01:10").Loc(time.UTC).Do(func() {
-		command.CheckWeather(bot, &opts, -1)
-	})
+	var jobs runningJobs
+	gocron.Every(1).Day().At("01:10").Loc(time.UTC).Do(jobs.track(func() {
+		command.CheckWeather(bot, app.Repos, &opts, -1)
+	}))
+	gocron.Every(1).Day().At("00:40").Loc(time.UTC).Do(jobs.track(func() {
+		if _, err := app.Backup(time.Now()); err != nil {
+			sentry.CaptureException(err)
+		}
+	}))
+	gocron.Every(1).Hour().Do(jobs.track(func() {
+		if _, err := command.RunJanitor(bot, app.Repos, &opts, time.Now()); err != nil {
+			sentry.CaptureException(err)
+		}
+	}))
 	gocron.Start()
 
-	sentry.CaptureMessage("Authorized on account " + bot.Self.UserName)
+	command.RecordEvent(app.Repos, structs.Event{Type: command.EventBotStarted, Details: bot.Self.UserName})
 	updates := bot.ListenForWebhook("/" + bot.Token)
 	go http.ListenAndServe(":"+strconv.Itoa(opts.Port), nil)
 
-	for update := range updates {
-
-		sentry.CurrentHub().PushScope()
+	// close the database on shutdown, so the file is released
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		select {
+		case update := <-updates:
+			processUpdate(app, update)
+		case <-stop:
+			gocron.Clear()
+			jobs.wait()
+			return
+		}
+	}
+}
 
-		if update.Message != nil {
+// runningJobs lets the scheduled jobs finish before the database is closed, because gocron.Clear only
+// unschedules them and doesn't wait for the ones that are running
+type runningJobs struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	stopped bool
+}
 
-			configureScope(update.Message.From, "message", update.Message.Text)
+// track wraps the job, so wait knows that it is running; the job doesn't start after wait was called
+func (j *runningJobs) track(job func()) func() {
+	return func() {
+		j.mu.Lock()
+		if j.stopped {
+			j.mu.Unlock()
+			return
+		}
+		j.wg.Add(1)
+		j.mu.Unlock()
 
-			if update.Message.IsCommand() {
+		defer j.wg.Done()
+		job()
+	}
+}
 
-				// This is a command starting with slash
-				command.ProcessCommands(bot, update.Message, &opts)
+// wait blocks until the running jobs are finished and doesn't let new ones start
+func (j *runningJobs) wait() {
+	j.mu.Lock()
+	j.stopped = true
+	j.mu.Unlock()
+	j.wg.Wait()
+}
 
-			} else {
+func processUpdate(app *command.App, update tgbotapi.Update) {
 
-				// just a plain text
-				command.ProcessPlainText(bot, update.Message)
-			}
+	sentry.CurrentHub().PushScope()
 
-		} else if update.CallbackQuery != nil {
+	if update.Message != nil {
 
-			configureScope(update.CallbackQuery.From, "button-clicked", update.CallbackQuery.Data)
+		configureScope(update.Message.From, "message", update.Message.Text)
 
-			// this is the callback after a button click
-			command.ProcessButtonCallback(bot, update.CallbackQuery, &opts)
+		if update.Message.IsCommand() {
 
-		} else if update.InlineQuery != nil {
+			// This is a command starting with slash
+			command.ProcessCommands(app, update.Message)
 
-			configureScope(update.InlineQuery.From, "inline-query", update.InlineQuery.Query)
+		} else {
 
-			// this is inline query (it's like a suggestion while typing)
-			command.ProcessInlineQuery(bot, update.InlineQuery)
+			// just a plain text
+			command.ProcessPlainText(app, update.Message)
 		}
 
-		sentry.CurrentHub().PopScope()
+	} else if update.CallbackQuery != nil {
+
+		configureScope(update.CallbackQuery.From, "button-clicked", update.CallbackQuery.Data)
+
+		// this is the callback after a button click
+		command.ProcessButtonCallback(app, update.CallbackQuery)
+
+	} else if update.InlineQuery != nil {
+
+		configureScope(update.InlineQuery.From, "inline-query", update.InlineQuery.Query)
+
+		// this is inline query (it's like a suggestion while typing)
+		command.ProcessInlineQuery(app, update.InlineQuery)
 	}
+
+	sentry.CurrentHub().PopScope()
 }
 
 func configureScope(user *tgbotapi.User, action, rawText string) {
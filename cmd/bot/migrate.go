@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/w32blaster/bot-weather-watcher/command"
+)
+
+const migrateUsage = "usage: bot migrate status|up|sqlite [sqlite path]"
+
+// runs "bot migrate status" or "bot migrate up" against the bot database. The status prints the schema version
+// and pending migrations, the up applies them; a new database gets the MetOffice sites from SITE_LIST_PATH.
+// The "bot migrate sqlite" copies the bot database to a new SQLite one, at SQLITE_PATH unless the path is given
+func runMigrate(args []string) error {
+	if len(args) == 0 || len(args) > 2 || (args[0] != "sqlite" && len(args) > 1) {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "status", "up":
+	case "sqlite":
+		return runCopyToSQLite(args[1:])
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(command.DbPath), 0755); err != nil {
+		return err
+	}
+
+	db, err := command.OpenDB(command.DbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if args[0] == "status" {
+		version, err := command.SchemaVersion(db)
+		if err != nil {
+			return err
+		}
+		pending, err := command.PendingMigrations(db)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Schema version: %d\n", version)
+		if len(pending) == 0 {
+			fmt.Println("The database is up to date")
+		}
+		for _, migration := range pending {
+			fmt.Printf("Pending %d: %s\n", migration.Version, migration.Description)
+		}
+		return nil
+	}
+
+	applied, err := command.Migrate(db)
+	for _, migration := range applied {
+		fmt.Printf("Applied %d: %s\n", migration.Version, migration.Description)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("The database is up to date")
+	}
+	return nil
+}
+
+func runCopyToSQLite(args []string) error {
+	sqlitePath := os.Getenv("SQLITE_PATH")
+	if len(args) > 0 {
+		sqlitePath = args[0]
+	}
+	if len(sqlitePath) == 0 {
+		sqlitePath = command.SQLiteDbPath
+	}
+
+	src, err := command.OpenDB(command.DbPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(sqlitePath), 0755); err != nil {
+		return err
+	}
+	dst, err := command.OpenSQLite(sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	stats, err := command.CopyToSQLite(src, dst)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Copied %d sites, %d bookmarks, %d states, %d settings, %d audit entries and %d events to %s\n",
+		stats.Sites, stats.Bookmarks, stats.States, stats.Settings, stats.Audit, stats.Events, sqlitePath)
+	fmt.Println("Set STORAGE=sqlite to use it")
+	return nil
+}
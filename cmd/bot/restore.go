@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/w32blaster/bot-weather-watcher/command"
+)
+
+const restoreUsage = "usage: bot restore <backup .db or export .json>"
+
+// runs "bot restore <file>", that loads a backup made by the bot or sent by /backup into an empty database.
+// The bot should be stopped, because the database file is locked while it runs
+func runRestore(args []string) error {
+	if len(args) != 1 {
+		return errors.New(restoreUsage)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(command.DbPath), 0755); err != nil {
+		return err
+	}
+
+	if err := command.Restore(args[0], command.DbPath); err != nil {
+		return err
+	}
+
+	fmt.Println("Restored to " + command.DbPath)
+	return nil
+}
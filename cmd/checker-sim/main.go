@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/command"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/codec/msgpack"
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/yaml.v2"
+)
+
+// Dry-run of the nightly checker: evaluates bookmarks against forecasts and prints every figure,
+// threshold and decision without sending anything to Telegram.
+//
+// Examples:
+//   go run cmd/checker-sim/main.go -db storage/weather.db -user 12345
+//   go run cmd/checker-sim/main.go -bookmarks bookmarks.yaml -fixtures api-examples/daily -json
+
+type (
+	yamlBookmarks struct {
+		Bookmarks []yamlBookmark `yaml:"bookmarks"`
+	}
+
+	// hypothetical bookmark, the fields are the same as the wizard asks; optional bounds are nil when not set
+	yamlBookmark struct {
+		Name             string   `yaml:"name"`
+		LocationID       string   `yaml:"locationId"`
+		GroupLocationIDs []string `yaml:"groupLocationIds"` // sites of a location group, instead of locationId
+		MaxWindSpeed     int      `yaml:"maxWindSpeed"`
+		LowestTemp       int      `yaml:"lowestTemp"`
+		CheckPeriod      string   `yaml:"checkPeriod"`     // "all" or "weekends"
+		ExcludedWeather  []string `yaml:"excludedWeather"` // names of the weather categories, for example "fog" or "snow"
+		MaxGust          *int     `yaml:"maxGust"`
+		MaxTemp          *int     `yaml:"maxTemp"`
+		MaxHumidity      *int     `yaml:"maxHumidity"`
+		MaxUV            *int     `yaml:"maxUV"`
+		MaxPrecipProb    *int     `yaml:"maxPrecipProb"`
+		MinVisibility    int      `yaml:"minVisibility"`  // from 0 (any) to 6 (excellent)
+		Window           string   `yaml:"window"`         // "day", "night" or "golden"; the day is the default one
+		MinNightTemp     *int     `yaml:"minNightTemp"`   // for the night window only
+		MinRunLength     int      `yaml:"minRunLength"`   // how many good days in a row are needed
+		WindDirections   []string `yaml:"windDirections"` // acceptable sectors, for example "N" or "SW"; any if empty
+		UVWarnLevel      *int     `yaml:"uvWarnLevel"`
+		Polarity         string   `yaml:"polarity"` // "good" or "hazard"; the good weather is the default one
+		Hazards          []string `yaml:"hazards"`  // names of the hazards to warn about, for example "frost" or "gale"
+		Profile          string   `yaml:"profile"`  // the key of the activity profile, its thresholds replace the ones above
+	}
+
+	// reads forecasts from files named <locationID>.json, saved from the DataPoint API with res=daily,
+	// and <locationID>-3hourly.json with res=3hourly, which is needed for golden hour bookmarks only
+	fixtureProvider struct {
+		dir string
+	}
+)
+
+func main() {
+	dbPath := flag.String("db", "", "path to the bot database to load bookmarks from")
+	bookmarksPath := flag.String("bookmarks", "", "path to a YAML file with hypothetical bookmarks")
+	userID := flag.Int("user", -1, "check only bookmarks of this user (for -db only)")
+	fixturesDir := flag.String("fixtures", "", "directory with forecast fixtures named <locationID>.json; MetOffice is used if empty")
+	metofficeKey := flag.String("key", os.Getenv("METOFFICE_APP_ID"), "MetOffice DataPoint key, used when no fixtures are given")
+	profilesPath := flag.String("profiles", os.Getenv("PROFILES_PATH"), "YAML file with more activity profiles, as the bot has")
+	asJSON := flag.Bool("json", false, "print results as JSON")
+	flag.Parse()
+
+	var bookmarks []structs.UsersLocationBookmark
+	names := make(map[int]string)
+	mapLocs := make(map[string]structs.SiteLocation)
+	var err error
+
+	if len(*profilesPath) > 0 {
+		if err := command.LoadProfiles(*profilesPath); err != nil {
+			fmt.Println("Error loading profiles, err: " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	switch {
+	case len(*dbPath) > 0:
+		bookmarks, mapLocs, err = loadFromDatabase(*dbPath, *userID)
+	case len(*bookmarksPath) > 0:
+		bookmarks, names, err = loadFromYAML(*bookmarksPath)
+	default:
+		err = fmt.Errorf("please specify either -db or -bookmarks")
+	}
+	if err != nil {
+		fmt.Println("Error loading bookmarks, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	var provider command.ForecastProvider = command.MetOfficeProvider{Opts: &structs.Opts{MetofficeAppID: *metofficeKey}}
+	if len(*fixturesDir) > 0 {
+		provider = fixtureProvider{dir: *fixturesDir}
+	}
+
+	// the same report as the bot builds, but nothing is sent
+	report := command.BuildCheckReport(bookmarks, mapLocs, provider)
+	for i := range report.Bookmarks {
+		if name, ok := names[report.Bookmarks[i].BookmarkID]; ok {
+			report.Bookmarks[i].Title = name
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(report); err != nil {
+			fmt.Println("Error encoding results, err: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	printReport(report)
+}
+
+// loads bookmarks and the sites they watch, so the sites have names in the report. The running bot keeps the
+// database locked, so either stop it or point to a snapshot made by /backup
+func loadFromDatabase(path string, userID int) ([]structs.UsersLocationBookmark, map[string]structs.SiteLocation, error) {
+	db, err := storm.Open(path, storm.Codec(msgpack.Codec), storm.BoltOptions(0600, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  5 * time.Second,
+	}))
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open the database, please stop the bot or use a snapshot made by /backup: %s", err.Error())
+	}
+	defer db.Close()
+
+	// the same bookmarks as the checker evaluates, so the wizards in progress are not included
+	repos := command.NewStormRepositories(db)
+	bookmarks, err := repos.Bookmarks.FindReady(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mapLocs := make(map[string]structs.SiteLocation)
+	for _, bookmark := range bookmarks {
+		for _, locationID := range command.MemberLocationIDs(bookmark) {
+			if site, err := repos.Sites.Get(locationID); err == nil {
+				mapLocs[locationID] = site
+			}
+		}
+	}
+
+	return bookmarks, mapLocs, nil
+}
+
+func loadFromYAML(path string) ([]structs.UsersLocationBookmark, map[int]string, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var parsed yamlBookmarks
+	if err := yaml.Unmarshal(bytes, &parsed); err != nil {
+		return nil, nil, err
+	}
+
+	names := make(map[int]string)
+	bookmarks := make([]structs.UsersLocationBookmark, len(parsed.Bookmarks))
+	for i, b := range parsed.Bookmarks {
+		bookmark, err := b.toBookmark()
+		if err != nil {
+			return nil, nil, fmt.Errorf("bookmark %d (%s): %s", i+1, b.Name, err.Error())
+		}
+
+		bookmark.ID = i + 1
+		bookmarks[i] = bookmark
+		names[bookmark.ID] = b.Name
+	}
+
+	return bookmarks, names, nil
+}
+
+// converts the readable values to the ones that the bot saves
+func (b yamlBookmark) toBookmark() (structs.UsersLocationBookmark, error) {
+	bookmark := structs.UsersLocationBookmark{
+		LocationID:    b.LocationID,
+		MaxWindSpeed:  b.MaxWindSpeed,
+		LowestTemp:    b.LowestTemp,
+		IsReady:       true,
+		MaxGust:       b.MaxGust,
+		MaxTemp:       b.MaxTemp,
+		MaxHumidity:   b.MaxHumidity,
+		MaxUV:         b.MaxUV,
+		MaxPrecipProb: b.MaxPrecipProb,
+		MinVisibility: b.MinVisibility,
+		MinNightTemp:  b.MinNightTemp,
+		MinRunLength:  b.MinRunLength,
+		UVWarnLevel:   b.UVWarnLevel,
+	}
+
+	if len(b.GroupLocationIDs) > 0 {
+		bookmark.GroupName = b.Name
+		bookmark.GroupLocationIDs = b.GroupLocationIDs
+		bookmark.LocationID = b.GroupLocationIDs[0]
+	}
+	if len(bookmark.LocationID) == 0 {
+		return bookmark, fmt.Errorf("either locationId or groupLocationIds is expected")
+	}
+
+	switch b.CheckPeriod {
+	case "", "all":
+		bookmark.CheckPeriod = command.AllDays
+	case "weekends":
+		bookmark.CheckPeriod = command.OnlyWeekends
+	default:
+		return bookmark, fmt.Errorf("checkPeriod is either 'all' or 'weekends', but got '%s'", b.CheckPeriod)
+	}
+
+	switch b.Window {
+	case "", "day":
+	case "night":
+		bookmark.Mode = command.ModeNight
+	case "golden":
+		bookmark.GoldenHourOnly = true
+	default:
+		return bookmark, fmt.Errorf("window is 'day', 'night' or 'golden', but got '%s'", b.Window)
+	}
+
+	switch b.Polarity {
+	case "", "good":
+	case "hazard":
+		bookmark.Polarity = command.PolarityHazard
+	default:
+		return bookmark, fmt.Errorf("polarity is either 'good' or 'hazard', but got '%s'", b.Polarity)
+	}
+
+	var err error
+	if bookmark.ExcludedWeather, err = command.WeatherCategoryFlags(b.ExcludedWeather); err != nil {
+		return bookmark, err
+	}
+	if bookmark.WindDirections, err = command.WindDirectionFlags(b.WindDirections); err != nil {
+		return bookmark, err
+	}
+	if bookmark.Hazards, err = command.HazardFlags(b.Hazards); err != nil {
+		return bookmark, err
+	}
+
+	if len(b.Profile) > 0 {
+		return bookmark, command.ApplyProfile(b.Profile, &bookmark)
+	}
+	return bookmark, nil
+}
+
+func (p fixtureProvider) DailyForecast(locationID string) (*structs.RootSiteRep, error) {
+	return p.load(locationID)
+}
+
+func (p fixtureProvider) ThreeHourlyForecast(locationID string) (*structs.RootSiteRep, error) {
+	return p.load(locationID + "-3hourly")
+}
+
+func (p fixtureProvider) load(name string) (*structs.RootSiteRep, error) {
+	bytes, err := ioutil.ReadFile(filepath.Join(p.dir, name+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var result structs.RootSiteRep
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func printReport(report command.CheckReport) {
+	for _, bookmark := range report.Bookmarks {
+		fmt.Printf("Bookmark #%d of user %d: %s\n", bookmark.BookmarkID, bookmark.UserID, bookmark.Title)
+
+		for _, site := range bookmark.Sites {
+			if len(site.Name) > 0 {
+				fmt.Printf(" site %s (%s)\n", site.Name, site.LocationID)
+			} else {
+				fmt.Printf(" site %s\n", site.LocationID)
+			}
+			if len(site.Error) > 0 {
+				fmt.Println("  error: " + site.Error)
+			}
+
+			for _, day := range site.Days {
+				fmt.Printf("  %s %s: ", day.Date, day.Weekday[0:3])
+				if day.Skipped {
+					fmt.Println("skipped, not in the checked period")
+					continue
+				}
+
+				parts := make([]string, len(day.Criteria))
+				for i, c := range day.Criteria {
+					mark := "✔"
+					if !c.Passed {
+						mark = "✘"
+					}
+					parts[i] = fmt.Sprintf("%s %s%s %s %s%s %s", c.Name, c.Value, c.Unit, c.Operator, c.Threshold, c.Unit, mark)
+				}
+
+				decision := "bad"
+				if day.IsSuitable {
+					decision = "GOOD"
+				}
+				if day.IsHazardous {
+					decision = "WARNING"
+				}
+				fmt.Printf("%s => %s\n", strings.Join(parts, ", "), decision)
+			}
+		}
+
+		for _, run := range bookmark.Runs {
+			fmt.Printf("  run: %s – %s, %d days in a row\n", run.From, run.To, run.Days)
+		}
+		fmt.Println()
+	}
+}
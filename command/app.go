@@ -0,0 +1,157 @@
+package command
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/codec/msgpack"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// App is the application context: everything that lives as long as the bot does. The database is opened
+// once at startup and shared by all the handlers and the nightly checker, because bbolt locks the file
+// exclusively and opening it per every update blocks concurrent ones. Handlers use the database
+// only through the repositories
+type App struct {
+	Bot    *tgbotapi.BotAPI
+	DB     *storm.DB // nil when the SQLite storage is used
+	SQL    *sql.DB   // nil when the storm storage is used
+	Repos  Repositories
+	Sites  *SiteIndex       // all the sites by their coordinates, loaded once at startup
+	Search *SiteSearchIndex // all the sites by their names, for the inline query
+	Opts   *structs.Opts
+}
+
+// NewApp opens the database chosen by opts.Storage and applies pending migrations; call Close on shutdown.
+// The storm database is at dbPath, the SQLite one at opts.SQLitePath
+func NewApp(bot *tgbotapi.BotAPI, opts *structs.Opts, dbPath string) (*App, error) {
+	app := &App{Bot: bot, Opts: opts}
+	if len(opts.SiteListPath) > 0 {
+		SiteListPath = opts.SiteListPath
+	}
+
+	var err error
+	switch opts.Storage {
+	case StorageStorm, "":
+		err = app.openStorm(dbPath)
+	case StorageSQLite:
+		err = app.openSQLite(opts.SQLitePath)
+	default:
+		err = fmt.Errorf("unknown storage '%s', expected '%s' or '%s'", opts.Storage, StorageStorm, StorageSQLite)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sites, err := app.Repos.Sites.All()
+	if err != nil {
+		app.Close()
+		return nil, err
+	}
+	bookmarks, err := app.Repos.Bookmarks.FindReady(-1)
+	if err != nil {
+		app.Close()
+		return nil, err
+	}
+
+	SetCallbackSecret(opts.CallbackSecret, opts.BotToken)
+
+	app.Sites = NewSiteIndex(sites)
+	app.Search = NewSiteSearchIndex(sites, sitePopularity(bookmarks))
+	return app, nil
+}
+
+func (a *App) openStorm(dbPath string) error {
+	db, err := OpenDB(dbPath)
+	if err != nil {
+		return err
+	}
+
+	applied, err := Migrate(db)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	a.DB, a.Repos = db, NewStormRepositories(db)
+	for _, migration := range applied {
+		RecordEvent(a.Repos, structs.Event{Type: EventMigrated, Details: fmt.Sprintf("version %d: %s", migration.Version, migration.Description)})
+	}
+	return nil
+}
+
+// a new SQLite database gets the MetOffice sites like a new storm one does, unless they are copied by "bot migrate sqlite"
+func (a *App) openSQLite(path string) error {
+	db, err := OpenSQLite(path)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sites").Scan(&count); err != nil {
+		db.Close()
+		return err
+	}
+	imported := 0
+	if count == 0 {
+		sites, err := readSiteList(SiteListPath)
+		if err == nil {
+			err = saveSQLiteSites(db, sites)
+		}
+		if err != nil {
+			db.Close()
+			return errors.Wrap(err, "can't import the MetOffice sites")
+		}
+		imported = len(sites)
+	}
+
+	a.SQL, a.Repos = db, NewSQLiteRepositories(db)
+	if imported > 0 {
+		RecordEvent(a.Repos, structs.Event{Type: EventMigrated, Details: fmt.Sprintf("%d MetOffice sites are imported to SQLite", imported)})
+	}
+	return nil
+}
+
+// OpenDB opens the database with the codec used by the bot
+func OpenDB(path string) (*storm.DB, error) {
+	return storm.Open(path, storm.Codec(msgpack.Codec))
+}
+
+// IsAdmin returns true if the user is allowed to use admin commands, such as /backup
+func (a *App) IsAdmin(userID int) bool {
+	for _, id := range a.Opts.AdminIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Backup saves the copy of the database to Opts.BackupDir and removes the old ones, returns the path of the new copy
+func (a *App) Backup(now time.Time) (string, error) {
+	if a.SQL != nil {
+		return BackupSQLite(a.SQL, a.Opts.BackupDir, a.Opts.BackupKeep, now)
+	}
+	return Backup(a.DB, a.Opts.BackupDir, a.Opts.BackupKeep, now)
+}
+
+// Export writes the JSON export of the users data, that "bot restore" loads into a new storm database
+func (a *App) Export(w io.Writer, now time.Time) error {
+	if a.SQL != nil {
+		return ExportSQLite(a.SQL, w, now)
+	}
+	return Export(a.DB, w, now)
+}
+
+// Close releases the database, so other processes can open it
+func (a *App) Close() error {
+	if a.SQL != nil {
+		return a.SQL.Close()
+	}
+	return a.DB.Close()
+}
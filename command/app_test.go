@@ -0,0 +1,70 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+// the typical work of one update: the state of the user, the settings and the bookmarks
+func simulateUpdate(b *testing.B, repos Repositories) {
+	repos.States.GetState(UserID)
+	GetUserSettings(repos.States, UserID)
+
+	if _, err := repos.Bookmarks.FindByUser(UserID); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func prepareBenchmarkDB(b *testing.B) (string, string) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "storm")
+	path := filepath.Join(dir, "weather.db")
+
+	db, err := OpenDB(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	db.Save(&structs.SiteLocation{ID: TestLocationID, Name: "London"})
+	for i := 0; i < 10; i++ {
+		db.Save(&structs.UsersLocationBookmark{UserID: UserID, LocationID: TestLocationID, MaxWindSpeed: 20, IsReady: true})
+	}
+	db.Close()
+
+	return dir, path
+}
+
+// how it was: every handler opened and closed the database
+func BenchmarkOpenDatabasePerUpdate(b *testing.B) {
+	dir, path := prepareBenchmarkDB(b)
+	defer os.RemoveAll(dir)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db, err := OpenDB(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		simulateUpdate(b, NewStormRepositories(db))
+		db.Close()
+	}
+}
+
+// how it is: one handle from the App shared by all the handlers
+func BenchmarkSharedDatabaseHandle(b *testing.B) {
+	dir, path := prepareBenchmarkDB(b)
+	defer os.RemoveAll(dir)
+
+	app, err := NewApp(nil, &structs.Opts{}, path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer app.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		simulateUpdate(b, app.Repos)
+	}
+}
@@ -0,0 +1,385 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/asdine/storm"
+	"github.com/getsentry/sentry-go"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	backupPrefix     = "weather-"
+	backupTimeLayout = "20060102-150405"
+)
+
+// DatabaseExport is the JSON export of everything users saved, with the audit trail and the event log. Sites are not exported, they are imported
+// again from the site list on restore
+type DatabaseExport struct {
+	SchemaVersion int                             `json:"schemaVersion"`
+	ExportedAt    time.Time                       `json:"exportedAt"`
+	Settings      []structs.UserSettings          `json:"settings"`
+	Bookmarks     []structs.UsersLocationBookmark `json:"bookmarks"`
+	States        []structs.UserState             `json:"states"`
+	Audit         []structs.AuditEntry            `json:"audit"`
+	Events        []structs.Event                 `json:"events"`
+}
+
+// WriteSnapshot writes the consistent copy of the whole database, the bot keeps working meanwhile
+func WriteSnapshot(db *storm.DB, w io.Writer) error {
+	return db.Bolt.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// Backup saves the snapshot to the directory as "weather-<time>.db" and removes old ones, so only the
+// last keep backups stay. Returns the path of the new backup
+func Backup(db *storm.DB, dir string, keep int, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// write to a temporary file first, so a half-written backup never looks like a real one
+	path := filepath.Join(dir, backupPrefix+now.UTC().Format(backupTimeLayout)+".db")
+	tmp, err := ioutil.TempFile(dir, "backup")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = WriteSnapshot(db, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "can't write the snapshot")
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+
+	return path, rotateBackups(dir, ".db", keep)
+}
+
+// removes all the backups with the extension but the last keep ones, zero or less keeps all of them; names contain
+// the time, so they are sorted by it
+func rotateBackups(dir, ext string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*"+ext))
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(backups)
+	for i := 0; i < len(backups)-keep; i++ {
+		if err := os.Remove(backups[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpireBackups removes the backups of both storages older than retentionDays, but never the newest one, and
+// returns how many were removed. Zero keeps them all, so only BACKUP_KEEP limits them
+func ExpireBackups(dir string, retentionDays int, now time.Time) (int, error) {
+	if retentionDays <= 0 {
+		return 0, nil
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*"))
+	if err != nil {
+		return 0, err
+	}
+
+	// names contain the time, the files with other names are not backups
+	var made []time.Time
+	var paths []string
+	for _, path := range backups {
+		name := filepath.Base(path)
+		t, err := time.Parse(backupTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), filepath.Ext(name)))
+		if err == nil {
+			made, paths = append(made, t), append(paths, path)
+		}
+	}
+
+	newest := 0
+	for i := range made {
+		if made[i].After(made[newest]) {
+			newest = i
+		}
+	}
+
+	removed := 0
+	for i, path := range paths {
+		if i == newest || !made[i].Before(now.UTC().AddDate(0, 0, -retentionDays)) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Export writes all the users settings, bookmarks, states, the audit trail and the events as JSON
+func Export(db storm.Node, w io.Writer, now time.Time) error {
+	export := DatabaseExport{ExportedAt: now.UTC()}
+
+	var err error
+	if export.SchemaVersion, err = SchemaVersion(db); err != nil {
+		return err
+	}
+	if err := db.All(&export.Settings); err != nil {
+		return err
+	}
+	if err := db.All(&export.Bookmarks); err != nil {
+		return err
+	}
+	if err := db.All(&export.States); err != nil {
+		return err
+	}
+	if err := db.All(&export.Audit); err != nil {
+		return err
+	}
+	if err := db.All(&export.Events); err != nil {
+		return err
+	}
+	return writeExport(w, export)
+}
+
+func writeExport(w io.Writer, export DatabaseExport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+// Restore loads either the snapshot or the JSON export to the database at dbPath, that should be empty or
+// not exist yet. The restored database is migrated to the current schema version
+func Restore(src, dbPath string) error {
+	isJSON, err := isJSONExport(src)
+	if err != nil {
+		return err
+	}
+	if err := checkEmptyDatabase(dbPath); err != nil {
+		return err
+	}
+
+	if isJSON {
+		return restoreExport(src, dbPath)
+	}
+	return restoreSnapshot(src, dbPath)
+}
+
+// the export starts with "{", the snapshot is a binary bbolt file
+func isJSONExport(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return false, errors.Wrap(err, "can't read the backup")
+		}
+		if b != ' ' && b != '\n' && b != '\r' && b != '\t' {
+			return b == '{', nil
+		}
+	}
+}
+
+func checkEmptyDatabase(dbPath string) error {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	db, err := OpenDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, data := range []interface{}{new(structs.UsersLocationBookmark), new(structs.UserState), new(structs.UserSettings)} {
+		count, err := db.Count(data)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("the database " + dbPath + " is not empty, please restore to a new one")
+		}
+	}
+	return nil
+}
+
+// the snapshot is copied and migrated next to the database first, so a file that is not a storm database, such as
+// the backup of SQLite, never takes the place of the database
+func restoreSnapshot(src, dbPath string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile(filepath.Dir(dbPath), "restore")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := migrateSnapshot(out.Name()); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dbPath)
+}
+
+func migrateSnapshot(path string) error {
+	db, err := OpenDB(path)
+	if err != nil {
+		return errors.Wrap(err, "the snapshot is not a valid storm database")
+	}
+	defer db.Close()
+
+	_, err = Migrate(db)
+	return err
+}
+
+func restoreExport(src, dbPath string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	var export DatabaseExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return errors.Wrap(err, "can't parse the export")
+	}
+
+	db, err := OpenDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// records get new IDs, because storm doesn't move the increment counter for the saved ones;
+	// the states and events refer to bookmarks, so they get the new IDs of them
+	bookmarkIDs := make(map[int]int)
+	for i := range export.Bookmarks {
+		oldID := export.Bookmarks[i].ID
+		export.Bookmarks[i].ID = 0
+		if err := tx.Save(&export.Bookmarks[i]); err != nil {
+			return err
+		}
+		bookmarkIDs[oldID] = export.Bookmarks[i].ID
+	}
+	for i := range export.States {
+		export.States[i].ID = 0
+		export.States[i].BookmarkID = bookmarkIDs[export.States[i].BookmarkID]
+		if err := tx.Save(&export.States[i]); err != nil {
+			return err
+		}
+	}
+	for i := range export.Settings {
+		export.Settings[i].ID = 0
+		if err := tx.Save(&export.Settings[i]); err != nil {
+			return err
+		}
+	}
+	for i := range export.Audit {
+		export.Audit[i].ID = 0
+		if err := tx.Save(&export.Audit[i]); err != nil {
+			return err
+		}
+	}
+	for i := range export.Events {
+		export.Events[i].ID = 0
+		export.Events[i].BookmarkID = bookmarkIDs[export.Events[i].BookmarkID]
+		if err := tx.Save(&export.Events[i]); err != nil {
+			return err
+		}
+	}
+
+	// the export was made at some schema version, sites are needed for all of them
+	if export.SchemaVersion > 0 {
+		if err := importSites(tx); err != nil {
+			return err
+		}
+		if err := tx.Set(metaBucket, schemaVersionKey, export.SchemaVersion); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	_, err = Migrate(db)
+	return err
+}
+
+// SendBackup makes a new backup and sends it to the admin, the export is sent if asked with "/backup json".
+// The backup has the data of all the users, so it is sent only to the private chat with the admin
+func SendBackup(app *App, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	now := time.Now()
+
+	if !message.Chat.IsPrivate() {
+		sendMsg(app.Bot, chatID, "Sorry, the backup has the data of all the users, please ask for it in the private chat with me")
+		return
+	}
+
+	var file interface{}
+	if message.CommandArguments() == "json" {
+		var buffer bytes.Buffer
+		if err := app.Export(&buffer, now); err != nil {
+			sentry.CaptureException(err)
+			sendMsg(app.Bot, chatID, "Sorry, can't export the database: "+err.Error())
+			return
+		}
+		file = tgbotapi.FileBytes{Name: backupPrefix + now.UTC().Format(backupTimeLayout) + ".json", Bytes: buffer.Bytes()}
+	} else {
+		path, err := app.Backup(now)
+		if err != nil {
+			sentry.CaptureException(err)
+			sendMsg(app.Bot, chatID, "Sorry, can't make the backup: "+err.Error())
+			return
+		}
+		file = path
+	}
+
+	if _, err := app.Bot.Send(tgbotapi.NewDocumentUpload(chatID, file)); err != nil {
+		sentry.CaptureException(err)
+	}
+}
@@ -0,0 +1,267 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+func TestBackupRotation(t *testing.T) {
+
+	// Given:
+	dir, db := prepareBackupDB(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	backupDir := filepath.Join(dir, "backups")
+	start := time.Date(2019, 11, 8, 0, 40, 0, 0, time.UTC)
+
+	// When: four nightly backups, but only two are kept
+	var paths []string
+	for day := 0; day < 4; day++ {
+		path, err := Backup(db, backupDir, 2, start.AddDate(0, 0, day))
+		assert.Nil(t, err)
+		paths = append(paths, path)
+	}
+
+	// Then:
+	files, _ := ioutil.ReadDir(backupDir)
+	assert.Equal(t, 2, len(files))
+	assert.Equal(t, "weather-20191110-004000.db", files[0].Name())
+	assert.Equal(t, filepath.Base(paths[3]), files[1].Name())
+}
+
+func TestExpireBackups(t *testing.T) {
+
+	// Given: backups of both storages made every 40 days, and some other file
+	dir, _ := ioutil.TempDir(os.TempDir(), "backups")
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2019, 11, 8, 0, 40, 0, 0, time.UTC)
+	for _, name := range []string{"weather-20190720-004000.db", "weather-20190829-004000.sqlite", "weather-20190929-004000.db", "weather-notes.txt"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("backup"), 0644)
+	}
+
+	// When: all are kept
+	count, err := ExpireBackups(dir, 0, now)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	// and when: 60 days
+	count, err = ExpireBackups(dir, 60, now)
+
+	// Then: the older ones are removed
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 2, len(files))
+	assert.Equal(t, "weather-20190929-004000.db", files[0].Name())
+
+	// and when: much later
+	count, err = ExpireBackups(dir, 60, now.AddDate(1, 0, 0))
+
+	// Then: the last backup is never removed
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	_, err = os.Stat(filepath.Join(dir, "weather-20190929-004000.db"))
+	assert.Nil(t, err)
+}
+
+func TestBackupRotationKeepsAll(t *testing.T) {
+
+	// Given:
+	dir, db := prepareBackupDB(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	backupDir := filepath.Join(dir, "backups")
+	start := time.Date(2019, 11, 8, 0, 40, 0, 0, time.UTC)
+
+	// When: BACKUP_KEEP is zero
+	for day := 0; day < 3; day++ {
+		_, err := Backup(db, backupDir, 0, start.AddDate(0, 0, day))
+		assert.Nil(t, err)
+	}
+
+	// Then: nothing is removed, including the new one
+	files, _ := ioutil.ReadDir(backupDir)
+	assert.Equal(t, 3, len(files))
+}
+
+func TestSendBackupOnlyToPrivateChat(t *testing.T) {
+
+	// Given: the admin asks for the backup in a group chat
+	dir, db := prepareBackupDB(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	backupDir := filepath.Join(dir, "backups")
+	app := &App{DB: db, Repos: NewStormRepositories(db), Opts: &structs.Opts{BackupDir: backupDir, BackupKeep: 2, AdminIDs: []int{UserID}}}
+	message := &tgbotapi.Message{From: &tgbotapi.User{ID: UserID}, Chat: &tgbotapi.Chat{ID: -100, Type: "group"}, Text: "/backup"}
+
+	// When:
+	SendBackup(app, message)
+
+	// Then: the backup is not even made
+	_, err := os.Stat(backupDir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+
+	// Given:
+	dir, db := prepareBackupDB(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	path, err := Backup(db, dir, 1, time.Now())
+	assert.Nil(t, err)
+
+	// When:
+	restoredPath := filepath.Join(dir, "restored.db")
+	assert.Nil(t, Restore(path, restoredPath))
+
+	// Then:
+	restored, err := OpenDB(restoredPath)
+	assert.Nil(t, err)
+	defer restored.Close()
+
+	bookmarks, _ := NewStormRepositories(restored).Bookmarks.FindByUser(UserID)
+	assert.Equal(t, 2, len(bookmarks))
+
+	version, _ := SchemaVersion(restored)
+	assert.Equal(t, len(migrations), version)
+}
+
+func TestRestoreExport(t *testing.T) {
+
+	// Given: the export of the database where user is changing a group
+	dir, db := prepareBackupDB(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	repos := NewStormRepositories(db)
+	bookmarks, _ := repos.Bookmarks.FindByUser(UserID)
+	assert.Nil(t, repos.States.SaveState(&structs.UserState{UserID: UserID, CurrentState: StepAddGroupMember, BookmarkID: bookmarks[1].ID}))
+	assert.Nil(t, repos.Audit.Record(&structs.AuditEntry{Action: auditForgetMe, Time: time.Now().UTC(), Bookmarks: 3}))
+	assert.Nil(t, repos.Events.Record(&structs.Event{Type: EventAlertSent, Time: time.Now().UTC(), UserID: UserID, BookmarkID: bookmarks[1].ID}))
+
+	exportPath := filepath.Join(dir, "export.json")
+	f, _ := os.Create(exportPath)
+	assert.Nil(t, Export(db, f, time.Now()))
+	f.Close()
+
+	// the sites are imported from the list again
+	defer func(path string) { SiteListPath = path }(SiteListPath)
+	SiteListPath = filepath.Join(dir, "site-list.json")
+	ioutil.WriteFile(SiteListPath, []byte(`{"Locations": {"Location": [{"id": "111", "name": "London"}]}}`), 0644)
+
+	// When:
+	restoredPath := filepath.Join(dir, "restored.db")
+	assert.Nil(t, Restore(exportPath, restoredPath))
+
+	// Then:
+	restored, err := OpenDB(restoredPath)
+	assert.Nil(t, err)
+	defer restored.Close()
+	restoredRepos := NewStormRepositories(restored)
+
+	restoredBookmarks, _ := restoredRepos.Bookmarks.FindByUser(UserID)
+	assert.Equal(t, 2, len(restoredBookmarks))
+	assert.Equal(t, "Peak District", restoredBookmarks[1].GroupName)
+
+	state, err := restoredRepos.States.GetState(UserID)
+	assert.Nil(t, err)
+	assert.Equal(t, restoredBookmarks[1].ID, state.BookmarkID)
+
+	assert.Equal(t, groupByLocation, GetUserSettings(restoredRepos.States, UserID).DigestGroup)
+
+	site, err := restoredRepos.Sites.Get(TestLocationID)
+	assert.Nil(t, err)
+	assert.Equal(t, "London", site.Name)
+
+	// the audit trail and the events are kept too
+	entries, err := restoredRepos.Audit.FindByAction(auditForgetMe)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, 3, entries[0].Bookmarks)
+
+	events, err := restoredRepos.Events.Find(EventFilter{Type: EventAlertSent})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, restoredBookmarks[1].ID, events[0].BookmarkID)
+
+	// and new bookmarks don't replace the restored ones
+	assert.Nil(t, restoredRepos.Bookmarks.Save(&structs.UsersLocationBookmark{UserID: UserID}))
+	restoredBookmarks, _ = restoredRepos.Bookmarks.FindByUser(UserID)
+	assert.Equal(t, 3, len(restoredBookmarks))
+}
+
+func TestRestoreNotStormSnapshot(t *testing.T) {
+
+	// Given: the file that is not a storm database, such as the SQLite backup
+	dir, db := prepareBackupDB(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	notStorm := filepath.Join(dir, "weather-20191108-004000.sqlite")
+	ioutil.WriteFile(notStorm, append([]byte("SQLite format 3\x00"), make([]byte, 8192)...), 0644)
+	restoredPath := filepath.Join(dir, "restored.db")
+
+	// When:
+	err := Restore(notStorm, restoredPath)
+
+	// Then: nothing is left instead of the database
+	assert.NotNil(t, err)
+	_, err = os.Stat(restoredPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// and the real snapshot can be restored there later
+	path, err := Backup(db, filepath.Join(dir, "backups"), 1, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, Restore(path, restoredPath))
+}
+
+func TestRestoreIntoNotEmptyDatabase(t *testing.T) {
+
+	// Given:
+	dir, db := prepareBackupDB(t)
+	defer os.RemoveAll(dir)
+
+	path, err := Backup(db, dir, 1, time.Now())
+	assert.Nil(t, err)
+	db.Close()
+
+	// When: the backup is restored over the database with bookmarks
+	err = Restore(path, filepath.Join(dir, "weather.db"))
+
+	// Then:
+	assert.NotNil(t, err)
+}
+
+// the migrated database with one site, two bookmarks and settings of the user
+func prepareBackupDB(t *testing.T) (string, *storm.DB) {
+	dir, db := prepareFixtureDB(t, func(db *storm.DB) {
+		db.Save(&structs.SiteLocation{ID: TestLocationID, Name: "London"})
+	})
+
+	_, err := Migrate(db)
+	assert.Nil(t, err)
+
+	repos := NewStormRepositories(db)
+	repos.Bookmarks.Save(&structs.UsersLocationBookmark{UserID: UserID, LocationID: TestLocationID, CheckPeriod: AllDays, IsReady: true})
+	repos.Bookmarks.Save(&structs.UsersLocationBookmark{UserID: UserID, LocationID: TestLocationID, GroupName: "Peak District",
+		GroupLocationIDs: []string{TestLocationID, TestLocation2ID}, CheckPeriod: OnlyWeekends, IsReady: true})
+	SaveDigestGrouping(repos.States, UserID, groupByLocation)
+
+	return dir, db
+}
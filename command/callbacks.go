@@ -0,0 +1,133 @@
+package command
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxCallbackDataLength = 64 // Telegram rejects buttons with longer callback data
+	callbackMacLength     = 8  // bytes of HMAC kept in the data, 11 characters in base64
+)
+
+// ErrForgedCallback is returned for callback data that was not signed by this bot, or was changed
+var ErrForgedCallback = errors.New("callback data is not signed or the signature doesn't match")
+
+// the codec of all the buttons; NewApp replaces it with the one using the configured secret
+var callbacks = NewCallbackCodec(randomCallbackSecret())
+
+// CallbackCodec signs callback data of inline buttons. Telegram clients can send any data for a button,
+// so without the signature anyone could click "delete bookmark" with the ID of somebody else's bookmark.
+// The data is "<fields separated by #>#<truncated HMAC in base64>", for example "dB#42#3q2-7wAbCdE"
+type CallbackCodec struct {
+	secret []byte
+}
+
+// NewCallbackCodec creates the codec; the secret should be the same for all the instances of the bot and
+// should survive restarts, otherwise buttons of old messages stop working
+func NewCallbackCodec(secret []byte) *CallbackCodec {
+	return &CallbackCodec{secret: secret}
+}
+
+// SetCallbackSecret makes all the buttons signed with the given secret. If it is empty, the secret is derived
+// from the bot token, so buttons of old messages keep working after restarts until the token is changed
+func SetCallbackSecret(secret, botToken string) {
+	if len(secret) == 0 {
+		callbacks = NewCallbackCodec(tokenCallbackSecret(botToken))
+		return
+	}
+	callbacks = NewCallbackCodec([]byte(secret))
+}
+
+// Encode joins the fields and signs them
+func (c *CallbackCodec) Encode(fields ...string) (string, error) {
+	for _, field := range fields {
+		if strings.Contains(field, Separator) {
+			return "", fmt.Errorf("the field %q of callback data contains the separator", field)
+		}
+	}
+
+	payload := strings.Join(fields, Separator)
+	data := payload + Separator + c.sign(payload)
+	if len(data) > maxCallbackDataLength {
+		return "", fmt.Errorf("callback data %q is %d bytes long, but Telegram accepts %d only", data, len(data), maxCallbackDataLength)
+	}
+	return data, nil
+}
+
+// Decode checks the signature and returns the fields; the result always has at least two of them
+func (c *CallbackCodec) Decode(data string) ([]string, error) {
+	i := strings.LastIndex(data, Separator)
+	if i < 0 {
+		return nil, ErrForgedCallback
+	}
+
+	payload, mac := data[:i], data[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(c.sign(payload))) {
+		return nil, ErrForgedCallback
+	}
+
+	// every button has the prefix and at least one value
+	fields := strings.Split(payload, Separator)
+	if len(fields) < 2 {
+		return nil, ErrForgedCallback
+	}
+	return fields, nil
+}
+
+func (c *CallbackCodec) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackMacLength])
+}
+
+// callbackData is the signed data for a button; errors are the bugs of the code, so they are reported and the
+// data is empty then, newInlineKeyboard drops such buttons
+func callbackData(fields ...string) string {
+	data, err := callbacks.Encode(fields...)
+	if err != nil {
+		sentry.CaptureException(err)
+	}
+	return data
+}
+
+// newInlineKeyboard is tgbotapi.NewInlineKeyboardMarkup without the buttons that have no data, because Telegram
+// rejects the whole keyboard with such a button. Buttons that open an inline query have no data at all and are kept
+func newInlineKeyboard(rows ...[]tgbotapi.InlineKeyboardButton) tgbotapi.InlineKeyboardMarkup {
+	keyboard := make([][]tgbotapi.InlineKeyboardButton, 0, len(rows))
+	for _, row := range rows {
+		var kept []tgbotapi.InlineKeyboardButton
+		for _, button := range row {
+			if button.CallbackData != nil && len(*button.CallbackData) == 0 {
+				continue
+			}
+			kept = append(kept, button)
+		}
+		if len(kept) > 0 {
+			keyboard = append(keyboard, kept)
+		}
+	}
+	return tgbotapi.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+// the token is a secret too, but it is not used as the key as it is, so the signatures don't reveal it
+func tokenCallbackSecret(botToken string) []byte {
+	secret := sha256.Sum256([]byte("callback data of " + botToken))
+	return secret[:]
+}
+
+func randomCallbackSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
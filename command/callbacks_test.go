@@ -0,0 +1,153 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+func TestCallbackCodecRoundTrip(t *testing.T) {
+
+	// Given:
+	codec := NewCallbackCodec([]byte("secret"))
+
+	for _, fields := range [][]string{
+		{ButtonDeleteBookmark, "42"},
+		{ButtonEditBookmark, "42", "run", "3"},
+		{ButtonDaysPrefix, "310007", "2019-11-08Z", "2147483647"},
+		{ButtonWizardAnswer, strings.Repeat("k", maxProfileKeyLength)},
+	} {
+		t.Run(strings.Join(fields, " "), func(t *testing.T) {
+
+			// When:
+			data, err := codec.Encode(fields...)
+			assert.Nil(t, err)
+
+			// Then:
+			assert.True(t, len(data) <= maxCallbackDataLength, data)
+			assert.True(t, strings.HasPrefix(data, strings.Join(fields, Separator)+Separator))
+
+			decoded, err := codec.Decode(data)
+			assert.Nil(t, err)
+			assert.Equal(t, fields, decoded)
+		})
+	}
+}
+
+func TestCallbackCodecRejectsForgedData(t *testing.T) {
+
+	// Given:
+	codec := NewCallbackCodec([]byte("secret"))
+	genuine, err := codec.Encode(ButtonDeleteBookmark, "42")
+	assert.Nil(t, err)
+	mac := genuine[strings.LastIndex(genuine, Separator)+1:]
+
+	otherSecret, _ := NewCallbackCodec([]byte("other secret")).Encode(ButtonDeleteBookmark, "43")
+
+	for _, tt := range []struct {
+		name string
+		data string
+	}{
+		{"old unsigned button", "dB#42"},
+		{"another bookmark ID with the genuine signature", "dB#43#" + mac},
+		{"another action with the genuine signature", "E#42#" + mac},
+		{"extra field", "dB#42#1#" + mac},
+		{"changed signature", "dB#42#" + strings.Repeat("A", len(mac))},
+		{"truncated signature", genuine[:len(genuine)-1]},
+		{"signed with another secret", otherSecret},
+		{"no separator", "dB"},
+		{"empty", ""},
+		{"only the signature", Separator + mac},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// When:
+			fields, err := codec.Decode(tt.data)
+
+			// Then:
+			assert.Equal(t, ErrForgedCallback, err)
+			assert.Nil(t, fields)
+		})
+	}
+}
+
+func TestCallbackCodecRejectsBadFields(t *testing.T) {
+	codec := NewCallbackCodec([]byte("secret"))
+
+	_, err := codec.Encode(ButtonWizardAnswer, "a"+Separator+"b")
+	assert.NotNil(t, err)
+
+	_, err = codec.Encode(ButtonWizardAnswer, strings.Repeat("k", maxCallbackDataLength))
+	assert.NotNil(t, err)
+}
+
+func TestInlineKeyboardDropsBadButtons(t *testing.T) {
+
+	// Given: the location ID with the separator and the data that is too long
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		{
+			tgbotapi.NewInlineKeyboardButtonData("good", callbackData(ButtonLocationPrefix, TestLocationID)),
+			tgbotapi.NewInlineKeyboardButtonData("separator", callbackData(ButtonLocationPrefix, "1"+Separator+"2")),
+		},
+		{tgbotapi.NewInlineKeyboardButtonData("too long", callbackData(ButtonLocationPrefix, strings.Repeat("1", maxCallbackDataLength)))},
+		{*renderKeyboardButtonActivateQuery("search")},
+	}
+
+	// When:
+	keyboard := newInlineKeyboard(rows...)
+
+	// Then: only the bad buttons are dropped, with the row left empty
+	assert.Equal(t, 2, len(keyboard.InlineKeyboard))
+	assert.Equal(t, 1, len(keyboard.InlineKeyboard[0]))
+	assert.Equal(t, "good", keyboard.InlineKeyboard[0][0].Text)
+	assert.Equal(t, "search", keyboard.InlineKeyboard[1][0].Text)
+}
+
+func TestCallbackSecretFromToken(t *testing.T) {
+	defer func(codec *CallbackCodec) { callbacks = codec }(callbacks)
+
+	// Given: the button sent before the restart
+	SetCallbackSecret("", "123:token")
+	data := callbackData(ButtonDeleteBookmark, "42")
+
+	// When: the bot is restarted without the secret
+	SetCallbackSecret("", "123:token")
+
+	// Then: the button still works
+	fields, err := callbacks.Decode(data)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ButtonDeleteBookmark, "42"}, fields)
+
+	// but not with another token
+	SetCallbackSecret("", "456:token")
+	_, err = callbacks.Decode(data)
+	assert.Equal(t, ErrForgedCallback, err)
+}
+
+func TestDeleteOneBookmarkChecksOwner(t *testing.T) {
+
+	// Given:
+	repos := prepareRepositories()
+	mine := structs.UsersLocationBookmark{UserID: UserID, LocationID: TestLocationID, IsReady: true}
+	foreign := structs.UsersLocationBookmark{UserID: User2ID, LocationID: TestLocationID, IsReady: true}
+	assert.Nil(t, repos.Bookmarks.Save(&mine))
+	assert.Nil(t, repos.Bookmarks.Save(&foreign))
+
+	// When: the user clicks "stop observing" with the ID of the bookmark of somebody else
+	deleteOneBookmark(nil, repos, UserID, UserID, strconv.Itoa(foreign.ID))
+
+	// Then: it is still there
+	_, err := repos.Bookmarks.Get(foreign.ID)
+	assert.Nil(t, err)
+
+	// and when: the own one
+	deleteOneBookmark(nil, repos, UserID, UserID, strconv.Itoa(mine.ID))
+
+	// Then:
+	_, err = repos.Bookmarks.Get(mine.ID)
+	assert.Equal(t, ErrNotFound, err)
+}
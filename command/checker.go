@@ -1,44 +1,102 @@
 package command
 
 import (
-	"bytes"
-	"fmt"
-	"github.com/asdine/storm/q"
 	"github.com/getsentry/sentry-go"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/w32blaster/bot-weather-watcher/structs"
 
-	"github.com/asdine/storm"
-	"github.com/asdine/storm/codec/msgpack"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/pkg/errors"
 )
 
 const precipProbRain = 40 // min precipitation probability when we assume that will be rainy day
 
-func CheckWeather(bot *tgbotapi.BotAPI, opts *structs.Opts, userID int) bool {
+// CheckWeather checks the forecast for all the bookmarks (or bookmarks of one user, if userID is not -1),
+// sends good days and warnings to users and returns the report with all the decisions
+func CheckWeather(bot *tgbotapi.BotAPI, repos Repositories, opts *structs.Opts, userID int) (CheckReport, error) {
 
-	db, err := storm.Open(DbPath, storm.Codec(msgpack.Codec))
+	locations, err := repos.Bookmarks.FindReady(userID)
 	if err != nil {
 		sentry.CaptureException(err)
-		return false
+		return CheckReport{}, errors.New("can't load bookmarks from the database")
 	}
-	defer db.Close()
 
-	locations, ok := getBookmarksFromDatabase(db, userID)
-	if !ok {
-		return false
+	// load locations, build a map
+	mapLocs := getMapOfLocations(locations, repos.Sites)
+	provider := MetOfficeProvider{Opts: opts}
+
+	report := BuildCheckReport(locations, mapLocs, provider)
+	logReportToSentry(report, mapLocs)
+	recordCheckedDays(repos, report)
+	deliverReport(bot, repos, report, provider)
+
+	return report, nil
+}
+
+// sends everything that was found: digests with good days, UV and bad weather warnings
+func deliverReport(bot *tgbotapi.BotAPI, repos Repositories, report CheckReport, provider ForecastProvider) {
+	goodDays := report.goodDays()
+
+	// send one digest per chat instead of a message per every bookmark
+	for _, d := range groupDigestsByChat(goodDays) {
+		settings := GetUserSettings(repos.States, d.userID)
+		sendDigest(bot, d, settings.DigestGroup)
+	}
+	recordAlerts(repos, "digest", goodDays)
+
+	// and warn about strong sun on the good days, if user asked for it
+	uvWarnings := collectUVWarnings(goodDays, provider)
+	sendUVWarnings(bot, uvWarnings)
+	uvDays := make([]goodDay, len(uvWarnings))
+	for i, warning := range uvWarnings {
+		uvDays[i] = warning.day
 	}
+	recordAlerts(repos, "uv", uvDays)
 
-	// load locations, build a map
-	mapLocs := getMapOfLocations(locations, db)
+	// bad weather warnings are sent separately, so they are not lost among the good news
+	hazardDays := report.hazardDays()
+	sendHazardWarnings(bot, hazardDays)
+	recordAlerts(repos, "hazard", hazardDays)
+}
+
+// records one event per sent day, for example "digest 2019-11-09"
+func recordAlerts(repos Repositories, kind string, days []goodDay) {
+	events := make([]structs.Event, len(days))
+	for i, day := range days {
+		events[i] = structs.Event{Type: EventAlertSent, UserID: day.bookmark.UserID, BookmarkID: day.bookmark.ID,
+			LocationID: day.bookmark.LocationID, Details: kind + " " + day.date.Format("2006-01-02")}
+	}
+	RecordEvents(repos, events)
+}
+
+// records whether every checked day was good enough, instead of sending them all to Sentry. There is an event
+// per every day of every site, so they are saved at once
+func recordCheckedDays(repos Repositories, report CheckReport) {
+	var events []structs.Event
+	for _, bookmark := range report.Bookmarks {
+		for _, site := range bookmark.Sites {
+			for _, evaluation := range site.Days {
+				if evaluation.Skipped {
+					continue
+				}
+
+				verdict := "not suitable"
+				if evaluation.IsSuitable {
+					verdict = "suitable"
+				}
+				events = append(events, structs.Event{Type: EventDayChecked, UserID: bookmark.bookmark.UserID, BookmarkID: bookmark.bookmark.ID,
+					LocationID: site.LocationID, Details: evaluation.Date + " " + verdict})
+			}
+		}
+	}
+	RecordEvents(repos, events)
+}
 
-	var buffer bytes.Buffer
-	wasFoundSomething := false
-	for _, loc := range locations {
+func logReportToSentry(report CheckReport, mapLocs map[string]structs.SiteLocation) {
+	for _, bookmark := range report.Bookmarks {
+		loc := bookmark.bookmark
 
 		sentry.CurrentHub().PushScope()
 		sentry.ConfigureScope(func(scope *sentry.Scope) {
@@ -53,150 +111,138 @@ func CheckWeather(bot *tgbotapi.BotAPI, opts *structs.Opts, userID int) bool {
 			scope.RemoveExtra("raw-text")
 		})
 
-		forecast, err := getDailyForecastFor(loc.LocationID, opts)
-		if err != nil {
-			sentry.CaptureException(err)
-			continue
-		}
-
-		// iterate over days
-		for _, day := range forecast.SiteRep.Dv.Location.Periods {
-
-			// parse date
-			t, err := time.Parse(layoutMetofficeDate, day.Value)
-			if err != nil {
-				sentry.CaptureException(errors.Wrap(err, "Can't parse date from the bookmark, this day is ignored from checking"))
-				continue
+		for _, site := range bookmark.Sites {
+			if site.err != nil {
+				if len(site.Days) > 0 {
+					sentry.CaptureException(errors.Wrap(site.err, "Can't parse date from the bookmark, this day is ignored from checking"))
+				} else {
+					sentry.CaptureException(site.err)
+				}
 			}
-
-			if !shouldBotherForWeekdays(loc.CheckPeriod, t.Weekday()) {
-				continue
-			}
-
-			feelsLikeDayTemp, windNoon, precProbab, weatherType := parseNumberFigures(day)
-
-			// decide whether current weather is that "good" or "naaah"
-			isSuitableWeather := feelsLikeDayTemp > loc.LowestTemp &&
-				windNoon < loc.MaxWindSpeed &&
-				precProbab < precipProbRain
-
-			logEventToSentry(loc, day, forecast, feelsLikeDayTemp, windNoon, precProbab, isSuitableWeather)
-
-			if isSuitableWeather {
-				buffer.WriteString(
-					fmt.Sprintf(" - %c in %s at %s (day temp %d˚C, wind is %dmph and precipitation probability is %d%%) \n",
-						mapWeatherTypes[weatherType].icon,
-						strings.Title(strings.ToLower(forecast.SiteRep.Dv.Location.Name)),
-						t.Format("02 Jan 2006, Mon"),
-						feelsLikeDayTemp,
-						windNoon,
-						precProbab),
-				)
-			}
-
-			// just to avoid any dDOS filters block us :) we are not in a hurry
-			time.Sleep(1 * time.Second)
-		}
-
-		if buffer.Len() > 0 {
-			msg, _ := sendMsg(bot, loc.ChatID, "Hey, good weather will be at: \n\n"+buffer.String())
-			renderButtonDeleteBookmark(bot, loc.ChatID, msg.MessageID, loc.ID, mapLocs[loc.LocationID].Name)
-			wasFoundSomething = true
 		}
 
-		buffer.Reset()
 		sentry.CurrentHub().PopScope()
 	}
-
-	return wasFoundSomething
 }
 
-func logEventToSentry(loc structs.UsersLocationBookmark, day structs.Period, forecast *structs.RootSiteRep, feelsLikeDayTemp, windNoon, precProbab int, isSuitableWeather bool) {
-	event := sentry.NewEvent()
-	event.Message = "Checker was called the forecast"
-	event.Timestamp = time.Now().UTC().Unix()
-	event.Tags = map[string]string{
-		"action": "check-weather-nightly",
-	}
-	event.User = sentry.User{
-		ID: strconv.Itoa(loc.UserID),
-	}
-	event.Level = sentry.LevelInfo
-	event.Extra = map[string]interface{}{
-		"date":                   day.Value,
-		"bookmark-owner-id":      loc.UserID,
-		"bookmark-owner":         loc.UserName,
-		"bookmark-location":      forecast.SiteRep.Dv.Location.Name,
-		"temp-feels-like":        feelsLikeDayTemp,
-		"temp-min-desired":       loc.LowestTemp,
-		"wind-speed":             windNoon,
-		"wind-speed-max-desired": loc.MaxWindSpeed,
-		"precip-prob":            precProbab,
-		"is-suitable":            isSuitableWeather,
+func newGoodDay(loc structs.UsersLocationBookmark, site structs.SiteLocation, evaluation DayEvaluation) goodDay {
+	return goodDay{
+		bookmark:    loc,
+		site:        site,
+		date:        evaluation.date,
+		temp:        evaluation.Temperature,
+		wind:        evaluation.Wind,
+		precip:      evaluation.PrecipProb,
+		weatherType: evaluation.WeatherType,
+		uv:          evaluation.UV,
+		moon:        evaluation.Moon,
 	}
-	sentry.CaptureEvent(event)
 }
 
-func parseNumberFigures(day structs.Period) (int, int, int, int) {
+// figures from the daily forecast (day part) that the checker looks at
+type dayFigures struct {
+	feelsLikeTemp int    // FDm
+	maxTemp       int    // Dm
+	wind          int    // S
+	gust          int    // Gn
+	humidity      int    // Hn
+	precipProb    int    // PPd
+	weatherType   int    // W
+	uv            int    // U
+	direction     string // D
+	visibility    string // V
+}
 
-	var feelsLikeDayTemp, windNoon, precProbab int
-	weatherType := 4 // default value is "not used"
+// figures from the daily forecast (night part) that the checker looks at
+type nightFigures struct {
+	minTemp     int    // Nm
+	wind        int    // S
+	gust        int    // Gm
+	humidity    int    // Hm
+	precipProb  int    // PPn
+	weatherType int    // W
+	visibility  string // V
+}
 
-	if intFrm, err := strconv.Atoi(day.Rep[0]["FDm"]); err == nil {
-		feelsLikeDayTemp = intFrm
-	}
-	if intGn, err := strconv.Atoi(day.Rep[0]["Gn"]); err == nil {
-		windNoon = intGn
-	}
-	if intPpd, err := strconv.Atoi(day.Rep[0]["PPd"]); err == nil {
-		precProbab = intPpd
+func parseNightFigures(day structs.Period) nightFigures {
+
+	figures := nightFigures{
+		weatherType: 4, // default value is "not used"
+		visibility:  day.Rep[1]["V"],
 	}
-	if intWt, err := strconv.Atoi(day.Rep[0]["W"]); err == nil {
-		weatherType = intWt
+
+	for key, field := range map[string]*int{
+		"Nm":  &figures.minTemp,
+		"S":   &figures.wind,
+		"Gm":  &figures.gust,
+		"Hm":  &figures.humidity,
+		"PPn": &figures.precipProb,
+		"W":   &figures.weatherType,
+	} {
+		if intValue, err := strconv.Atoi(day.Rep[1][key]); err == nil {
+			*field = intValue
+		}
 	}
-	return feelsLikeDayTemp, windNoon, precProbab, weatherType
+
+	return figures
 }
 
-func getBookmarksFromDatabase(db *storm.DB, userID int) ([]structs.UsersLocationBookmark, bool) {
+func parseNumberFigures(day structs.Period) dayFigures {
+
+	figures := dayFigures{
+		weatherType: 4, // default value is "not used"
+		direction:   day.Rep[0]["D"],
+		visibility:  day.Rep[0]["V"],
+	}
 
-	var locations []structs.UsersLocationBookmark
-	var err error
-	if userID == -1 {
+	for key, field := range map[string]*int{
+		"FDm": &figures.feelsLikeTemp,
+		"Dm":  &figures.maxTemp,
+		"S":   &figures.wind,
+		"Gn":  &figures.gust,
+		"Hn":  &figures.humidity,
+		"PPd": &figures.precipProb,
+		"W":   &figures.weatherType,
+		"U":   &figures.uv,
+	} {
+		if intValue, err := strconv.Atoi(day.Rep[0][key]); err == nil {
+			*field = intValue
+		}
+	}
 
-		// find all ready bookmarks
-		err = db.Find("IsReady", true, &locations)
-	} else {
+	return figures
+}
 
-		// find all the bookrmarks for the given user
-		err = db.Select(q.And(
-			q.Eq("UserID", userID),
-			q.Eq("IsReady", true),
-		)).Find(&locations)
+// the same figures, but from one 3-hour step of the 3-hourly forecast
+func parseStepFigures(step map[string]string) dayFigures {
+
+	figures := dayFigures{
+		weatherType: 4, // default value is "not used"
+		direction:   step["D"],
+		visibility:  step["V"],
 	}
 
-	if err != nil {
-		sentry.CaptureException(err)
-		return nil, false
+	for key, field := range map[string]*int{
+		"F":  &figures.feelsLikeTemp,
+		"T":  &figures.maxTemp,
+		"S":  &figures.wind,
+		"G":  &figures.gust,
+		"H":  &figures.humidity,
+		"Pp": &figures.precipProb,
+		"W":  &figures.weatherType,
+		"U":  &figures.uv,
+	} {
+		if intValue, err := strconv.Atoi(step[key]); err == nil {
+			*field = intValue
+		}
 	}
 
-	return locations, true
+	return figures
 }
 
 // shortcut function, checks should we bother a customer in a specific day depending on his/her
 // preferences
 // Please refer to unit tests
 func shouldBotherForWeekdays(dayChoice int, weekday time.Weekday) bool {
-	return !(dayChoice == onlyWeekends && weekday != time.Friday && weekday != time.Saturday && weekday != time.Sunday)
-}
-
-// renders the button row with days for detailed forecast
-func renderButtonDeleteBookmark(bot *tgbotapi.BotAPI, chatID int64, messageID int, bookmarkID int, locationName string) {
-
-	rowCloseButton := []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("❌ Stop observing "+locationName, ButtonDeleteBookmark+Separator+strconv.Itoa(bookmarkID)),
-	}
-
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(rowCloseButton)
-	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, keyboard)
-	bot.Send(keyboardMsg)
+	return !(dayChoice == OnlyWeekends && weekday != time.Friday && weekday != time.Saturday && weekday != time.Sunday)
 }
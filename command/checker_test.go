@@ -1,11 +1,15 @@
 package command
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
 )
 
 func TestWeekdayBother(t *testing.T) {
@@ -15,21 +19,21 @@ func TestWeekdayBother(t *testing.T) {
 		weekday        time.Weekday
 		expectedResult bool
 	}{
-		{onlyWeekends, time.Monday, false},
-		{onlyWeekends, time.Tuesday, false},
-		{onlyWeekends, time.Wednesday, false},
-		{onlyWeekends, time.Thursday, false},
-		{onlyWeekends, time.Friday, true}, // because Friday is almost not working day in fact :)
-		{onlyWeekends, time.Saturday, true},
-		{onlyWeekends, time.Sunday, true},
+		{OnlyWeekends, time.Monday, false},
+		{OnlyWeekends, time.Tuesday, false},
+		{OnlyWeekends, time.Wednesday, false},
+		{OnlyWeekends, time.Thursday, false},
+		{OnlyWeekends, time.Friday, true}, // because Friday is almost not working day in fact :)
+		{OnlyWeekends, time.Saturday, true},
+		{OnlyWeekends, time.Sunday, true},
 
-		{allDays, time.Monday, true},
-		{allDays, time.Tuesday, true},
-		{allDays, time.Wednesday, true},
-		{allDays, time.Thursday, true},
-		{allDays, time.Friday, true},
-		{allDays, time.Saturday, true},
-		{allDays, time.Sunday, true},
+		{AllDays, time.Monday, true},
+		{AllDays, time.Tuesday, true},
+		{AllDays, time.Wednesday, true},
+		{AllDays, time.Thursday, true},
+		{AllDays, time.Friday, true},
+		{AllDays, time.Saturday, true},
+		{AllDays, time.Sunday, true},
 	}
 
 	for _, tt := range dataSet {
@@ -45,3 +49,772 @@ func TestWeekdayBother(t *testing.T) {
 	}
 
 }
+
+func TestEvaluateBookmark(t *testing.T) {
+
+	// Given: the bookmark saved before the sustained wind was checked, with the gust limit of the migration 3
+	forecast := loadDailyForecast(t)
+	bookmark := structs.UsersLocationBookmark{
+		LocationID:   TestLocationID,
+		MaxWindSpeed: 20,
+		MaxGust:      intPtr(19),
+		LowestTemp:   10,
+		CheckPeriod:  OnlyWeekends,
+	}
+
+	// When:
+	evaluations, err := EvaluateBookmark(bookmark, forecast)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(evaluations))
+
+	// Thursday and Monday are not checked at all
+	assert.True(t, evaluations[0].Skipped)
+	assert.True(t, evaluations[4].Skipped)
+
+	// Friday is too windy, as it was before, but the rest is fine
+	assert.False(t, evaluations[1].IsSuitable)
+	assert.Equal(t, "gust", evaluations[1].Criteria[3].Name)
+	assert.Equal(t, "20", evaluations[1].Criteria[3].Value)
+	assert.False(t, evaluations[1].Criteria[3].Passed)
+	assert.True(t, evaluations[1].Criteria[0].Passed)
+	assert.True(t, evaluations[1].Criteria[1].Passed)
+	assert.True(t, evaluations[1].Criteria[2].Passed)
+
+	// and the weekend is good
+	assert.True(t, evaluations[2].IsSuitable)
+	assert.Equal(t, "2019-10-12", evaluations[2].Date)
+	assert.Equal(t, 14, evaluations[2].Temperature)
+	assert.True(t, evaluations[3].IsSuitable)
+}
+
+func loadDailyForecast(t *testing.T) *structs.RootSiteRep {
+	bytes, err := ioutil.ReadFile("../api-examples/daily/3066.json")
+	assert.Nil(t, err)
+
+	var forecast structs.RootSiteRep
+	assert.Nil(t, json.Unmarshal(bytes, &forecast))
+	return &forecast
+}
+
+func TestWeatherTypeExcluded(t *testing.T) {
+
+	var dataSet = []struct {
+		excluded       int
+		weatherType    int
+		expectedResult bool
+	}{
+		{0, 6, false},
+		{excludeFog, 5, true},
+		{excludeFog, 6, true},
+		{excludeFog, 7, false},
+		{excludeOvercast, 8, true},
+		{excludeShowers | excludeThunder, 14, true},
+		{excludeShowers | excludeThunder, 29, true},
+		{excludeShowers | excludeThunder, 15, false}, // heavy rain is not a shower, it is checked by precipitation probability
+		{excludeSnow, 27, true},
+		{excludeSnow, 1, false},
+	}
+
+	for _, tt := range dataSet {
+		t.Run(fmt.Sprintf("Is weather type %d excluded by %b", tt.weatherType, tt.excluded),
+			func(t *testing.T) {
+
+				// When:
+				result := isWeatherTypeExcluded(tt.excluded, tt.weatherType)
+
+				// Then:
+				assert.Equal(t, tt.expectedResult, result)
+			})
+	}
+}
+
+func TestWeatherCategoryFlags(t *testing.T) {
+
+	var dataSet = []struct {
+		names         []string
+		expectedFlags int
+		isError       bool
+	}{
+		{nil, 0, false},
+		{[]string{"fog", "Snow"}, excludeFog | excludeSnow, false},
+		{[]string{"Fog/mist"}, excludeFog, false},
+		{[]string{"hail"}, 0, true},
+	}
+
+	for _, tt := range dataSet {
+		t.Run(fmt.Sprintf("Flags of %v", tt.names),
+			func(t *testing.T) {
+
+				// When:
+				flags, err := WeatherCategoryFlags(tt.names)
+
+				// Then:
+				assert.Equal(t, tt.isError, err != nil)
+				assert.Equal(t, tt.expectedFlags, flags)
+			})
+	}
+}
+
+func TestEvaluateBookmarkWithExcludedWeather(t *testing.T) {
+
+	// Given:
+	forecast := loadDailyForecast(t)
+	bookmark := structs.UsersLocationBookmark{
+		LocationID:      TestLocationID,
+		MaxWindSpeed:    20,
+		LowestTemp:      10,
+		CheckPeriod:     OnlyWeekends,
+		ExcludedWeather: excludeFog,
+	}
+
+	// When:
+	evaluations, err := EvaluateBookmark(bookmark, forecast)
+
+	// Then:
+	assert.Nil(t, err)
+
+	// Saturday is sunny
+	assert.True(t, evaluations[2].IsSuitable)
+
+	// but Sunday is foggy, so not good anymore
+	assert.False(t, evaluations[3].IsSuitable)
+	assert.Equal(t, "weather", evaluations[3].Criteria[3].Name)
+	assert.Equal(t, "Fog", evaluations[3].Criteria[3].Value)
+	assert.False(t, evaluations[3].Criteria[3].Passed)
+}
+
+func TestDirectionSectors(t *testing.T) {
+
+	var dataSet = []struct {
+		compass         string
+		expectedSectors int
+	}{
+		{"N", sectorN},
+		{"NNE", sectorN | sectorNE}, // on the border, so belongs to both
+		{"NE", sectorNE},
+		{"WSW", sectorSW | sectorW},
+		{"NW", sectorNW},
+		{"NNW", sectorNW | sectorN},
+		{"", 0},
+		{"XYZ", 0},
+	}
+
+	for _, tt := range dataSet {
+		t.Run(fmt.Sprintf("Sectors of %s", tt.compass),
+			func(t *testing.T) {
+
+				// When:
+				result := directionSectors(tt.compass)
+
+				// Then:
+				assert.Equal(t, tt.expectedSectors, result)
+			})
+	}
+}
+
+func TestWindDirectionFlags(t *testing.T) {
+
+	var dataSet = []struct {
+		names         []string
+		expectedFlags int
+		isError       bool
+	}{
+		{nil, 0, false},
+		{[]string{"N", "ne"}, sectorN | sectorNE, false},
+		{[]string{"NNE"}, 0, true}, // only the sectors of the compass rose
+	}
+
+	for _, tt := range dataSet {
+		t.Run(fmt.Sprintf("Flags of %v", tt.names),
+			func(t *testing.T) {
+
+				// When:
+				flags, err := WindDirectionFlags(tt.names)
+
+				// Then:
+				assert.Equal(t, tt.isError, err != nil)
+				assert.Equal(t, tt.expectedFlags, flags)
+			})
+	}
+}
+
+func TestEvaluateBookmarkWithWindDirections(t *testing.T) {
+
+	// Given:
+	forecast := loadDailyForecast(t)
+	bookmark := structs.UsersLocationBookmark{
+		LocationID:     TestLocationID,
+		MaxWindSpeed:   20,
+		LowestTemp:     10,
+		CheckPeriod:    OnlyWeekends,
+		WindDirections: sectorN | sectorNE,
+	}
+
+	// When:
+	evaluations, err := EvaluateBookmark(bookmark, forecast)
+
+	// Then:
+	assert.Nil(t, err)
+
+	// Saturday wind comes from NW, that is not what we want
+	assert.False(t, evaluations[2].IsSuitable)
+	assert.Equal(t, "NW", evaluations[2].Criteria[3].Value)
+	assert.False(t, evaluations[2].Criteria[3].Passed)
+
+	// but on Sunday it is northern
+	assert.True(t, evaluations[3].IsSuitable)
+}
+
+func TestEvaluateBookmarkWithOptionalBounds(t *testing.T) {
+
+	// Given:
+	forecast := loadDailyForecast(t)
+	maxGust, maxTemp := 15, 14
+	bookmark := structs.UsersLocationBookmark{
+		LocationID:   TestLocationID,
+		MaxWindSpeed: 20,
+		LowestTemp:   10,
+		CheckPeriod:  AllDays,
+		MaxGust:      &maxGust,
+	}
+
+	// When:
+	evaluations, err := EvaluateBookmark(bookmark, forecast)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(evaluations[2].Criteria))
+
+	// Saturday gusts are 13 mph
+	assert.True(t, evaluations[2].IsSuitable)
+
+	// but on Sunday gusts are 16 mph, even though the sustained wind is only 9 mph
+	assert.False(t, evaluations[3].IsSuitable)
+	assert.Equal(t, "gust", evaluations[3].Criteria[3].Name)
+	assert.True(t, evaluations[3].Criteria[1].Passed)
+
+	// When:
+	bookmark.MaxTemp = &maxTemp
+	evaluations, err = EvaluateBookmark(bookmark, forecast)
+
+	// Then: Saturday is too hot now, 15˚C
+	assert.Nil(t, err)
+	assert.False(t, evaluations[2].IsSuitable)
+	assert.Equal(t, "max temperature", evaluations[2].Criteria[4].Name)
+	assert.False(t, evaluations[2].Criteria[4].Passed)
+}
+
+func TestEvaluateBookmarkWithoutOptionalBounds(t *testing.T) {
+
+	// Given: the bookmark saved before optional bounds appeared
+	forecast := loadDailyForecast(t)
+	bookmark := structs.UsersLocationBookmark{
+		LocationID:   TestLocationID,
+		MaxWindSpeed: 20,
+		LowestTemp:   10,
+		CheckPeriod:  AllDays,
+	}
+
+	// When:
+	evaluations, err := EvaluateBookmark(bookmark, forecast)
+
+	// Then: only the basic criteria are checked
+	assert.Nil(t, err)
+	for _, evaluation := range evaluations {
+		assert.Equal(t, 3, len(evaluation.Criteria))
+	}
+}
+
+func TestPeakUVHours(t *testing.T) {
+
+	// Given:
+	bytes, err := ioutil.ReadFile("../api-examples/example-5-day-forecast-aerodrome.json")
+	assert.Nil(t, err)
+	var forecast structs.RootSiteRep
+	assert.Nil(t, json.Unmarshal(bytes, &forecast))
+	day := forecast.SiteRep.Dv.Location.Periods[0] // UV: 1 at 9am, 3 at 12am, 1 at 3pm, then 0
+
+	var dataSet = []struct {
+		level         int
+		expectedHours string
+	}{
+		{1, "09:00–18:00"},
+		{2, "12:00–15:00"},
+		{3, "12:00–15:00"},
+		{4, ""},
+	}
+
+	for _, tt := range dataSet {
+		t.Run(fmt.Sprintf("Peak hours for UV level %d", tt.level),
+			func(t *testing.T) {
+
+				// When:
+				result := peakUVHours(day, tt.level)
+
+				// Then:
+				assert.Equal(t, tt.expectedHours, result)
+			})
+	}
+}
+
+func TestPeakUVHoursWithGap(t *testing.T) {
+
+	// Given:
+	day := structs.Period{
+		Rep: []map[string]string{
+			{"$": "540", "U": "6"},
+			{"$": "720", "U": "4"},
+			{"$": "900", "U": "6"},
+			{"$": "1080", "U": "1"},
+		},
+	}
+
+	// When:
+	result := peakUVHours(day, 5)
+
+	// Then:
+	assert.Equal(t, "09:00–12:00, 15:00–18:00", result)
+}
+
+func TestUVBand(t *testing.T) {
+	assert.Equal(t, "low", uvBand(2))
+	assert.Equal(t, "moderate", uvBand(3))
+	assert.Equal(t, "high", uvBand(7))
+	assert.Equal(t, "very high", uvBand(8))
+	assert.Equal(t, "extreme", uvBand(11))
+}
+
+// returns the same forecast for any location
+type fakeForecastProvider struct {
+	daily       *structs.RootSiteRep
+	threeHourly *structs.RootSiteRep
+}
+
+func (p fakeForecastProvider) DailyForecast(locationID string) (*structs.RootSiteRep, error) {
+	return p.daily, nil
+}
+
+func (p fakeForecastProvider) ThreeHourlyForecast(locationID string) (*structs.RootSiteRep, error) {
+	return p.threeHourly, nil
+}
+
+func TestCollectUVWarnings(t *testing.T) {
+
+	// Given:
+	bytes, err := ioutil.ReadFile("../api-examples/example-5-day-forecast-aerodrome.json")
+	assert.Nil(t, err)
+	var forecast structs.RootSiteRep
+	assert.Nil(t, json.Unmarshal(bytes, &forecast))
+
+	level := 2
+	bookmark := structs.UsersLocationBookmark{ID: 1, LocationID: TestLocationID, UVWarnLevel: &level}
+	days := []goodDay{
+		{bookmark: bookmark, date: time.Date(2019, 9, 27, 0, 0, 0, 0, time.UTC), uv: 3},
+		{bookmark: bookmark, date: time.Date(2019, 9, 28, 0, 0, 0, 0, time.UTC), uv: 1},                             // too low
+		{bookmark: structs.UsersLocationBookmark{ID: 2}, date: time.Date(2019, 9, 29, 0, 0, 0, 0, time.UTC), uv: 9}, // not asked
+	}
+
+	// When:
+	warnings := collectUVWarnings(days, fakeForecastProvider{threeHourly: &forecast})
+
+	// Then:
+	assert.Equal(t, 1, len(warnings))
+	assert.Equal(t, 3, warnings[0].day.uv)
+	assert.Equal(t, "12:00–15:00", warnings[0].peakHours)
+}
+
+func TestEvaluateBookmarkWithMinVisibility(t *testing.T) {
+
+	// Given:
+	forecast := loadDailyForecast(t)
+	bookmark := structs.UsersLocationBookmark{
+		LocationID:    TestLocationID,
+		MaxWindSpeed:  20,
+		LowestTemp:    10,
+		CheckPeriod:   OnlyWeekends,
+		MinVisibility: visibilityLevel("VG"),
+	}
+
+	// When:
+	evaluations, err := EvaluateBookmark(bookmark, forecast)
+
+	// Then:
+	assert.Nil(t, err)
+
+	// Saturday visibility is excellent
+	assert.True(t, evaluations[2].IsSuitable)
+	assert.Equal(t, "EX", evaluations[2].Criteria[3].Value)
+	assert.Equal(t, "VG", evaluations[2].Criteria[3].Threshold)
+
+	// but on Sunday it is only good
+	assert.False(t, evaluations[3].IsSuitable)
+	assert.False(t, evaluations[3].Criteria[3].Passed)
+}
+
+func TestVisibilityScale(t *testing.T) {
+	assert.True(t, visibilityLevel("EX") > visibilityLevel("VG"))
+	assert.True(t, visibilityLevel("GO") > visibilityLevel("MO"))
+	assert.True(t, visibilityLevel("VP") > visibilityLevel("UN"))
+	assert.Equal(t, 0, visibilityLevel("something unexpected"))
+	assert.Equal(t, "GO", visibilityCode(visibilityLevel("GO")))
+}
+
+func TestEvaluateBookmarkAtNight(t *testing.T) {
+
+	// Given:
+	forecast := loadDailyForecast(t)
+	minNightTemp := 0
+	bookmark := structs.UsersLocationBookmark{
+		LocationID:   TestLocationID,
+		MaxWindSpeed: 10,
+		CheckPeriod:  OnlyWeekends,
+		Mode:         ModeNight,
+		MinNightTemp: &minNightTemp,
+	}
+
+	// When:
+	evaluations, err := EvaluateBookmark(bookmark, forecast)
+
+	// Then:
+	assert.Nil(t, err)
+
+	// Saturday night is clear, calm and dry
+	assert.True(t, evaluations[2].IsNight)
+	assert.True(t, evaluations[2].IsSuitable)
+	assert.Equal(t, 3, evaluations[2].Temperature)
+	assert.NotEmpty(t, evaluations[2].Moon)
+
+	// but Sunday night is foggy and frosty
+	assert.False(t, evaluations[3].IsSuitable)
+	assert.False(t, evaluations[3].Criteria[0].Passed)
+	assert.False(t, evaluations[3].Criteria[3].Passed)
+}
+
+func TestMoonPhase(t *testing.T) {
+	for _, tt := range []struct {
+		date     time.Time
+		expected string
+	}{
+		{time.Date(2019, time.October, 13, 21, 8, 0, 0, time.UTC), "full moon"},
+		{time.Date(2019, time.October, 28, 3, 38, 0, 0, time.UTC), "new moon"},
+		{time.Date(2019, time.October, 5, 16, 47, 0, 0, time.UTC), "first quarter"},
+		{time.Date(2019, time.October, 21, 12, 39, 0, 0, time.UTC), "last quarter"},
+	} {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, moonPhaseNameFor(moonPhase(tt.date)).name)
+		})
+	}
+
+	// the full moon is fully lit, the new moon is dark
+	assert.InDelta(t, 1.0, moonIllumination(moonPhase(time.Date(2019, time.October, 13, 21, 8, 0, 0, time.UTC))), 0.01)
+	assert.InDelta(t, 0.0, moonIllumination(moonPhase(time.Date(2019, time.October, 28, 3, 38, 0, 0, time.UTC))), 0.01)
+}
+
+func TestCalculateSunTimes(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		date      time.Time
+		latitude  float64
+		longitude float64
+		sunrise   string
+		sunset    string
+	}{
+		{"Keswick in autumn", time.Date(2019, time.October, 12, 0, 0, 0, 0, time.UTC), 54.6007, -3.1343, "06:34", "17:23"},
+		{"London, summer solstice", time.Date(2019, time.June, 21, 0, 0, 0, 0, time.UTC), 51.5074, -0.1278, "03:43", "20:21"},
+		{"London, winter solstice", time.Date(2019, time.December, 21, 0, 0, 0, 0, time.UTC), 51.5074, -0.1278, "08:04", "15:53"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// When:
+			sun, ok := calculateSunTimes(tt.date, tt.latitude, tt.longitude)
+
+			// Then: the sunrise equation is accurate to a couple of minutes
+			assert.True(t, ok)
+			assertTimeAround(t, tt.date, tt.sunrise, sun.sunrise)
+			assertTimeAround(t, tt.date, tt.sunset, sun.sunset)
+
+			// and golden hours start at sunrise and finish at sunset
+			assert.Equal(t, 2, len(sun.golden))
+			assert.Equal(t, sun.sunrise, sun.golden[0].from)
+			assert.True(t, sun.golden[0].to.After(sun.sunrise))
+			assert.True(t, sun.golden[1].from.Before(sun.sunset))
+			assert.Equal(t, sun.sunset, sun.golden[1].to)
+		})
+	}
+}
+
+func TestCalculateSunTimesInTheFarNorth(t *testing.T) {
+
+	// Shetland in December: the sun rises, but never climbs high, so the whole day is golden
+	sun, ok := calculateSunTimes(time.Date(2019, time.December, 21, 0, 0, 0, 0, time.UTC), 60.8, -0.9)
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(sun.golden))
+	assert.Equal(t, "all day", shortGoldenHours(sun))
+
+	// and in the Arctic it doesn't rise at all
+	_, ok = calculateSunTimes(time.Date(2019, time.December, 21, 0, 0, 0, 0, time.UTC), 78.2, 15.6)
+	assert.False(t, ok)
+}
+
+func assertTimeAround(t *testing.T, date time.Time, expected string, actual time.Time) {
+	parsed, err := time.Parse("15:04", expected)
+	assert.Nil(t, err)
+	expectedTime := date.Add(time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute)
+	assert.WithinDuration(t, expectedTime, actual, 3*time.Minute)
+}
+
+func TestEvaluateGoldenHours(t *testing.T) {
+
+	// Given: Saturday in Keswick, golden hours are about 06:34–07:23 and 16:34–17:23
+	step := func(minutes, wind int) map[string]string {
+		return map[string]string{"$": strconv.Itoa(minutes), "F": "12", "T": "14", "S": strconv.Itoa(wind),
+			"G": "20", "H": "70", "Pp": "5", "W": "1", "U": "1", "D": "N", "V": "GO"}
+	}
+	bookmark := structs.UsersLocationBookmark{
+		MaxWindSpeed:   20,
+		LowestTemp:     10,
+		CheckPeriod:    AllDays,
+		GoldenHourOnly: true,
+	}
+
+	for _, tt := range []struct {
+		name       string
+		winds      []int // for the steps starting at 03:00, 06:00, 09:00, 12:00, 15:00 and 18:00
+		isSuitable bool
+		goldenStep string
+	}{
+		{"calm morning", []int{30, 10, 30, 30, 30, 30}, true, "06:00–09:00"},
+		{"calm evening", []int{30, 30, 10, 10, 10, 30}, true, "15:00–18:00"},
+		{"calm midday only", []int{10, 30, 10, 10, 30, 10}, false, "06:00–09:00"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			day := structs.Period{Value: "2019-10-12Z"}
+			for i, wind := range tt.winds {
+				day.Rep = append(day.Rep, step((i+1)*180, wind))
+			}
+
+			// When:
+			evaluation, err := EvaluateGoldenHours(bookmark, day, "54.6007", "-3.1343")
+
+			// Then:
+			assert.Nil(t, err)
+			assert.Equal(t, tt.isSuitable, evaluation.IsSuitable)
+			assert.Equal(t, "golden hour", evaluation.Criteria[0].Name)
+			assert.Equal(t, tt.goldenStep, evaluation.Criteria[0].Value)
+			assert.NotEmpty(t, evaluation.GoldenHours)
+		})
+	}
+}
+
+func TestFindRuns(t *testing.T) {
+
+	// every letter is one day starting from Thursday, 10 Oct 2019:
+	// G - good day, B - bad day, S - skipped (not in the checked period), _ - missing in the forecast
+	evaluationsFor := func(pattern string) []DayEvaluation {
+		var evaluations []DayEvaluation
+		start := time.Date(2019, time.October, 10, 0, 0, 0, 0, time.UTC)
+		for i, letter := range pattern {
+			date := start.AddDate(0, 0, i)
+			if letter == '_' {
+				continue
+			}
+			evaluations = append(evaluations, DayEvaluation{
+				Date:       date.Format("2006-01-02"),
+				Skipped:    letter == 'S',
+				IsSuitable: letter == 'G',
+				date:       date,
+			})
+		}
+		return evaluations
+	}
+
+	for _, tt := range []struct {
+		name      string
+		pattern   string
+		minLength int
+		expected  []Run
+	}{
+		{"no good days", "BBBBB", 2, nil},
+		{"isolated good days are not enough", "GBGBG", 2, nil},
+		{"any single day is fine", "GBGBB", 1, []Run{
+			{From: "2019-10-10", To: "2019-10-10", Days: 1},
+			{From: "2019-10-12", To: "2019-10-12", Days: 1},
+		}},
+		{"bad day breaks the run", "GGBGG", 2, []Run{
+			{From: "2019-10-10", To: "2019-10-11", Days: 2},
+			{From: "2019-10-13", To: "2019-10-14", Days: 2, OpenEnded: true},
+		}},
+		{"missing day breaks the run", "GG_GG", 2, []Run{
+			{From: "2019-10-10", To: "2019-10-11", Days: 2},
+			{From: "2019-10-13", To: "2019-10-14", Days: 2, OpenEnded: true},
+		}},
+		{"run at the beginning of the forecast", "GGGBB", 3, []Run{
+			{From: "2019-10-10", To: "2019-10-12", Days: 3},
+		}},
+		{"run at the end of the forecast could be longer", "BBGGG", 3, []Run{
+			{From: "2019-10-12", To: "2019-10-14", Days: 3, OpenEnded: true},
+		}},
+		{"too short run at the end of the forecast", "BBBGG", 3, nil},
+		{"the whole forecast", "GGGGG", 3, []Run{
+			{From: "2019-10-10", To: "2019-10-14", Days: 5, OpenEnded: true},
+		}},
+		{"only weekends, Monday is not checked", "SGGGS", 3, []Run{
+			{From: "2019-10-11", To: "2019-10-13", Days: 3},
+		}},
+		{"only weekends, bad Saturday", "SGBGS", 2, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// When:
+			runs := FindRuns(evaluationsFor(tt.pattern), tt.minLength)
+
+			// Then:
+			assert.Equal(t, len(tt.expected), len(runs))
+			for i := range runs {
+				runs[i].days = nil // compare only exported fields
+			}
+			if len(tt.expected) > 0 {
+				assert.Equal(t, tt.expected, runs)
+			}
+		})
+	}
+}
+
+func TestFindRunsWithWeekendsOnly(t *testing.T) {
+
+	// Given: Thursday till Monday, and user wants weekends only
+	forecast := loadDailyForecast(t)
+	bookmark := structs.UsersLocationBookmark{
+		MaxWindSpeed: 20,
+		LowestTemp:   0,
+		CheckPeriod:  OnlyWeekends,
+	}
+	evaluations, err := EvaluateBookmark(bookmark, forecast)
+	assert.Nil(t, err)
+
+	// When:
+	runs := FindRuns(evaluations, 2)
+
+	// Then: Friday, Saturday and Sunday are good, Thursday and Monday are not checked
+	assert.Equal(t, 1, len(runs))
+	assert.Equal(t, "2019-10-11", runs[0].From)
+	assert.Equal(t, "2019-10-13", runs[0].To)
+	assert.Equal(t, 3, runs[0].Days)
+	assert.False(t, runs[0].OpenEnded)
+}
+
+func TestRunDescription(t *testing.T) {
+	run := Run{Days: 3, days: []DayEvaluation{
+		{date: time.Date(2019, time.October, 12, 0, 0, 0, 0, time.UTC)},
+		{date: time.Date(2019, time.October, 14, 0, 0, 0, 0, time.UTC)},
+	}}
+	assert.Equal(t, "Sat 12 – Mon 14 Oct, 3 good days", run.describe(false))
+
+	run = Run{Days: 3, OpenEnded: true, days: []DayEvaluation{
+		{date: time.Date(2019, time.October, 31, 0, 0, 0, 0, time.UTC)},
+		{date: time.Date(2019, time.November, 2, 0, 0, 0, 0, time.UTC)},
+	}}
+	assert.Equal(t, "Thu 31 Oct – Sat 2 Nov, 3 clear nights, maybe more", run.describe(true))
+}
+
+func TestEvaluateHazards(t *testing.T) {
+
+	allHazards := hazardFrost | hazardGale | hazardHeavyRain | hazardSnow | hazardThunder
+
+	var tests = []struct {
+		name        string
+		hazards     int
+		day         map[string]string
+		night       map[string]string
+		isHazardous bool
+		triggered   string
+	}{
+		{"calm and mild", allHazards, map[string]string{"W": "1", "Gn": "20"}, map[string]string{"W": "0", "Gm": "15", "Nm": "5"}, false, ""},
+		{"frost", allHazards, map[string]string{"W": "1", "Gn": "20"}, map[string]string{"W": "0", "Gm": "15", "Nm": "-2"}, true, "🧊 frost (-2˚C)"},
+		{"frost is not watched", hazardGale, map[string]string{"W": "1", "Gn": "20"}, map[string]string{"W": "0", "Gm": "15", "Nm": "-2"}, false, ""},
+		{"gale at night", allHazards, map[string]string{"W": "1", "Gn": "30"}, map[string]string{"W": "0", "Gm": "45", "Nm": "5"}, true, "💨 gale (45mph)"},
+		{"gale is exactly the limit", allHazards, map[string]string{"W": "1", "Gn": "40"}, map[string]string{"W": "0", "Gm": "15", "Nm": "5"}, false, ""},
+		{"heavy rain in the day", allHazards, map[string]string{"W": "15", "Gn": "20"}, map[string]string{"W": "0", "Gm": "15", "Nm": "5"}, true, "☔ heavy rain"},
+		{"snow at night", hazardSnow, map[string]string{"W": "1", "Gn": "20"}, map[string]string{"W": "27", "Gm": "15", "Nm": "5"}, true, "☃ snow"},
+		{"thunder", hazardThunder, map[string]string{"W": "30", "Gn": "20"}, map[string]string{"W": "0", "Gm": "15", "Nm": "5"}, true, "⚡ thunder"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Given:
+			bookmark := structs.UsersLocationBookmark{CheckPeriod: AllDays, Polarity: PolarityHazard, Hazards: tt.hazards}
+			day := structs.Period{Value: "2019-10-12Z", Rep: []map[string]string{tt.day, tt.night}}
+
+			// When:
+			evaluation, err := EvaluateDay(bookmark, day)
+
+			// Then:
+			assert.Nil(t, err)
+			assert.Equal(t, tt.isHazardous, evaluation.IsHazardous)
+			if tt.isHazardous {
+				assert.Contains(t, triggeredHazards(evaluation.Criteria), tt.triggered)
+			} else {
+				assert.Empty(t, triggeredHazards(evaluation.Criteria))
+			}
+		})
+	}
+}
+
+func TestEvaluateHazardsWithoutNightForecast(t *testing.T) {
+
+	// Given:
+	bookmark := structs.UsersLocationBookmark{CheckPeriod: AllDays, Polarity: PolarityHazard, Hazards: hazardFrost | hazardGale}
+	day := structs.Period{Value: "2019-10-12Z", Rep: []map[string]string{{"W": "1", "Gn": "20"}}}
+
+	// When:
+	evaluation, err := EvaluateDay(bookmark, day)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.False(t, evaluation.IsHazardous)
+	assert.Equal(t, 2, len(evaluation.Criteria))
+}
+
+func TestHazardFlags(t *testing.T) {
+
+	var dataSet = []struct {
+		names         []string
+		expectedFlags int
+		isError       bool
+	}{
+		{nil, 0, false},
+		{[]string{"frost", "Heavy rain"}, hazardFrost | hazardHeavyRain, false},
+		{[]string{"flood"}, 0, true},
+	}
+
+	for _, tt := range dataSet {
+		t.Run(fmt.Sprintf("Flags of %v", tt.names),
+			func(t *testing.T) {
+
+				// When:
+				flags, err := HazardFlags(tt.names)
+
+				// Then:
+				assert.Equal(t, tt.isError, err != nil)
+				assert.Equal(t, tt.expectedFlags, flags)
+			})
+	}
+}
+
+func TestThrottle(t *testing.T) {
+
+	// Given:
+	th := &throttle{interval: 20 * time.Millisecond}
+	start := time.Now()
+
+	// When: three requests in a row
+	for i := 0; i < 3; i++ {
+		th.wait()
+	}
+
+	// Then: the first one is not delayed, the next ones wait for the interval
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+}
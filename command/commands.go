@@ -10,9 +10,6 @@ import (
 
 	"github.com/w32blaster/bot-weather-watcher/structs"
 
-	"github.com/asdine/storm"
-	"github.com/asdine/storm/codec/msgpack"
-	"github.com/asdine/storm/q"
 	"github.com/getsentry/sentry-go"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/pkg/errors"
@@ -20,6 +17,7 @@ import (
 
 const (
 	DbPath                        = "storage/weather.db"
+	SQLiteDbPath                  = "storage/weather.sqlite" // the default of SQLITE_PATH
 	LocationIDPrefix              = "LocationID:"
 	Separator                     = "#"
 	ButtonChoiceAllDaysOrWeekends = "W"  // for buttons "all days" or "weekdays only"
@@ -27,13 +25,25 @@ const (
 	ButtonLocationPrefix          = "L"  // for button "start searching for location
 	ButtonDeleteMsgPrefix         = "dM" // for button "delete message"
 	ButtonDeleteBookmark          = "dB" // for button "delete bookmark"
+	ButtonDigestGrouping          = "G"  // for buttons "group digest by date" or "by location"
+	ButtonEditBookmark            = "E"  // for buttons "edit this bookmark"
+	ButtonExcludeWeather          = "X"  // for toggle buttons with excluded weather types
+	ButtonWindDirection           = "C"  // for toggle buttons of the compass rose with wind directions
+	ButtonWizardAnswer            = "A"  // for buttons that answer the current step of adding new location
+	ButtonGroupMembers            = "M"  // for buttons that show, add or remove members of a location group
+	ButtonHazards                 = "Z"  // for toggle buttons with hazards of bad weather warnings
+	ButtonForgetMe                = "F"  // for buttons that confirm or cancel deleting all the user data
+	ButtonDone                    = "done"
+	ButtonSkip                    = "skip"
 )
 
 // ProcessCommands acts when user sent to a bot some command, for example "/command arg1 arg2"
-func ProcessCommands(bot *tgbotapi.BotAPI, message *tgbotapi.Message, opts *structs.Opts) {
+func ProcessCommands(app *App, message *tgbotapi.Message) {
 
+	bot := app.Bot
 	chatID := message.Chat.ID
 	command := extractCommand(message.Command())
+	RecordEvent(app.Repos, structs.Event{Type: EventCommandUsed, UserID: message.From.ID, Details: command})
 
 	switch command {
 
@@ -46,7 +56,6 @@ when a weather forecast matches your expectations. Have fun.
 This bot works in UK only and uses data from metoffice.gov.uk
 
 Please start with /start command.`
-		sentry.CaptureMessage("About command was sent")
 		sendMsg(bot, chatID, about)
 
 	case "help":
@@ -55,73 +64,134 @@ Please start with /start command.`
  /start - shows start message
  /help - this command
  /add - add new place to watch
+ /addgroup - add a group of places, when any of them is good enough
  /locations - list all the saved locations
+ /edit - change settings of a saved location
  /about - information about this bot
  /check - check the weather forecast for your bookmarks now
- /deleteall - delete all saved places`
+ /digest - choose how good days are grouped in notifications
+ /deleteall - delete all saved places
+ /mydata - get everything I store about you
+ /forgetme - delete everything I store about you`
 		sendMsg(bot, chatID, html.EscapeString(help))
 
 	case "add":
-		StartProcessAddingNewLocation(bot, message)
+		StartProcessAddingNewLocation(bot, app.Repos, message)
+
+	case "addgroup":
+		StartProcessAddingNewGroup(bot, app.Repos, message)
 
 	case "check":
-		CheckForecastForBookmarks(bot, message, opts)
+		CheckForecastForBookmarks(bot, app.Repos, message, app.Opts)
 
 	case "start":
 		sendMsg(bot, chatID, "Hey! In order to begin, you should add at least one site location where you would like to observe a weather. Click /add")
 
 	case "locations":
-		PrintSavedLocations(bot, chatID, message.From.ID)
+		PrintSavedLocations(bot, app.Repos, chatID, message.From.ID)
 
 	case "deleteall":
-		DeleteLocations(bot, message)
+		DeleteLocations(bot, app.Repos, message)
+
+	case "digest":
+		AskDigestGrouping(bot, message.Chat.ID)
+
+	case "edit":
+		StartEditingBookmark(bot, app.Repos, chatID, message.From.ID)
+
+	case "mydata":
+		SendPersonalData(bot, app.Repos, message)
+
+	case "forgetme":
+		AskForgetMe(bot, chatID)
+
+	case "backup":
+		if !app.IsAdmin(message.From.ID) {
+			sendMsg(bot, chatID, "Sorry, I don't recognize such command: "+command+", please call /help to get full list of commands I understand")
+			return
+		}
+		SendBackup(app, message)
+
+	case "events":
+		if !app.IsAdmin(message.From.ID) {
+			sendMsg(bot, chatID, "Sorry, I don't recognize such command: "+command+", please call /help to get full list of commands I understand")
+			return
+		}
+		SendEvents(app, message)
 
 	default:
 		sendMsg(bot, chatID, "Sorry, I don't recognize such command: "+command+", please call /help to get full list of commands I understand")
 	}
 }
 
-func CheckForecastForBookmarks(bot *tgbotapi.BotAPI, message *tgbotapi.Message, opts *structs.Opts) {
-	sentry.CaptureMessage("The check command was called")
-
+func CheckForecastForBookmarks(bot *tgbotapi.BotAPI, repos Repositories, message *tgbotapi.Message, opts *structs.Opts) {
 	msg, _ := sendMsg(bot, message.Chat.ID, "Checking the weather forecast for all your saved bookmarks...")
 
-	if wasFound := CheckWeather(bot, opts, message.From.ID); !wasFound {
-		sendMsg(bot, message.Chat.ID, "Sorry, only bad weather in the nearest time ⛈")
+	report, err := CheckWeather(bot, repos, opts, message.From.ID)
+	if err != nil {
+		sendMsg(bot, message.Chat.ID, "Sorry, internal error occurred, can't check the weather. Please try again later.")
+	} else if !report.HasNews() {
+
+		// nothing was sent, so explain why every day was not good enough
+		text := "Sorry, only bad weather in the nearest time ⛈ \n\n" + report.Explain()
+		for _, chunk := range splitMessage(text, maxMessageLength) {
+			sendMsg(bot, message.Chat.ID, chunk)
+		}
 	}
 
 	tgbotapi.NewDeleteMessage(message.Chat.ID, msg.MessageID)
 }
 
-func DeleteLocations(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	db, err := storm.Open(DbPath, storm.Codec(msgpack.Codec))
+// AskDigestGrouping lets user choose how good days should be grouped in the notification
+func AskDigestGrouping(bot *tgbotapi.BotAPI, chatID int64) {
+	msg, err := sendMsg(bot, chatID, "All the good days are sent to you in one message. How would you like them to be grouped?")
 	if err != nil {
-		sentry.CaptureException(err)
-		sendMsg(bot, message.Chat.ID, "Sorry, internal error occurred, can't open a database. Please try again later.")
 		return
 	}
-	defer db.Close()
 
-	if err := DeleteAllForThisUser(db, message.From.ID); err != nil {
-		sentry.CaptureException(err)
+	rowButtons := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("📅 By date", callbackData(ButtonDigestGrouping, strconv.Itoa(groupByDate))),
+		tgbotapi.NewInlineKeyboardButtonData("📍 By location", callbackData(ButtonDigestGrouping, strconv.Itoa(groupByLocation))),
 	}
 
-	sentry.CaptureMessage(fmt.Sprintf("User %s (id=%d) bookmarks were deleted", message.From.UserName, message.From.ID))
-	sendMsg(bot, message.Chat.ID, "Deleted")
+	keyboard := newInlineKeyboard(rowButtons)
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, msg.MessageID, keyboard)
+	bot.Send(keyboardMsg)
 }
 
-func PrintSavedLocations(bot *tgbotapi.BotAPI, chatID int64, userID int) {
+func saveDigestGrouping(bot *tgbotapi.BotAPI, repos Repositories, chatID int64, userID int, choice string) {
+	grouping, err := strconv.Atoi(choice)
+	if err != nil || (grouping != groupByDate && grouping != groupByLocation) {
+		sentry.CaptureException(errors.New("Unexpected digest grouping choice: " + choice))
+		return
+	}
 
-	db, err := storm.Open(DbPath, storm.Codec(msgpack.Codec))
-	if err != nil {
+	if err := SaveDigestGrouping(repos.States, userID, grouping); err != nil {
+		sentry.CaptureException(err)
+		sendMsg(bot, chatID, "Sorry, internal error occurred, can't save your choice. Please try again later.")
+		return
+	}
+
+	sendMsg(bot, chatID, "✅ Saved")
+}
+
+func DeleteLocations(bot *tgbotapi.BotAPI, repos Repositories, message *tgbotapi.Message) {
+	bookmarks, _ := repos.Bookmarks.FindByUser(message.From.ID)
+	if err := repos.Bookmarks.DeleteAllForUser(message.From.ID); err != nil {
 		sentry.CaptureException(err)
-		sendMsg(bot, chatID, "Sorry, internal error occurred, can't open a database. Please try again later.")
+		sendMsg(bot, message.Chat.ID, "Sorry, internal error occurred, can't delete your locations. Please try again later.")
 		return
 	}
-	defer db.Close()
 
-	var locations []structs.UsersLocationBookmark
-	db.Find("UserID", userID, &locations)
+	for _, bookmark := range bookmarks {
+		RecordEvent(repos, structs.Event{Type: EventBookmarkDeleted, UserID: bookmark.UserID, BookmarkID: bookmark.ID, LocationID: bookmark.LocationID})
+	}
+	sendMsg(bot, message.Chat.ID, "Deleted")
+}
+
+func PrintSavedLocations(bot *tgbotapi.BotAPI, repos Repositories, chatID int64, userID int) {
+
+	locations, _ := repos.Bookmarks.FindByUser(userID)
 
 	if len(locations) == 0 {
 		sendMsg(bot, chatID, "No saved locations yet. Please type /add to add one")
@@ -129,36 +199,125 @@ func PrintSavedLocations(bot *tgbotapi.BotAPI, chatID int64, userID int) {
 	}
 
 	// load locations, build a map
-	mapLocs := getMapOfLocations(locations, db)
+	mapLocs := getMapOfLocations(locations, repos.Sites)
 
 	var buffer bytes.Buffer
 	for _, loc := range locations {
 		buffer.WriteRune('•')
 		buffer.WriteRune(' ')
-		buffer.WriteString(mapLocs[loc.LocationID].Name)
+		if len(loc.GroupLocationIDs) > 0 {
+			buffer.WriteString("👥 " + escapeMarkdown(loc.GroupName) + ": " + escapeMarkdown(memberNames(loc.GroupLocationIDs, mapLocs)))
+		} else {
+			buffer.WriteString(mapLocs[loc.LocationID].Name)
+		}
+		if len(loc.Profile) > 0 {
+			buffer.WriteString(", " + profileTitle(loc.Profile))
+		}
+		if loc.Polarity == PolarityHazard {
+			buffer.WriteString(" (⚠ warn about: ")
+			buffer.WriteString(hazardNames(loc.Hazards))
+			buffer.WriteString(", check ")
+			buffer.WriteString(checkPeriodName(loc.CheckPeriod))
+			buffer.WriteString(")\n")
+			continue
+		}
+		if loc.Mode == ModeNight {
+			buffer.WriteString(" (clear nights, max wind: ")
+			buffer.WriteString(strconv.Itoa(loc.MaxWindSpeed))
+			buffer.WriteString("mph, ")
+			if loc.MinNightTemp != nil {
+				buffer.WriteString("min night t: ")
+				buffer.WriteString(strconv.Itoa(*loc.MinNightTemp))
+				buffer.WriteString("˚C, ")
+			}
+			if loc.MinRunLength > 1 {
+				buffer.WriteString("at least ")
+				buffer.WriteString(strconv.Itoa(loc.MinRunLength))
+				buffer.WriteString(" nights in a row, ")
+			}
+			buffer.WriteString("check ")
+			buffer.WriteString(checkPeriodName(loc.CheckPeriod))
+			buffer.WriteString(")\n")
+			continue
+		}
 		buffer.WriteString(" (min t: ")
 		buffer.WriteString(strconv.Itoa(loc.LowestTemp))
 		buffer.WriteString("˚C, max wind: ")
 		buffer.WriteString(strconv.Itoa(loc.MaxWindSpeed))
-		buffer.WriteString("mph, check ")
-		if loc.CheckPeriod == allDays {
-			buffer.WriteString("all days)\n")
-		} else if loc.CheckPeriod == onlyWeekends {
-			buffer.WriteString("only weekends)\n")
+		buffer.WriteString("mph, ")
+		if loc.MaxGust != nil {
+			buffer.WriteString("max gust: ")
+			buffer.WriteString(strconv.Itoa(*loc.MaxGust))
+			buffer.WriteString("mph, ")
+		}
+		if loc.MaxTemp != nil {
+			buffer.WriteString("max t: ")
+			buffer.WriteString(strconv.Itoa(*loc.MaxTemp))
+			buffer.WriteString("˚C, ")
+		}
+		if loc.MaxPrecipProb != nil {
+			buffer.WriteString("max rain chance: ")
+			buffer.WriteString(strconv.Itoa(*loc.MaxPrecipProb))
+			buffer.WriteString("%, ")
+		}
+		if loc.MaxHumidity != nil {
+			buffer.WriteString("max humidity: ")
+			buffer.WriteString(strconv.Itoa(*loc.MaxHumidity))
+			buffer.WriteString("%, ")
+		}
+		if loc.MaxUV != nil {
+			buffer.WriteString("max UV: ")
+			buffer.WriteString(strconv.Itoa(*loc.MaxUV))
+			buffer.WriteString(", ")
+		}
+		if loc.UVWarnLevel != nil {
+			buffer.WriteString("UV warning from: ")
+			buffer.WriteString(strconv.Itoa(*loc.UVWarnLevel))
+			buffer.WriteString(", ")
+		}
+		if loc.GoldenHourOnly {
+			buffer.WriteString("golden hours only, ")
+		}
+		if loc.MinRunLength > 1 {
+			buffer.WriteString("at least ")
+			buffer.WriteString(strconv.Itoa(loc.MinRunLength))
+			buffer.WriteString(" days in a row, ")
 		}
+		if loc.MinVisibility > 0 {
+			buffer.WriteString("min visibility: ")
+			buffer.WriteString(visibilityNames[visibilityCode(loc.MinVisibility)])
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString("check ")
+		buffer.WriteString(checkPeriodName(loc.CheckPeriod))
+		if loc.ExcludedWeather != 0 {
+			buffer.WriteString(", excluding: ")
+			buffer.WriteString(excludedWeatherNames(loc.ExcludedWeather))
+		}
+		if loc.WindDirections != 0 {
+			buffer.WriteString(", wind from: ")
+			buffer.WriteString(windDirectionNames(loc.WindDirections))
+		}
+		buffer.WriteString(")\n")
 	}
 
 	msg, _ := sendMsg(bot, chatID, "Here are your saved locations: \n\n"+buffer.String()+"\n\n For details click buttons below")
 	renderLocationsButtons(bot, chatID, msg.MessageID, locations, mapLocs)
 }
 
-func getMapOfLocations(locations []structs.UsersLocationBookmark, db *storm.DB) map[string]structs.SiteLocation {
-	ids := make([]string, len(locations))
-	for i, loc := range locations {
-		ids[i] = loc.LocationID
+func checkPeriodName(checkPeriod int) string {
+	if checkPeriod == OnlyWeekends {
+		return "only weekends"
+	}
+	return "all days"
+}
+
+func getMapOfLocations(locations []structs.UsersLocationBookmark, sites SiteRepository) map[string]structs.SiteLocation {
+	var ids []string
+	for _, loc := range locations {
+		ids = append(ids, MemberLocationIDs(loc)...)
 	}
-	var locs []structs.SiteLocation
-	db.Select(q.In("ID", ids)).Find(&locs)
+	locs, _ := sites.GetMany(ids)
 	mapLocations := make(map[string]structs.SiteLocation)
 	for _, loc := range locs {
 		mapLocations[loc.ID] = loc
@@ -168,65 +327,78 @@ func getMapOfLocations(locations []structs.UsersLocationBookmark, db *storm.DB)
 }
 
 // Initiate the process of adding a new location, create a new state
-func StartProcessAddingNewLocation(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	db, err := storm.Open(DbPath, storm.Codec(msgpack.Codec))
-	if err != nil {
+func StartProcessAddingNewLocation(bot *tgbotapi.BotAPI, repos Repositories, message *tgbotapi.Message) {
+	sm := startNewBookmark(bot, repos, message)
+	if sm == nil {
+		return
+	}
+
+	// the first step is the activity profile, then the location
+	if err := sm.markNextStepState(StepChooseProfile); err != nil {
 		sentry.CaptureException(err)
-		sendMsg(bot, message.Chat.ID, "Sorry, internal error occurred, can't open a database. Please try again later.")
+		sendMsg(bot, message.Chat.ID, "Internal error: can't update state")
 		return
 	}
 
-	defer db.Close()
+	sm.askProfile()
+}
+
+// starts the state machine from scratch with a new unfinished bookmark; returns nil if failed
+func startNewBookmark(bot *tgbotapi.BotAPI, repos Repositories, message *tgbotapi.Message) *StateMachine {
 
 	// to make sure we start from the beginning, clear all previous states if any
-	DeleteStateForUser(db, message.From.ID)
+	repos.States.DeleteState(message.From.ID)
 
 	// Delete all the bookmarks that this user has not finished if any
-	DeleteAllUnfinishedBookmarksForThisUser(db, message.From.ID)
+	repos.Bookmarks.DeleteUnfinished(message.From.ID)
 
 	// and now start a new state machine
-	sm, err := LoadStateMachineFor(bot, message.Chat.ID, message.From.ID, message.From.UserName, db)
+	sm, err := LoadStateMachineFor(bot, message.Chat.ID, message.From.ID, message.From.UserName, repos)
 	if err != nil {
 		sentry.CaptureException(err)
 		sendMsg(bot, message.Chat.ID, "Sorry, internal error occurred, please trt again later")
-		return
+		return nil
 	}
 
 	if err := sm.CreateNewBookmark(message.Chat.ID); err != nil {
 		sentry.CaptureException(err)
 		sendMsg(bot, message.Chat.ID, "Sorry, internal error occurred, please trt again later")
-		return
+		return nil
 	}
 
-	resp, _ := sendMsg(bot, message.Chat.ID, "Ok, let's add a location where you want to monitor a weather. "+
-		"Start typing name following by the bot name and suggestions will appear. \n"+
-		"Example: @WeatherObserverBot London \n\n Or, click the button below")
-
-	renderButtonThatOpensInlineQuery(bot, message.Chat.ID, resp.MessageID)
+	return sm
 }
 
 // Process a general text. The context should be retrieved from state machine
-func ProcessPlainText(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	db, err := storm.Open(DbPath, storm.Codec(msgpack.Codec))
-	defer db.Close()
+func ProcessPlainText(app *App, message *tgbotapi.Message) {
+	if stateMachine := resumeWizard(app.Bot, app.Repos, message.Chat.ID, message.From); stateMachine != nil {
+		stateMachine.ProcessNextState(message.Text)
+	}
+}
 
-	if err != nil {
-		sendMsg(bot, message.Chat.ID, "Ouch, this is internal error, sorry")
+// loads the wizard that user is answering. Only the commands start a wizard, so the text or the button without
+// a saved state is not an answer, for example when the janitor cancelled the wizard; user is told how to start then
+func resumeWizard(bot *tgbotapi.BotAPI, repos Repositories, chatID int64, user *tgbotapi.User) *StateMachine {
+	if _, err := repos.States.GetState(user.ID); err == ErrNotFound {
+		sendMsg(bot, chatID, "I'm not waiting for any answer now. Please type /add to watch a new place, or /help to see everything I can do")
+		return nil
+	} else if err != nil {
+		sendMsg(bot, chatID, "Ouch, this is internal error, sorry")
 		sentry.CaptureException(err)
-		return
+		return nil
 	}
 
-	stateMachine, err := LoadStateMachineFor(bot, message.Chat.ID, message.From.ID, message.From.UserName, db)
+	stateMachine, err := LoadStateMachineFor(bot, chatID, user.ID, user.UserName, repos)
 	if err != nil {
-		sendMsg(bot, message.Chat.ID, "Ouch, this is internal error, sorry")
+		sendMsg(bot, chatID, "Ouch, this is internal error, sorry")
 		sentry.CaptureException(err)
-		return
+		return nil
 	}
-
-	stateMachine.ProcessNextState(message.Text)
+	return stateMachine
 }
 
-func ProcessButtonCallback(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.CallbackQuery, opts *structs.Opts) {
+func ProcessButtonCallback(app *App, callbackQuery *tgbotapi.CallbackQuery) {
+	bot, repos, opts := app.Bot, app.Repos, app.Opts
 
 	sentry.AddBreadcrumb(&sentry.Breadcrumb{
 		Message: "The button was clicked",
@@ -236,30 +408,30 @@ func ProcessButtonCallback(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.Callbac
 		Level: sentry.LevelInfo,
 	})
 
-	// notify the telegram that we processed the button, it will turn "loading indicator" off
-	defer bot.AnswerCallbackQuery(tgbotapi.CallbackConfig{
-		CallbackQueryID: callbackQuery.ID,
-	})
-
-	db, err := storm.Open(DbPath, storm.Codec(msgpack.Codec))
+	// expected data is "location id # date # signature", for example; the data is sent by the client,
+	// so the buttons not signed by us are not trusted
+	parts, err := callbacks.Decode(callbackQuery.Data)
 	if err != nil {
-		sentry.CaptureException(err)
-		sendMsg(bot, callbackQuery.Message.Chat.ID, "Whoops... error :(")
+
+		// buttons of messages sent before the secret changed are expected, so this is not an error
+		RecordEvent(repos, structs.Event{Type: EventButtonRejected, UserID: callbackQuery.From.ID, Details: callbackQuery.Data})
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callbackQuery.ID, "Sorry, this button is outdated"))
 		return
 	}
-	defer db.Close()
 
-	// expected data is "location id # date", for example
-	parts := strings.Split(callbackQuery.Data, Separator)
+	// notify the telegram that we processed the button, it will turn "loading indicator" off
+	defer bot.AnswerCallbackQuery(tgbotapi.CallbackConfig{
+		CallbackQueryID: callbackQuery.ID,
+	})
 
 	if parts[0] == ButtonDaysPrefix {
 
 		// render 3 hour charts for temp and wind, for one location within one day
-		renderOneDayDetailedWeatherForecast(bot, callbackQuery, db, opts, parts[1], parts[2], parts[3])
+		renderOneDayDetailedWeatherForecast(bot, callbackQuery, repos, opts, parts[1], parts[2], parts[3])
 	} else if parts[0] == ButtonLocationPrefix {
 
 		// render table with 5 days summary for a given location
-		renderWeatherForecastForOneLocation(bot, db, callbackQuery.Message.Chat.ID, callbackQuery.From.ID, opts, parts[1])
+		renderWeatherForecastForOneLocation(bot, repos, callbackQuery.Message.Chat.ID, opts, parts[1])
 	} else if parts[0] == ButtonDeleteMsgPrefix {
 
 		// delete message
@@ -267,18 +439,42 @@ func ProcessButtonCallback(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.Callbac
 	} else if parts[0] == ButtonDeleteBookmark {
 
 		// delete one bookmark
-		deleteOneBookmark(bot, db, callbackQuery.Message.Chat.ID, parts[1])
-	} else if parts[0] == ButtonChoiceAllDaysOrWeekends {
-
-		// this is part of new location adding steps, where user should select "all days" or "only weekend"; so use state machine
-		stateMachine, err := LoadStateMachineFor(bot, callbackQuery.Message.Chat.ID, callbackQuery.From.ID, callbackQuery.From.UserName, db)
-		if err != nil {
-			sendMsg(bot, callbackQuery.Message.Chat.ID, "Ouch, this is internal error, sorry")
-			sentry.CaptureException(err)
-			return
-		}
+		deleteOneBookmark(bot, repos, callbackQuery.Message.Chat.ID, callbackQuery.From.ID, parts[1])
+	} else if parts[0] == ButtonDigestGrouping {
+
+		// user chose how to group the digest
+		saveDigestGrouping(bot, repos, callbackQuery.Message.Chat.ID, callbackQuery.From.ID, parts[1])
+	} else if parts[0] == ButtonEditBookmark {
+
+		// show menu of a bookmark, or ask for a new value of one of settings
+		processEditButton(bot, repos, callbackQuery, parts)
+	} else if parts[0] == ButtonGroupMembers {
+
+		// show members of a location group, add or remove them
+		processGroupButton(bot, repos, callbackQuery, parts)
+	} else if parts[0] == ButtonExcludeWeather {
 
-		stateMachine.ProcessNextState(parts[1])
+		// toggle one of excluded weather categories, or finish the choice
+		processToggleButton(bot, repos, callbackQuery, excludedWeatherSetting, parts[1], parts[2])
+	} else if parts[0] == ButtonWindDirection {
+
+		// toggle one of wind direction sectors, or finish the choice
+		processToggleButton(bot, repos, callbackQuery, windDirectionsSetting, parts[1], parts[2])
+	} else if parts[0] == ButtonHazards {
+
+		// toggle one of hazards of bad weather warnings, or finish the choice
+		processToggleButton(bot, repos, callbackQuery, hazardsSetting, parts[1], parts[2])
+	} else if parts[0] == ButtonForgetMe {
+
+		// user confirmed or cancelled deleting all the data
+		processForgetMeButton(bot, repos, callbackQuery, parts[1])
+	} else if parts[0] == ButtonChoiceAllDaysOrWeekends || parts[0] == ButtonWizardAnswer {
+
+		// this is part of new location adding steps, where user should select "all days" or "only weekend",
+		// or skip an optional step; so use state machine
+		if stateMachine := resumeWizard(bot, repos, callbackQuery.Message.Chat.ID, callbackQuery.From); stateMachine != nil {
+			stateMachine.ProcessNextState(parts[1])
+		}
 	}
 }
 
@@ -291,7 +487,8 @@ func deleteMessage(bot *tgbotapi.BotAPI, chatID int64, messageID string) {
 	}
 }
 
-func deleteOneBookmark(bot *tgbotapi.BotAPI, db *storm.DB, chatID int64, bookmarkID string) {
+// deletes the bookmark, if it belongs to the user who clicked the button
+func deleteOneBookmark(bot *tgbotapi.BotAPI, repos Repositories, chatID int64, userID int, bookmarkID string) {
 
 	intBookmarkID, err := strconv.Atoi(bookmarkID)
 	if err != nil {
@@ -299,30 +496,39 @@ func deleteOneBookmark(bot *tgbotapi.BotAPI, db *storm.DB, chatID int64, bookmar
 		return
 	}
 
-	var bookmark structs.UsersLocationBookmark
-	if err := db.One("ID", intBookmarkID, &bookmark); err != nil {
-		sentry.CaptureException(errors.Wrap(err, "Can't find a bookmark"))
+	bookmark, err := repos.Bookmarks.Get(intBookmarkID)
+	if err != nil || bookmark.UserID != userID {
 		sendMsg(bot, chatID, "Can't find a bookmark")
 		return
 	}
 
-	if err := db.DeleteStruct(&bookmark); err != nil {
+	if err := repos.Bookmarks.Delete(&bookmark); err != nil {
 		sentry.CaptureException(err)
+		sendMsg(bot, chatID, "Sorry, internal error occurred, can't delete the bookmark. Please try again later.")
+		return
 	}
 
+	RecordEvent(repos, structs.Event{Type: EventBookmarkDeleted, UserID: userID, BookmarkID: bookmark.ID, LocationID: bookmark.LocationID})
 	sendMsg(bot, chatID, "✅ Deleted. You can see all saved bookmarks using the command \n /locations")
 }
 
-func renderWeatherForecastForOneLocation(bot *tgbotapi.BotAPI, db *storm.DB, chatID int64, userID int, opts *structs.Opts, locationID string) {
+func renderWeatherForecastForOneLocation(bot *tgbotapi.BotAPI, repos Repositories, chatID int64, opts *structs.Opts, locationID string) {
 
-	var locations []structs.UsersLocationBookmark
-	db.Select(q.Eq("LocationID", locationID), q.Eq("UserID", userID)).Limit(1).Find(&locations)
-
-	mapLocations := getMapOfLocations(locations, db)
+	// the location could be a member of a group, so look for the site itself
+	site, err := repos.Sites.Get(locationID)
+	if err != nil {
+		sendMsg(bot, chatID, "Can't find this location")
+		return
+	}
 
-	loc, _ := getDailyForecastFor(locations[0].LocationID, opts)
+	// clicks come in bursts, so they are throttled like the nightly check is
+	loc, err := MetOfficeProvider{Opts: opts}.DailyForecast(locationID)
+	if err != nil {
+		sentry.CaptureException(err)
+		sendMsg(bot, chatID, "Error retrieving data from MetOffice. Try again later")
+		return
+	}
 
-	site := mapLocations[locations[0].LocationID]
 	str := fmt.Sprintf("%s %s, %s, %s UK\n\n", site.NationalPark, site.Name, site.AuthArea, strings.ToUpper(site.Region))
 	str = str + drawFiveDaysTable(loc)
 	str = str + "\n For detailed daily forecast per 3 hour please use buttons below:"
@@ -332,10 +538,7 @@ func renderWeatherForecastForOneLocation(bot *tgbotapi.BotAPI, db *storm.DB, cha
 	renderDetailedDatesButtons(bot, chatID, resp.MessageID, loc)
 }
 
-func renderOneDayDetailedWeatherForecast(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.CallbackQuery, db *storm.DB, opts *structs.Opts, locationID, selectedDate string, messageIDtoUpdate string) {
-
-	var locations []structs.UsersLocationBookmark
-	db.Select(q.Eq("LocationID", locationID), q.Eq("UserID", callbackQuery.From.ID)).Limit(1).Find(&locations)
+func renderOneDayDetailedWeatherForecast(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.CallbackQuery, repos Repositories, opts *structs.Opts, locationID, selectedDate string, messageIDtoUpdate string) {
 
 	// format the data
 	dateFormatted := "unknown date"
@@ -343,7 +546,11 @@ func renderOneDayDetailedWeatherForecast(bot *tgbotapi.BotAPI, callbackQuery *tg
 		dateFormatted = t.Format("2 January 2006, Monday")
 	}
 
-	site := getMapOfLocations(locations, db)[locationID]
+	site, err := repos.Sites.Get(locationID)
+	if err != nil {
+		sendMsg(bot, callbackQuery.Message.Chat.ID, "Can't find this location")
+		return
+	}
 
 	// Title
 	nationalPark := ""
@@ -354,7 +561,7 @@ func renderOneDayDetailedWeatherForecast(bot *tgbotapi.BotAPI, callbackQuery *tg
 		", UK*\n" + dateFormatted + "\n------\n\n"
 
 	// make request to MetOffice
-	root, err := get3HoursForecastFor(locationID, opts)
+	root, err := MetOfficeProvider{Opts: opts}.ThreeHourlyForecast(locationID)
 	if err != nil {
 		sentry.CaptureException(err)
 		sendMsg(bot, callbackQuery.Message.Chat.ID, "Error retrieving data from MetOffice. Try again later")
@@ -365,14 +572,28 @@ func renderOneDayDetailedWeatherForecast(bot *tgbotapi.BotAPI, callbackQuery *tg
 	for _, day := range root.SiteRep.Dv.Location.Periods {
 		if day.Value == selectedDate {
 
-			str := "Temperature: \n\n"
-			str = str + printDetailedPlotsForADay(day.Rep, "T", "˚C", false)
+			str := ""
+			if t, err := time.Parse(layoutMetofficeDate, day.Value); err == nil {
+				location := root.SiteRep.Dv.Location
+				if sun, ok := sunTimesFor(t, location.Latitude, location.Longitude); ok {
+					str = sun.String() + "\n\n"
+				}
+			}
+
+			str = str + "Temperature: \n\n"
+			str = str + printDetailedPlotsForADay(day.Rep, "T", "˚C", false, false)
 
-			str = str + "Wind speed: \n\n"
-			str = str + printDetailedPlotsForADay(day.Rep, "S", "mph", false)
+			str = str + "Wind speed and direction: \n\n"
+			str = str + printDetailedPlotsForADay(day.Rep, "S", "mph", false, true)
 
 			str = str + "Precipitation Probability: \n\n"
-			str = str + printDetailedPlotsForADay(day.Rep, "Pp", " %", true)
+			str = str + printDetailedPlotsForADay(day.Rep, "Pp", " %", true, false)
+
+			str = str + "UV index: \n\n"
+			str = str + printDetailedPlotsForADay(day.Rep, "U", "UV", false, false)
+
+			str = str + "Visibility: \n\n"
+			str = str + printVisibilityBand(day.Rep)
 
 			// update existing message
 			if intMessageID, err := strconv.Atoi(messageIDtoUpdate); err == nil {
@@ -396,23 +617,11 @@ func renderOneDayDetailedWeatherForecast(bot *tgbotapi.BotAPI, callbackQuery *tg
 	}
 }
 
-func ProcessInlineQuery(bot *tgbotapi.BotAPI, inlineQuery *tgbotapi.InlineQuery) {
-	db, err := storm.Open(DbPath, storm.Codec(msgpack.Codec))
-	if err != nil {
-		sentry.CaptureException(err)
-		return
-	}
-	defer db.Close()
+func ProcessInlineQuery(app *App, inlineQuery *tgbotapi.InlineQuery) {
+	bot := app.Bot
 
 	// firstly, make query to TFL
-	searchQuery := inlineQuery.Query
-	var locations []structs.SiteLocation
-
-	db.Select(q.Or(
-		q.Re("Name", "(?i)(^| )"+searchQuery),
-		q.Re("AuthArea", "(?i)(^| )"+searchQuery),
-		q.Re("NationalPark", "(?i)(^| )"+searchQuery),
-	)).Limit(20).OrderBy("Name").Find(&locations)
+	locations := app.Search.Search(inlineQuery.Query, 20)
 
 	var answers []interface{}
 
@@ -469,6 +678,13 @@ func extractCommand(rawCommand string) string {
 	return command
 }
 
+// escapes the names of sites and groups, so "Loch_Ness" or "Peak*" don't break Markdown of the message
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
 // simply send a message to bot in Markdown format
 func sendMsg(bot *tgbotapi.BotAPI, chatID int64, textMarkdown string) (tgbotapi.Message, error) {
 
@@ -500,7 +716,7 @@ func renderKeyboardButtonActivateQuery(message string) *tgbotapi.InlineKeyboardB
 
 // renders the button "search for location"
 func renderButtonThatOpensInlineQuery(bot *tgbotapi.BotAPI, chatID int64, messageID int) {
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+	keyboard := newInlineKeyboard(
 		[]tgbotapi.InlineKeyboardButton{
 			*renderKeyboardButtonActivateQuery(" 🔍 Search for location"),
 		})
@@ -509,12 +725,29 @@ func renderButtonThatOpensInlineQuery(bot *tgbotapi.BotAPI, chatID int64, messag
 	bot.Send(keyboardMsg)
 }
 
+// removes all the inline buttons from the message
+func removeButtons(bot *tgbotapi.BotAPI, chatID int64, messageID int) {
+	keyboard := tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{},
+	}
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, keyboard)
+	bot.Send(keyboardMsg)
+}
+
 // renders the buttons for saved locations
 func renderLocationsButtons(bot *tgbotapi.BotAPI, chatID int64, messageID int, locations []structs.UsersLocationBookmark, mapLocs map[string]structs.SiteLocation) {
 
 	buttonRows := make([][]tgbotapi.InlineKeyboardButton, len(locations))
 	for i, e := range locations {
 
+		// groups have their own menu with all the members
+		if len(e.GroupLocationIDs) > 0 {
+			buttonRows[i] = []tgbotapi.InlineKeyboardButton{
+				tgbotapi.NewInlineKeyboardButtonData("👥 "+e.GroupName, callbackData(ButtonGroupMembers, strconv.Itoa(e.ID))),
+			}
+			continue
+		}
+
 		// assemble address (label) of a location
 		currentLoc := mapLocs[e.LocationID]
 		var buffer bytes.Buffer
@@ -530,11 +763,11 @@ func renderLocationsButtons(bot *tgbotapi.BotAPI, chatID int64, messageID int, l
 
 		// add button to the row
 		buttonRows[i] = []tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData(buffer.String(), ButtonLocationPrefix+Separator+e.LocationID),
+			tgbotapi.NewInlineKeyboardButtonData(buffer.String(), callbackData(ButtonLocationPrefix, e.LocationID)),
 		}
 	}
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(buttonRows...)
+	keyboard := newInlineKeyboard(buttonRows...)
 	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, keyboard)
 	bot.Send(keyboardMsg)
 }
@@ -554,7 +787,7 @@ func renderDetailedDatesButtons(bot *tgbotapi.BotAPI, chatID int64, messageID in
 		}
 
 		rowDaysButtonsRow1[i] = tgbotapi.NewInlineKeyboardButtonData(text,
-			ButtonDaysPrefix+Separator+root.SiteRep.Dv.Location.ID+Separator+period.Value+Separator+strMessageID)
+			callbackData(ButtonDaysPrefix, root.SiteRep.Dv.Location.ID, period.Value, strMessageID))
 	}
 
 	rowDaysButtonsRow2 := make([]tgbotapi.InlineKeyboardButton, 3)
@@ -567,14 +800,14 @@ func renderDetailedDatesButtons(bot *tgbotapi.BotAPI, chatID int64, messageID in
 		}
 
 		rowDaysButtonsRow2[i-2] = tgbotapi.NewInlineKeyboardButtonData(text,
-			ButtonDaysPrefix+Separator+root.SiteRep.Dv.Location.ID+Separator+period.Value+Separator+strMessageID)
+			callbackData(ButtonDaysPrefix, root.SiteRep.Dv.Location.ID, period.Value, strMessageID))
 	}
 
 	rowCloseButton := []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("❌ Close", ButtonDeleteMsgPrefix+Separator+strMessageID),
+		tgbotapi.NewInlineKeyboardButtonData("❌ Close", callbackData(ButtonDeleteMsgPrefix, strMessageID)),
 	}
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(rowDaysButtonsRow1, rowDaysButtonsRow2, rowCloseButton)
+	keyboard := newInlineKeyboard(rowDaysButtonsRow1, rowDaysButtonsRow2, rowCloseButton)
 	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, keyboard)
 	bot.Send(keyboardMsg)
 }
@@ -1,8 +1,8 @@
 package command
 
 import (
-	"github.com/asdine/storm"
-	"github.com/asdine/storm/q"
+	"errors"
+
 	"github.com/w32blaster/bot-weather-watcher/structs"
 )
 
@@ -11,10 +11,10 @@ func (sm *StateMachine) CreateNewBookmark(chatID int64) error {
 	if bookmark := sm.GetUnfinishedBookmark(); bookmark != nil {
 		// the object already exists. Probably, user enters something wrong and decided to start again.
 		// Remove old object to begin from the start with fresh state
-		sm.db.DeleteStruct(&bookmark)
+		sm.repos.Bookmarks.Delete(bookmark)
 	}
 
-	return sm.db.Save(&structs.UsersLocationBookmark{
+	return sm.repos.Bookmarks.Save(&structs.UsersLocationBookmark{
 		UserID:       sm.UserID,
 		UserName:     sm.UserName,
 		LocationID:   "",
@@ -27,35 +27,31 @@ func (sm *StateMachine) CreateNewBookmark(chatID int64) error {
 
 func (sm *StateMachine) UpdateFieldInBookmark(fieldName string, value interface{}) error {
 	bookmark := sm.GetUnfinishedBookmark()
-	return sm.db.UpdateField(bookmark, fieldName, value)
+	if bookmark == nil {
+		return errors.New("there is no unfinished bookmark to update the field " + fieldName)
+	}
+	return sm.repos.Bookmarks.UpdateField(bookmark, fieldName, value)
 }
 
 func (sm *StateMachine) GetUnfinishedBookmark() *structs.UsersLocationBookmark {
-	var bookmark structs.UsersLocationBookmark
-	err := sm.db.Select(q.And(
-		q.Eq("UserID", sm.UserID),
-		q.Eq("IsReady", false),
-	)).First(&bookmark)
-
+	bookmark, err := sm.repos.Bookmarks.FindUnfinished(sm.UserID)
 	if err != nil {
 		return nil
 	}
 	return &bookmark
 }
 
-func DeleteStateForUser(db *storm.DB, userID int) {
-	var state structs.UserState
-	if err := db.One("UserID", userID, &state); err == nil {
-		db.DeleteStruct(&state)
+// GetUserSettings returns saved settings for the user, or default ones if the user never changed anything
+func GetUserSettings(states UserStateRepository, userID int) structs.UserSettings {
+	settings, err := states.GetSettings(userID)
+	if err != nil {
+		return structs.UserSettings{UserID: userID, DigestGroup: groupByDate}
 	}
+	return settings
 }
 
-func DeleteAllUnfinishedBookmarksForThisUser(db *storm.DB, userID int) error {
-	query := db.Select(q.Eq("UserID", userID), q.Eq("IsReady", false))
-	return query.Delete(new(structs.UsersLocationBookmark))
-}
-
-func DeleteAllForThisUser(db *storm.DB, userID int) error {
-	query := db.Select(q.Eq("UserID", userID))
-	return query.Delete(new(structs.UsersLocationBookmark))
+func SaveDigestGrouping(states UserStateRepository, userID int, grouping int) error {
+	settings := GetUserSettings(states, userID)
+	settings.DigestGroup = grouping
+	return states.SaveSettings(&settings)
 }
@@ -0,0 +1,256 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	groupByDate     = 0
+	groupByLocation = 1
+
+	// Telegram doesn't accept messages longer than 4096 UTF-16 code units,
+	// keep some space for safety
+	maxMessageLength = 4000
+)
+
+// one day that matches the bookmark expectations
+type goodDay struct {
+	bookmark    structs.UsersLocationBookmark
+	site        structs.SiteLocation
+	date        time.Time
+	temp        int
+	wind        int
+	precip      int
+	weatherType int
+	uv          int
+	moon        string // for clear nights only
+	run         *Run   // for bookmarks that need several good days in a row
+	members     string // the best sites of a location group, for example "Edale, Castleton"
+	hazards     string // for bad weather warnings only, for example "🧊 frost (-2˚C)"
+}
+
+// all the good days found for one chat, that will be sent as one message (or several,
+// if the text is too long)
+type digest struct {
+	chatID int64
+	userID int
+	days   []goodDay
+}
+
+// collects good days into digests, one per chat, keeping the order in which chats were found
+func groupDigestsByChat(days []goodDay) []*digest {
+	var digests []*digest
+	mapDigests := make(map[int64]*digest)
+	for _, day := range days {
+		d, ok := mapDigests[day.bookmark.ChatID]
+		if !ok {
+			d = &digest{
+				chatID: day.bookmark.ChatID,
+				userID: day.bookmark.UserID,
+			}
+			mapDigests[day.bookmark.ChatID] = d
+			digests = append(digests, d)
+		}
+		d.days = append(d.days, day)
+	}
+	return digests
+}
+
+// renders the digest text, grouping days either by date or by location
+func (d *digest) render(grouping int) string {
+
+	days := make([]goodDay, len(d.days))
+	copy(days, d.days)
+
+	var keyFn func(goodDay) string
+	var lineFn func(goodDay) string
+
+	if grouping == groupByLocation {
+		sort.SliceStable(days, func(i, j int) bool {
+			if days[i].bookmark.ID != days[j].bookmark.ID {
+				return days[i].bookmark.ID < days[j].bookmark.ID
+			}
+			return days[i].date.Before(days[j].date)
+		})
+		keyFn = func(day goodDay) string { return "📍 " + escapeMarkdown(siteTitle(day)) }
+		lineFn = func(day goodDay) string { return day.date.Format("02 Jan, Mon") }
+	} else {
+		sort.SliceStable(days, func(i, j int) bool {
+			return days[i].date.Before(days[j].date)
+		})
+		keyFn = func(day goodDay) string { return "📅 " + day.date.Format("Mon, 02 Jan 2006") }
+		lineFn = func(day goodDay) string { return escapeMarkdown(siteTitle(day)) }
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("Hey, good weather will be at: \n")
+	previousKey := ""
+	for _, day := range days {
+		if key := keyFn(day); key != previousKey {
+			buffer.WriteString("\n*" + key + "*\n")
+			previousKey = key
+		}
+		if day.run != nil {
+			buffer.WriteString(fmt.Sprintf(" - %c %s: %s (temp from %d˚C, wind up to %dmph, precipitation probability up to %d%%) \n",
+				mapWeatherTypes[day.weatherType].icon,
+				escapeMarkdown(siteTitle(day))+bestMembers(day),
+				day.run.describe(day.bookmark.Mode == ModeNight),
+				day.temp,
+				day.wind,
+				day.precip))
+			continue
+		}
+		if day.bookmark.Mode == ModeNight {
+			buffer.WriteString(fmt.Sprintf(" - %c %s on %s in %s (min temp %d˚C, wind is %dmph, precipitation probability is %d%%, moon: %s) \n",
+				mapWeatherTypes[day.weatherType].icon,
+				strings.ToLower(mapWeatherTypes[day.weatherType].name),
+				day.date.Format("Mon"),
+				escapeMarkdown(siteTitle(day))+bestMembers(day),
+				day.temp,
+				day.wind,
+				day.precip,
+				day.moon))
+			continue
+		}
+
+		buffer.WriteString(fmt.Sprintf(" - %c %s (day temp %d˚C, wind is %dmph and precipitation probability is %d%%) \n",
+			mapWeatherTypes[day.weatherType].icon,
+			lineFn(day)+bestMembers(day),
+			day.temp,
+			day.wind,
+			day.precip))
+
+		if sun, ok := sunTimesFor(day.date, day.site.Latitude, day.site.Longitude); ok {
+			buffer.WriteString("    " + sun.String() + "\n")
+		}
+	}
+
+	return buffer.String()
+}
+
+// returns the bookmarks from the digest, each one only once
+func (d *digest) bookmarks() []goodDay {
+	var result []goodDay
+	seen := make(map[int]bool)
+	for _, day := range d.days {
+		if !seen[day.bookmark.ID] {
+			seen[day.bookmark.ID] = true
+			result = append(result, day)
+		}
+	}
+	return result
+}
+
+func siteTitle(day goodDay) string {
+	if len(day.bookmark.GroupName) > 0 {
+		return day.bookmark.GroupName
+	}
+	if len(day.site.Name) > 0 {
+		return day.site.Name
+	}
+	return day.bookmark.LocationID
+}
+
+// names the best sites for the location groups
+func bestMembers(day goodDay) string {
+	if len(day.members) == 0 {
+		return ""
+	}
+	return ", best at " + escapeMarkdown(day.members)
+}
+
+// sends the digest, splitting it to several messages if necessary. The buttons are attached to the last one
+func sendDigest(bot *tgbotapi.BotAPI, d *digest, grouping int) {
+	chunks := splitMessage(d.render(grouping), maxMessageLength)
+
+	var msg tgbotapi.Message
+	for _, chunk := range chunks {
+		msg, _ = sendMsg(bot, d.chatID, chunk)
+	}
+
+	renderDigestButtons(bot, d.chatID, msg.MessageID, d.bookmarks())
+}
+
+// splits the long text to several chunks, each one is not longer than the limit (in UTF-16 code units,
+// as Telegram counts). Text is split by lines where possible, so we don't break Markdown in the middle of line.
+// Please refer to unit tests
+func splitMessage(text string, limit int) []string {
+	var chunks []string
+	var current strings.Builder
+	currentLen := 0
+
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentLen = 0
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		lineLen := utf16Len(line)
+
+		if currentLen+lineLen > limit {
+			flush()
+		}
+
+		// the line itself is too long, so split it by runes
+		for lineLen > limit {
+			head, tail := cutByUTF16(line, limit)
+			chunks = append(chunks, head)
+			line = tail
+			lineLen = utf16Len(line)
+		}
+
+		current.WriteString(line)
+		currentLen += lineLen
+	}
+	flush()
+
+	return chunks
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// cuts the string so the head is not longer than the limit in UTF-16 code units
+func cutByUTF16(s string, limit int) (string, string) {
+	length := 0
+	for i, r := range s {
+		length += len(utf16.Encode([]rune{r}))
+		if length > limit {
+			return s[:i], s[i:]
+		}
+	}
+	return s, ""
+}
+
+// renders buttons for every location mentioned in the digest: show forecast and stop observing
+func renderDigestButtons(bot *tgbotapi.BotAPI, chatID int64, messageID int, days []goodDay) {
+	if len(days) == 0 {
+		return
+	}
+
+	buttonRows := make([][]tgbotapi.InlineKeyboardButton, len(days))
+	for i, day := range days {
+		name := siteTitle(day)
+		buttonRows[i] = []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("📍 "+name, callbackData(ButtonLocationPrefix, day.bookmark.LocationID)),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Stop observing", callbackData(ButtonDeleteBookmark, fmt.Sprint(day.bookmark.ID))),
+		}
+	}
+
+	keyboard := newInlineKeyboard(buttonRows...)
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, keyboard)
+	bot.Send(keyboardMsg)
+}
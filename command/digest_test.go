@@ -0,0 +1,197 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+func TestSplitMessage(t *testing.T) {
+
+	var dataSet = []struct {
+		text           string
+		limit          int
+		expectedChunks []string
+	}{
+		{"short text", 100, []string{"short text"}},
+		{"line1\nline2\nline3\n", 12, []string{"line1\nline2\n", "line3\n"}},
+		{"line1\nline2\nline3", 6, []string{"line1\n", "line2\n", "line3"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"☀☀☀", 2, []string{"☀☀", "☀"}},     // BMP runes take one UTF-16 unit
+		{"🌧🌧🌧", 2, []string{"🌧", "🌧", "🌧"}}, // but this one takes two of them
+		{"", 10, nil},
+	}
+
+	for _, tt := range dataSet {
+		t.Run(fmt.Sprintf("Split %q by %d", tt.text, tt.limit),
+			func(t *testing.T) {
+
+				// When:
+				chunks := splitMessage(tt.text, tt.limit)
+
+				// Then:
+				assert.Equal(t, tt.expectedChunks, chunks)
+			})
+	}
+}
+
+func TestSplitLongDigestIsUnderTheLimit(t *testing.T) {
+
+	// Given:
+	text := strings.Repeat(" - ☀ Keswick (day temp 14˚C, wind is 8mph and precipitation probability is 10%) \n", 200)
+
+	// When:
+	chunks := splitMessage(text, maxMessageLength)
+
+	// Then:
+	assert.True(t, len(chunks) > 1)
+	assert.Equal(t, text, strings.Join(chunks, ""))
+	for _, chunk := range chunks {
+		assert.True(t, utf16Len(chunk) <= maxMessageLength)
+		assert.True(t, strings.HasSuffix(chunk, "\n"))
+	}
+}
+
+func TestDigestGroupedByChat(t *testing.T) {
+
+	// Given:
+	days := []goodDay{
+		{bookmark: structs.UsersLocationBookmark{ID: 1, ChatID: 10, UserID: UserID}},
+		{bookmark: structs.UsersLocationBookmark{ID: 2, ChatID: 20, UserID: User2ID}},
+		{bookmark: structs.UsersLocationBookmark{ID: 1, ChatID: 10, UserID: UserID}},
+	}
+
+	// When:
+	digests := groupDigestsByChat(days)
+
+	// Then:
+	assert.Equal(t, 2, len(digests))
+	assert.Equal(t, int64(10), digests[0].chatID)
+	assert.Equal(t, 2, len(digests[0].days))
+	assert.Equal(t, 1, len(digests[0].bookmarks()))
+	assert.Equal(t, int64(20), digests[1].chatID)
+	assert.Equal(t, 1, len(digests[1].days))
+}
+
+func TestDigestRendering(t *testing.T) {
+
+	// Given:
+	sat := time.Date(2019, 10, 12, 0, 0, 0, 0, time.UTC)
+	sun := sat.AddDate(0, 0, 1)
+	keswick := structs.UsersLocationBookmark{ID: 1, LocationID: "1", ChatID: 10}
+	london := structs.UsersLocationBookmark{ID: 2, LocationID: "2", ChatID: 10}
+	d := digest{
+		chatID: 10,
+		days: []goodDay{
+			{bookmark: keswick, site: structs.SiteLocation{Name: "Keswick"}, date: sun, weatherType: 1},
+			{bookmark: london, site: structs.SiteLocation{Name: "London"}, date: sat, weatherType: 1},
+			{bookmark: keswick, site: structs.SiteLocation{Name: "Keswick"}, date: sat, weatherType: 1},
+		},
+	}
+
+	// When:
+	byDate := d.render(groupByDate)
+	byLocation := d.render(groupByLocation)
+
+	// Then:
+	assert.Equal(t, 1, strings.Count(byDate, "Sat, 12 Oct 2019"))
+	assert.Equal(t, 1, strings.Count(byDate, "Sun, 13 Oct 2019"))
+	assert.True(t, strings.Index(byDate, "Sat, 12 Oct") < strings.Index(byDate, "Sun, 13 Oct"))
+
+	assert.Equal(t, 1, strings.Count(byLocation, "📍 Keswick"))
+	assert.Equal(t, 1, strings.Count(byLocation, "📍 London"))
+	assert.True(t, strings.Index(byLocation, "12 Oct, Sat") < strings.Index(byLocation, "13 Oct, Sun"))
+}
+
+func TestDigestRenderingEscapesMarkdown(t *testing.T) {
+
+	// Given: names with Markdown characters
+	sat := time.Date(2019, 10, 12, 0, 0, 0, 0, time.UTC)
+	group := structs.UsersLocationBookmark{ID: 2, ChatID: 10, LocationID: "2", GroupName: "*Peak* District", GroupLocationIDs: []string{"2", "3"}}
+	d := digest{
+		chatID: 10,
+		days: []goodDay{
+			{bookmark: structs.UsersLocationBookmark{ID: 1, ChatID: 10}, site: structs.SiteLocation{Name: "Loch_Ness"}, date: sat, weatherType: 1},
+			{bookmark: group, site: structs.SiteLocation{Name: "Castleton"}, date: sat, weatherType: 1, members: "Castleton, [Edale]"},
+		},
+	}
+
+	// When:
+	byDate := d.render(groupByDate)
+	byLocation := d.render(groupByLocation)
+
+	// Then:
+	assert.Contains(t, byDate, "Loch\\_Ness (")
+	assert.Contains(t, byDate, "\\*Peak\\* District, best at Castleton, \\[Edale] (")
+	assert.Contains(t, byLocation, "📍 Loch\\_Ness*")
+	assert.Contains(t, byLocation, "📍 \\*Peak\\* District*")
+}
+
+func TestDigestRenderingWithSunTimes(t *testing.T) {
+
+	// Given:
+	sat := time.Date(2019, 10, 12, 0, 0, 0, 0, time.UTC)
+	keswick := structs.SiteLocation{Name: "Keswick", Latitude: "54.6007", Longitude: "-3.1343"}
+	d := digest{
+		chatID: 10,
+		days: []goodDay{
+			{bookmark: structs.UsersLocationBookmark{ID: 1, ChatID: 10}, site: keswick, date: sat, weatherType: 1},
+			{bookmark: structs.UsersLocationBookmark{ID: 2, ChatID: 10}, site: structs.SiteLocation{Name: "Nowhere"}, date: sat, weatherType: 1},
+		},
+	}
+
+	// When:
+	text := d.render(groupByDate)
+
+	// Then: sunrise, sunset and golden hours are shown only where we know coordinates
+	assert.Equal(t, 1, strings.Count(text, "🌅"))
+	assert.Contains(t, text, "golden hours 06:3")
+}
+
+func TestDigestRenderingOfLocationGroup(t *testing.T) {
+
+	// Given:
+	sat := time.Date(2019, 10, 12, 0, 0, 0, 0, time.UTC)
+	group := structs.UsersLocationBookmark{ID: 1, ChatID: 10, LocationID: "2", GroupName: "Peak District", GroupLocationIDs: []string{"1", "2"}}
+	d := digest{
+		chatID: 10,
+		days: []goodDay{
+			{bookmark: group, site: structs.SiteLocation{Name: "Castleton"}, date: sat, weatherType: 1, members: "Castleton, Edale"},
+		},
+	}
+
+	// When:
+	byDate := d.render(groupByDate)
+	byLocation := d.render(groupByLocation)
+
+	// Then: the group is named once, with the best places
+	assert.Contains(t, byDate, "Peak District, best at Castleton, Edale (")
+	assert.Contains(t, byLocation, "📍 Peak District")
+	assert.Contains(t, byLocation, "12 Oct, Sat, best at Castleton, Edale (")
+}
+
+func TestHazardWarningsRendering(t *testing.T) {
+
+	// Given:
+	sat := time.Date(2019, 10, 12, 0, 0, 0, 0, time.UTC)
+	sun := sat.AddDate(0, 0, 1)
+	allotment := structs.UsersLocationBookmark{ID: 1, LocationID: "1", ChatID: 10, Polarity: PolarityHazard}
+	moorings := structs.UsersLocationBookmark{ID: 2, LocationID: "2", ChatID: 10, Polarity: PolarityHazard, GroupName: "Moorings"}
+	days := []goodDay{
+		{bookmark: allotment, site: structs.SiteLocation{Name: "Keswick"}, date: sun, hazards: "🧊 frost (-2˚C)"},
+		{bookmark: moorings, site: structs.SiteLocation{Name: "London"}, date: sat, hazards: "💨 gale (45mph)"},
+	}
+
+	// When:
+	text := renderHazardWarnings(days)
+
+	// Then:
+	assert.True(t, strings.HasPrefix(text, "⚠️ Bad weather warning"))
+	assert.Contains(t, text, "Sun, 13 Oct in Keswick: 🧊 frost (-2˚C)")
+	assert.Contains(t, text, "Sat, 12 Oct in London (Moorings): 💨 gale (45mph)")
+	assert.True(t, strings.Index(text, "Sat, 12 Oct") < strings.Index(text, "Sun, 13 Oct"))
+}
@@ -0,0 +1,139 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// wind direction sectors, 45˚ each. The wind direction is where the wind comes from
+const (
+	sectorN = 1 << iota
+	sectorNE
+	sectorE
+	sectorSE
+	sectorS
+	sectorSW
+	sectorW
+	sectorNW
+)
+
+type windSector struct {
+	flag  int
+	name  string
+	arrow rune // where the wind blows to, so the northern wind is drawn as "↓"
+}
+
+// in the clockwise order starting from North
+var windSectors = []windSector{
+	{sectorN, "N", '↓'},
+	{sectorNE, "NE", '↙'},
+	{sectorE, "E", '←'},
+	{sectorSE, "SE", '↖'},
+	{sectorS, "S", '↑'},
+	{sectorSW, "SW", '↗'},
+	{sectorW, "W", '→'},
+	{sectorNW, "NW", '↘'},
+}
+
+// 16-point compass used by MetOffice, in the clockwise order starting from North
+var compassPoints = []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+
+var windDirectionsSetting = toggleSetting{
+	field:    "WindDirections",
+	value:    func(bookmark *structs.UsersLocationBookmark) *int { return &bookmark.WindDirections },
+	keyboard: renderWindDirectionsKeyboard,
+}
+
+// returns the sectors the compass direction belongs to. The main points belong to one sector, but the
+// intermediate ones, such as "NNE", lie exactly on the border and belong to both neighbours.
+// Returns 0 if the direction is unknown.
+// Please refer to unit tests
+func directionSectors(compass string) int {
+	for i, point := range compassPoints {
+		if point != compass {
+			continue
+		}
+		if i%2 == 0 {
+			return windSectors[i/2].flag
+		}
+		return windSectors[i/2].flag | windSectors[(i/2+1)%len(windSectors)].flag
+	}
+	return 0
+}
+
+// returns the arrow for the compass direction, or space if the direction is unknown
+func directionArrow(compass string) rune {
+	for i, point := range compassPoints {
+		if point == compass {
+			// round to the nearest sector, so "NNE" is drawn as "NE"
+			return windSectors[((i+1)/2)%len(windSectors)].arrow
+		}
+	}
+	return ' '
+}
+
+// human readable list of sectors, for example "N, NE"
+func windDirectionNames(directions int) string {
+	var names []string
+	for _, sector := range windSectors {
+		if directions&sector.flag != 0 {
+			names = append(names, sector.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// WindDirectionFlags converts names of sectors, such as "N" or "SW", to the bit mask, case insensitive
+func WindDirectionFlags(names []string) (int, error) {
+	flags := 0
+	for _, name := range names {
+		found := false
+		for _, sector := range windSectors {
+			if strings.ToUpper(name) == sector.name {
+				flags |= sector.flag
+				found = true
+			}
+		}
+		if !found {
+			return 0, errors.New("unknown wind direction '" + name + "'")
+		}
+	}
+	return flags, nil
+}
+
+// renders the compass rose: 8 toggle buttons around the "Done" one
+func renderWindDirectionsKeyboard(bookmark *structs.UsersLocationBookmark) tgbotapi.InlineKeyboardMarkup {
+	strBookmarkID := strconv.Itoa(bookmark.ID)
+
+	button := func(flag int, name string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(toggleLabel(bookmark.WindDirections&flag != 0, name),
+			callbackData(ButtonWindDirection, strBookmarkID, strconv.Itoa(flag)))
+	}
+
+	return newInlineKeyboard(
+		[]tgbotapi.InlineKeyboardButton{button(sectorNW, "NW"), button(sectorN, "N"), button(sectorNE, "NE")},
+		[]tgbotapi.InlineKeyboardButton{
+			button(sectorW, "W"),
+			tgbotapi.NewInlineKeyboardButtonData("👌 Done", callbackData(ButtonWindDirection, strBookmarkID, ButtonDone)),
+			button(sectorE, "E"),
+		},
+		[]tgbotapi.InlineKeyboardButton{button(sectorSW, "SW"), button(sectorS, "S"), button(sectorSE, "SE")},
+	)
+}
+
+// sends the message with the compass rose
+func askWindDirections(bot *tgbotapi.BotAPI, chatID int64, bookmark *structs.UsersLocationBookmark) {
+	msg, err := sendMsg(bot, chatID, "Where should the wind come from? For example, onshore wind for kitesurfing. "+
+		"Tick the acceptable directions and click Done; leave all of them empty if any direction is fine:")
+	if err != nil {
+		return
+	}
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, msg.MessageID, renderWindDirectionsKeyboard(bookmark))
+	bot.Send(keyboardMsg)
+}
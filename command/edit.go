@@ -0,0 +1,172 @@
+package command
+
+import (
+	"strconv"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// one setting of a saved bookmark that can be changed with /edit
+type editOption struct {
+	key   string
+	label string
+	fnAsk func(bot *tgbotapi.BotAPI, chatID int64, bookmark *structs.UsersLocationBookmark)
+
+	// saves the answer sent as "E#<bookmark id>#<option>#<value>"; options that use their own
+	// keyboards (such as toggles) don't need it
+	fnSave func(bookmarks BookmarkRepository, bookmark *structs.UsersLocationBookmark, value string) error
+}
+
+var editOptions = []editOption{
+	{"weather", "🌫 Excluded weather", askExcludedWeather, nil},
+	{"direction", "🧭 Wind directions", askWindDirections, nil},
+	{"golden", "🌅 Golden hour only", askGoldenHourOnly, saveGoldenHourOnly},
+	{"run", "🏕 Good days in a row", askMinRunLength, saveMinRunLength},
+	{"hazards", "⚠ Bad weather warnings", askHazards, nil},
+}
+
+const maxRunLength = 4 // the forecast is only five days long
+
+// StartEditingBookmark shows the list of saved bookmarks, so user can choose which one to change
+func StartEditingBookmark(bot *tgbotapi.BotAPI, repos Repositories, chatID int64, userID int) {
+	bookmarks, _ := repos.Bookmarks.FindReady(userID)
+
+	if len(bookmarks) == 0 {
+		sendMsg(bot, chatID, "No saved locations yet. Please type /add to add one")
+		return
+	}
+
+	mapLocs := getMapOfLocations(bookmarks, repos.Sites)
+
+	buttonRows := make([][]tgbotapi.InlineKeyboardButton, len(bookmarks))
+	for i, bookmark := range bookmarks {
+		buttonRows[i] = []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("✏ "+bookmarkTitle(bookmark, mapLocs), callbackData(ButtonEditBookmark, strconv.Itoa(bookmark.ID))),
+		}
+	}
+
+	msg, err := sendMsg(bot, chatID, "Which location would you like to change?")
+	if err != nil {
+		return
+	}
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, msg.MessageID, newInlineKeyboard(buttonRows...))
+	bot.Send(keyboardMsg)
+}
+
+// processes clicks on the edit buttons: "E#<bookmark id>" shows the menu, "E#<bookmark id>#<option>" asks for the new value
+func processEditButton(bot *tgbotapi.BotAPI, repos Repositories, callbackQuery *tgbotapi.CallbackQuery, parts []string) {
+	chatID := callbackQuery.Message.Chat.ID
+
+	intBookmarkID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		sentry.CaptureException(errors.Wrap(err, "Can't parse bookmarkID, expected valid number, but got: "+parts[1]))
+		return
+	}
+
+	bookmark, err := repos.Bookmarks.Get(intBookmarkID)
+	if err != nil || bookmark.UserID != callbackQuery.From.ID {
+		sendMsg(bot, chatID, "Can't find a bookmark")
+		return
+	}
+
+	if len(parts) > 2 {
+		for _, option := range editOptions {
+			if option.key != parts[2] {
+				continue
+			}
+
+			if len(parts) > 3 && option.fnSave != nil {
+				removeButtons(bot, chatID, callbackQuery.Message.MessageID)
+				if err := option.fnSave(repos.Bookmarks, &bookmark, parts[3]); err != nil {
+					sentry.CaptureException(err)
+					sendMsg(bot, chatID, "Sorry, internal error occurred, can't save your choice. Please try again later.")
+					return
+				}
+				sendMsg(bot, chatID, "✅ Saved. You can see all saved bookmarks using the command \n /locations")
+				return
+			}
+
+			option.fnAsk(bot, chatID, &bookmark)
+			return
+		}
+		return
+	}
+
+	mapLocs := getMapOfLocations([]structs.UsersLocationBookmark{bookmark}, repos.Sites)
+
+	buttonRows := make([][]tgbotapi.InlineKeyboardButton, len(editOptions))
+	for i, option := range editOptions {
+		buttonRows[i] = []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(option.label, callbackData(ButtonEditBookmark, parts[1], option.key)),
+		}
+	}
+
+	msg, err := sendMsg(bot, chatID, "What would you like to change for "+bookmarkTitle(bookmark, mapLocs)+"?")
+	if err != nil {
+		return
+	}
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, msg.MessageID, newInlineKeyboard(buttonRows...))
+	bot.Send(keyboardMsg)
+}
+
+// asks whether to check only golden hours, the answer is saved by saveGoldenHourOnly
+func askGoldenHourOnly(bot *tgbotapi.BotAPI, chatID int64, bookmark *structs.UsersLocationBookmark) {
+	if bookmark.Mode == ModeNight {
+		sendMsg(bot, chatID, "This location is watched for clear nights, golden hours are for days only")
+		return
+	}
+
+	msg, err := sendMsg(bot, chatID, "Landscape photographers care only about the light around sunrise and sunset. "+
+		"Should I check only the golden hours for this location?")
+	if err != nil {
+		return
+	}
+
+	strBookmarkID := strconv.Itoa(bookmark.ID)
+	rowButtons := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData(toggleLabel(bookmark.GoldenHourOnly, "Golden hours only"), callbackData(ButtonEditBookmark, strBookmarkID, "golden", "on")),
+		tgbotapi.NewInlineKeyboardButtonData(toggleLabel(!bookmark.GoldenHourOnly, "The whole day"), callbackData(ButtonEditBookmark, strBookmarkID, "golden", "off")),
+	}
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, msg.MessageID, newInlineKeyboard(rowButtons))
+	bot.Send(keyboardMsg)
+}
+
+func saveGoldenHourOnly(bookmarks BookmarkRepository, bookmark *structs.UsersLocationBookmark, value string) error {
+	return bookmarks.UpdateField(bookmark, "GoldenHourOnly", value == "on")
+}
+
+// asks how many good days in a row are needed, the answer is saved by saveMinRunLength
+func askMinRunLength(bot *tgbotapi.BotAPI, chatID int64, bookmark *structs.UsersLocationBookmark) {
+	msg, err := sendMsg(bot, chatID, "Going camping? I can tell you only about several good days in a row. "+
+		"How many days do you need?")
+	if err != nil {
+		return
+	}
+
+	strBookmarkID := strconv.Itoa(bookmark.ID)
+	rowButtons := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData(toggleLabel(bookmark.MinRunLength <= 1, "Any day"), callbackData(ButtonEditBookmark, strBookmarkID, "run", "1")),
+	}
+	for length := 2; length <= maxRunLength; length++ {
+		rowButtons = append(rowButtons, tgbotapi.NewInlineKeyboardButtonData(
+			toggleLabel(bookmark.MinRunLength == length, strconv.Itoa(length)), callbackData(ButtonEditBookmark, strBookmarkID, "run", strconv.Itoa(length))))
+	}
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, msg.MessageID, newInlineKeyboard(rowButtons))
+	bot.Send(keyboardMsg)
+}
+
+func saveMinRunLength(bookmarks BookmarkRepository, bookmark *structs.UsersLocationBookmark, value string) error {
+	length, err := strconv.Atoi(value)
+	if err != nil || length < 1 || length > maxRunLength {
+		return errors.Errorf("Unexpected run length: %s", value)
+	}
+	return bookmarks.UpdateField(bookmark, "MinRunLength", length)
+}
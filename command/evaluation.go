@@ -0,0 +1,269 @@
+package command
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+const (
+	ModeDay   = 0 // looks for good days, this is the default one
+	ModeNight = 1 // looks for clear nights, for astronomers
+
+	weatherClearNight        = 0
+	weatherPartlyCloudyNight = 2
+)
+
+type (
+	// Criterion is one evaluated figure compared with the threshold from a bookmark
+	Criterion struct {
+		Name      string `json:"name"`
+		Value     string `json:"value"`
+		Operator  string `json:"operator"`
+		Threshold string `json:"threshold"`
+		Unit      string `json:"unit"`
+		Passed    bool   `json:"passed"`
+	}
+
+	// DayEvaluation contains everything the checker knows about one day for one bookmark,
+	// so we can explain why the bot decided that the day is good or not
+	DayEvaluation struct {
+		Date           string      `json:"date"`
+		Weekday        string      `json:"weekday"`
+		Skipped        bool        `json:"skipped"` // the day is not in the period chosen by user, so not checked at all
+		WeatherType    int         `json:"weatherType"`
+		Temperature    int         `json:"temperature"`
+		MaxTemperature int         `json:"maxTemperature"`
+		Wind           int         `json:"wind"`
+		Gust           int         `json:"gust"`
+		Humidity       int         `json:"humidity"`
+		PrecipProb     int         `json:"precipProb"`
+		UV             int         `json:"uv"`
+		Visibility     string      `json:"visibility"`
+		IsNight        bool        `json:"isNight"`
+		Moon           string      `json:"moon,omitempty"`
+		GoldenHours    string      `json:"goldenHours,omitempty"`
+		Criteria       []Criterion `json:"criteria"`
+		IsSuitable     bool        `json:"isSuitable"`
+		IsHazardous    bool        `json:"isHazardous,omitempty"` // for bad weather warnings, any of hazards is forecast
+
+		date time.Time
+	}
+)
+
+// EvaluateBookmark checks every day from the forecast against the bookmark thresholds.
+// It doesn't send anything anywhere, so it is safe to use in tools and tests. Days that
+// can't be parsed are ignored, the last such error is returned.
+// The forecast is daily, except golden hour bookmarks that need the 3-hourly one, see ForecastFor
+func EvaluateBookmark(bookmark structs.UsersLocationBookmark, forecast *structs.RootSiteRep) ([]DayEvaluation, error) {
+	evaluate := EvaluateDay
+	if isGoldenHourOnly(bookmark) {
+		location := forecast.SiteRep.Dv.Location
+		evaluate = func(bookmark structs.UsersLocationBookmark, day structs.Period) (DayEvaluation, error) {
+			return EvaluateGoldenHours(bookmark, day, location.Latitude, location.Longitude)
+		}
+	}
+
+	var evaluations []DayEvaluation
+	var lastErr error
+	for _, day := range forecast.SiteRep.Dv.Location.Periods {
+
+		evaluation, err := evaluate(bookmark, day)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		evaluations = append(evaluations, evaluation)
+	}
+	return evaluations, lastErr
+}
+
+// EvaluateDay decides whether one day is "good" or "naaah" for the given bookmark
+func EvaluateDay(bookmark structs.UsersLocationBookmark, day structs.Period) (DayEvaluation, error) {
+	evaluation, err := newDayEvaluation(bookmark, day)
+	if err != nil || evaluation.Skipped {
+		return evaluation, err
+	}
+
+	if bookmark.Polarity == PolarityHazard {
+		evaluation.Criteria = evaluateHazards(bookmark, day, &evaluation)
+		evaluation.IsHazardous = !allPassed(evaluation.Criteria)
+	} else if bookmark.Mode == ModeNight {
+		evaluation.Criteria = evaluateNight(bookmark, day, &evaluation)
+	} else {
+		evaluation.Criteria = evaluateDaytime(bookmark, day, &evaluation)
+	}
+
+	evaluation.IsSuitable = allPassed(evaluation.Criteria)
+
+	return evaluation, nil
+}
+
+// parses the date of the day and checks whether user wants this day to be checked at all
+func newDayEvaluation(bookmark structs.UsersLocationBookmark, day structs.Period) (DayEvaluation, error) {
+
+	// parse date
+	t, err := time.Parse(layoutMetofficeDate, day.Value)
+	if err != nil {
+		return DayEvaluation{}, err
+	}
+
+	evaluation := DayEvaluation{
+		Date:    t.Format("2006-01-02"),
+		Weekday: t.Weekday().String(),
+		date:    t,
+	}
+
+	evaluation.Skipped = !shouldBotherForWeekdays(bookmark.CheckPeriod, t.Weekday())
+	return evaluation, nil
+}
+
+func allPassed(criteria []Criterion) bool {
+	for _, criterion := range criteria {
+		if !criterion.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// checks the day part of the forecast (Rep[0])
+func evaluateDaytime(bookmark structs.UsersLocationBookmark, day structs.Period, evaluation *DayEvaluation) []Criterion {
+	figures := parseNumberFigures(day)
+	evaluation.setDayFigures(figures)
+
+	return daytimeCriteria(bookmark, figures)
+}
+
+func (e *DayEvaluation) setDayFigures(figures dayFigures) {
+	e.Temperature = figures.feelsLikeTemp
+	e.MaxTemperature = figures.maxTemp
+	e.Wind = figures.wind
+	e.Gust = figures.gust
+	e.Humidity = figures.humidity
+	e.PrecipProb = figures.precipProb
+	e.WeatherType = figures.weatherType
+	e.UV = figures.uv
+	e.Visibility = figures.visibility
+}
+
+// compares the day figures with the bookmark thresholds, the figures could come either from
+// the daily forecast or from one 3-hour step
+func daytimeCriteria(bookmark structs.UsersLocationBookmark, figures dayFigures) []Criterion {
+	criteria := []Criterion{
+		newCriterion("temperature", figures.feelsLikeTemp, ">", bookmark.LowestTemp, "˚C", figures.feelsLikeTemp > bookmark.LowestTemp),
+		newCriterion("wind", figures.wind, "<", bookmark.MaxWindSpeed, "mph", figures.wind < bookmark.MaxWindSpeed),
+		precipitationCriterion(bookmark, figures.precipProb),
+	}
+
+	// optional bounds, they are checked only if user set them
+	if bookmark.MaxGust != nil {
+		criteria = append(criteria,
+			newCriterion("gust", figures.gust, "<=", *bookmark.MaxGust, "mph", figures.gust <= *bookmark.MaxGust))
+	}
+	if bookmark.MaxTemp != nil {
+		criteria = append(criteria,
+			newCriterion("max temperature", figures.maxTemp, "<=", *bookmark.MaxTemp, "˚C", figures.maxTemp <= *bookmark.MaxTemp))
+	}
+	if bookmark.MaxHumidity != nil {
+		criteria = append(criteria,
+			newCriterion("humidity", figures.humidity, "<=", *bookmark.MaxHumidity, "%", figures.humidity <= *bookmark.MaxHumidity))
+	}
+	if bookmark.MaxUV != nil {
+		criteria = append(criteria,
+			newCriterion("uv", figures.uv, "<=", *bookmark.MaxUV, "", figures.uv <= *bookmark.MaxUV))
+	}
+
+	if bookmark.MinVisibility > 0 {
+		criteria = append(criteria, Criterion{
+			Name:      "visibility",
+			Value:     figures.visibility,
+			Operator:  ">=",
+			Threshold: visibilityCode(bookmark.MinVisibility),
+			Passed:    visibilityLevel(figures.visibility) >= bookmark.MinVisibility,
+		})
+	}
+
+	if bookmark.ExcludedWeather != 0 {
+		criteria = append(criteria, Criterion{
+			Name:      "weather",
+			Value:     mapWeatherTypes[figures.weatherType].name,
+			Operator:  "not",
+			Threshold: excludedWeatherNames(bookmark.ExcludedWeather),
+			Passed:    !isWeatherTypeExcluded(bookmark.ExcludedWeather, figures.weatherType),
+		})
+	}
+
+	if bookmark.WindDirections != 0 {
+		criteria = append(criteria, Criterion{
+			Name:      "wind direction",
+			Value:     figures.direction,
+			Operator:  "in",
+			Threshold: windDirectionNames(bookmark.WindDirections),
+			Passed:    bookmark.WindDirections&directionSectors(figures.direction) != 0,
+		})
+	}
+
+	return criteria
+}
+
+// checks the night part of the forecast (Rep[1]): the sky should be clear, calm and dry
+func evaluateNight(bookmark structs.UsersLocationBookmark, day structs.Period, evaluation *DayEvaluation) []Criterion {
+	evaluation.IsNight = true
+	evaluation.Moon = describeMoon(evaluation.date)
+
+	if len(day.Rep) < 2 {
+		return []Criterion{{Name: "night forecast", Value: "missing", Passed: false}}
+	}
+
+	figures := parseNightFigures(day)
+	evaluation.Temperature = figures.minTemp
+	evaluation.Wind = figures.wind
+	evaluation.Gust = figures.gust
+	evaluation.Humidity = figures.humidity
+	evaluation.PrecipProb = figures.precipProb
+	evaluation.WeatherType = figures.weatherType
+	evaluation.Visibility = figures.visibility
+
+	isClearSky := figures.weatherType == weatherClearNight || figures.weatherType == weatherPartlyCloudyNight
+
+	criteria := []Criterion{
+		{
+			Name:      "sky",
+			Value:     mapWeatherTypes[figures.weatherType].name,
+			Operator:  "in",
+			Threshold: mapWeatherTypes[weatherClearNight].name + ", " + mapWeatherTypes[weatherPartlyCloudyNight].name,
+			Passed:    isClearSky,
+		},
+		newCriterion("wind", figures.wind, "<", bookmark.MaxWindSpeed, "mph", figures.wind < bookmark.MaxWindSpeed),
+		precipitationCriterion(bookmark, figures.precipProb),
+	}
+
+	if bookmark.MinNightTemp != nil {
+		criteria = append(criteria,
+			newCriterion("night temperature", figures.minTemp, ">=", *bookmark.MinNightTemp, "˚C", figures.minTemp >= *bookmark.MinNightTemp))
+	}
+
+	return criteria
+}
+
+// the chance of rain is below 40% by default, unless user (or the activity profile) set the own limit
+func precipitationCriterion(bookmark structs.UsersLocationBookmark, precipProb int) Criterion {
+	if bookmark.MaxPrecipProb != nil {
+		return newCriterion("precipitation", precipProb, "<=", *bookmark.MaxPrecipProb, "%", precipProb <= *bookmark.MaxPrecipProb)
+	}
+	return newCriterion("precipitation", precipProb, "<", precipProbRain, "%", precipProb < precipProbRain)
+}
+
+func newCriterion(name string, value int, operator string, threshold int, unit string, passed bool) Criterion {
+	return Criterion{
+		Name:      name,
+		Value:     strconv.Itoa(value),
+		Operator:  operator,
+		Threshold: strconv.Itoa(threshold),
+		Unit:      unit,
+		Passed:    passed,
+	}
+}
@@ -0,0 +1,235 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// types of events
+const (
+	EventCommandUsed     = "command"          // Details is the command, such as "add"
+	EventBookmarkCreated = "bookmark-created" // the wizard is finished
+	EventBookmarkDeleted = "bookmark-deleted"
+	EventAlertSent       = "alert-sent"      // Details is the kind of alert and the date, such as "digest 2019-11-09"
+	EventDayChecked      = "day-checked"     // Details is the date and whether the day is good enough
+	EventBotStarted      = "bot-started"     // Details is the account of the bot
+	EventMigrated        = "migrated"        // Details is the description of the migration
+	EventWizardExpired   = "wizard-expired"  // Details is the step that was never answered
+	EventButtonRejected  = "button-rejected" // Details is the data of the outdated or forged button
+
+	maxEventsShown = 20 // how many events /events prints
+	eventsPeriod   = 7  // days that /events counts
+)
+
+var eventTypes = []string{
+	EventCommandUsed, EventBookmarkCreated, EventBookmarkDeleted, EventAlertSent, EventDayChecked, EventBotStarted, EventMigrated,
+	EventWizardExpired, EventButtonRejected,
+}
+
+// RecordEvent saves the event with the current time; errors are reported, because the event log should never
+// stop the bot from answering
+func RecordEvent(repos Repositories, event structs.Event) {
+	event.Time = time.Now().UTC()
+	if err := repos.Events.Record(&event); err != nil {
+		sentry.CaptureException(errors.Wrap(err, "can't record the event "+event.Type))
+	}
+}
+
+// RecordEvents saves the events of one job, such as the check of the weather, in one transaction
+func RecordEvents(repos Repositories, events []structs.Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	for i := range events {
+		events[i].Time = now
+	}
+	if err := repos.Events.RecordAll(events); err != nil {
+		sentry.CaptureException(errors.Wrapf(err, "can't record %d events", len(events)))
+	}
+}
+
+// PurgeEvents removes the events older than retentionDays, zero keeps them forever
+func PurgeEvents(repos Repositories, retentionDays int, now time.Time) (int, error) {
+	if retentionDays <= 0 {
+		return 0, nil
+	}
+	return repos.Events.DeleteBefore(now.AddDate(0, 0, -retentionDays))
+}
+
+// the notifications that are compacted by the janitor, there are many of them every night
+var compactedEventTypes = []string{EventAlertSent, EventDayChecked}
+
+// CompactEvents replaces the alerts and checked days older than compactDays with one summary per site and day,
+// such as "5 days checked, 2 suitable", and returns how many events were replaced. Only whole days are compacted,
+// so a summary never gets more events of its day later. Zero never compacts them
+func CompactEvents(repos Repositories, compactDays int, now time.Time) (int, error) {
+	if compactDays <= 0 {
+		return 0, nil
+	}
+	before := now.UTC().AddDate(0, 0, -compactDays).Truncate(24 * time.Hour)
+
+	var old, summaries []structs.Event
+	for _, eventType := range compactedEventTypes {
+		events, err := repos.Events.Find(EventFilter{Type: eventType, Before: before})
+		if err != nil {
+			return 0, err
+		}
+
+		// the events are the newest first, so summaries get the time of the oldest one
+		var keys []string
+		mapEvents := make(map[string][]structs.Event)
+		for i := len(events) - 1; i >= 0; i-- {
+			event := events[i]
+			key := fmt.Sprintf("%d %d %s %s", event.UserID, event.BookmarkID, event.LocationID, event.Time.UTC().Format("2006-01-02"))
+			if _, ok := mapEvents[key]; !ok {
+				keys = append(keys, key)
+			}
+			mapEvents[key] = append(mapEvents[key], event)
+		}
+
+		for _, key := range keys {
+			if dayEvents := mapEvents[key]; len(dayEvents) > 1 {
+				old = append(old, dayEvents...)
+				summaries = append(summaries, summarizeEvents(dayEvents))
+			}
+		}
+	}
+
+	if len(old) == 0 {
+		return 0, nil
+	}
+	return len(old), repos.Events.Compact(old, summaries)
+}
+
+// one event instead of several ones of the same type, site and day
+func summarizeEvents(events []structs.Event) structs.Event {
+	summary := events[0]
+	summary.ID = 0
+
+	if summary.Type == EventDayChecked {
+		suitable := 0
+		for _, event := range events {
+			if strings.HasSuffix(event.Details, " suitable") && !strings.HasSuffix(event.Details, " not suitable") {
+				suitable++
+			}
+		}
+		summary.Details = fmt.Sprintf("%d days checked, %d suitable", len(events), suitable)
+		return summary
+	}
+
+	details := make([]string, len(events))
+	for i, event := range events {
+		details[i] = event.Details
+	}
+	summary.Details = fmt.Sprintf("%d alerts: %s", len(events), strings.Join(details, ", "))
+	return summary
+}
+
+// parses arguments of /events: nothing, the type of events or the user ID
+func parseEventFilter(args string) (EventFilter, error) {
+	filter := EventFilter{Limit: maxEventsShown}
+
+	args = strings.TrimSpace(args)
+	if len(args) == 0 {
+		return filter, nil
+	}
+
+	if userID, err := strconv.Atoi(args); err == nil {
+		filter.UserID = userID
+		return filter, nil
+	}
+
+	for _, eventType := range eventTypes {
+		if args == eventType {
+			filter.Type = eventType
+			return filter, nil
+		}
+	}
+	return filter, fmt.Errorf("expected the type of events (%s) or the user ID", strings.Join(eventTypes, ", "))
+}
+
+// renders the number of events of every type for the period and the last matching events
+func renderEvents(counted, last []structs.Event) string {
+	counts := make(map[string]int)
+	for _, event := range counted {
+		counts[event.Type]++
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Events of the last %d days:\n", eventsPeriod))
+	if len(counts) == 0 {
+		buffer.WriteString(" none\n")
+	}
+	types := make([]string, 0, len(counts))
+	for eventType := range counts {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+	for _, eventType := range types {
+		buffer.WriteString(fmt.Sprintf(" %s: %d\n", eventType, counts[eventType]))
+	}
+
+	buffer.WriteString(fmt.Sprintf("\nThe last %d:\n", len(last)))
+	for _, event := range last {
+		buffer.WriteString(event.Time.Format("02 Jan 15:04") + " " + event.Type)
+		if event.UserID != 0 {
+			buffer.WriteString(" user " + strconv.Itoa(event.UserID))
+		}
+		if event.BookmarkID != 0 {
+			buffer.WriteString(" bookmark " + strconv.Itoa(event.BookmarkID))
+		}
+		if len(event.LocationID) > 0 {
+			buffer.WriteString(" location " + event.LocationID)
+		}
+		if len(event.Details) > 0 {
+			buffer.WriteString(": " + event.Details)
+		}
+		buffer.WriteRune('\n')
+	}
+	return buffer.String()
+}
+
+// SendEvents answers "/events [type or user ID]" of admins with the numbers of events and the last ones
+func SendEvents(app *App, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+
+	filter, err := parseEventFilter(message.CommandArguments())
+	if err != nil {
+		sendMsg(app.Bot, chatID, err.Error())
+		return
+	}
+
+	periodFilter := filter
+	periodFilter.Since, periodFilter.Limit = time.Now().AddDate(0, 0, -eventsPeriod), 0
+	counted, err := app.Repos.Events.Find(periodFilter)
+	if err != nil {
+		sentry.CaptureException(err)
+		sendMsg(app.Bot, chatID, "Sorry, can't load the events: "+err.Error())
+		return
+	}
+
+	last, err := app.Repos.Events.Find(filter)
+	if err != nil {
+		sentry.CaptureException(err)
+		sendMsg(app.Bot, chatID, "Sorry, can't load the events: "+err.Error())
+		return
+	}
+
+	// the details are typed by users, so the text is sent as it is, without Markdown
+	msg := tgbotapi.NewMessage(chatID, renderEvents(counted, last))
+	if _, err := app.Bot.Send(msg); err != nil {
+		sentry.CaptureException(err)
+	}
+}
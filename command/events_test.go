@@ -0,0 +1,152 @@
+package command
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+func TestPurgeEvents(t *testing.T) {
+
+	// Given:
+	now := time.Date(2019, 11, 8, 10, 0, 0, 0, time.UTC)
+	repos := prepareRepositories()
+	for _, age := range []int{100, 91, 89, 1} {
+		assert.Nil(t, repos.Events.Record(&structs.Event{Type: EventCommandUsed, Time: now.AddDate(0, 0, -age), UserID: UserID}))
+	}
+
+	// When: forever
+	count, err := PurgeEvents(repos, 0, now)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	// and when: 90 days
+	count, err = PurgeEvents(repos, 90, now)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	events, _ := repos.Events.Find(EventFilter{})
+	assert.Equal(t, 2, len(events))
+}
+
+func TestCompactEvents(t *testing.T) {
+
+	// Given: a night of checks and alerts 40 days ago, another one yesterday, and a command
+	now := time.Date(2019, 11, 8, 10, 0, 0, 0, time.UTC)
+	repos := prepareRepositories()
+	for _, night := range []time.Time{now.AddDate(0, 0, -40), now.AddDate(0, 0, -1)} {
+		assert.Nil(t, repos.Events.RecordAll([]structs.Event{
+			{Type: EventDayChecked, Time: night, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "2019-09-30 suitable"},
+			{Type: EventDayChecked, Time: night, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "2019-10-01 not suitable"},
+			{Type: EventDayChecked, Time: night, UserID: UserID, BookmarkID: 1, LocationID: TestLocation2ID, Details: "2019-09-30 suitable"},
+			{Type: EventAlertSent, Time: night, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "digest 2019-09-30"},
+			{Type: EventAlertSent, Time: night, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "uv 2019-09-30"},
+			{Type: EventCommandUsed, Time: night, UserID: UserID, Details: "add"},
+		}))
+	}
+
+	// When: never
+	count, err := CompactEvents(repos, 0, now)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	// and when: after 30 days
+	count, err = CompactEvents(repos, 30, now)
+
+	// Then: the old days of the same site are summarized, the single ones and the recent ones are kept
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count)
+
+	checked, _ := repos.Events.Find(EventFilter{Type: EventDayChecked, Before: now.AddDate(0, 0, -30)})
+	assert.Equal(t, 2, len(checked))
+	assert.Equal(t, "2 days checked, 1 suitable", checked[0].Details)
+	assert.Equal(t, TestLocationID, checked[0].LocationID)
+	assert.Equal(t, "2019-09-30 suitable", checked[1].Details)
+	assert.Equal(t, TestLocation2ID, checked[1].LocationID)
+
+	alerts, _ := repos.Events.Find(EventFilter{Type: EventAlertSent, Before: now.AddDate(0, 0, -30)})
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, "2 alerts: digest 2019-09-30, uv 2019-09-30", alerts[0].Details)
+	assert.Equal(t, now.AddDate(0, 0, -40), alerts[0].Time)
+
+	all, _ := repos.Events.Find(EventFilter{})
+	assert.Equal(t, 10, len(all))
+
+	// and when: again
+	count, err = CompactEvents(repos, 30, now)
+
+	// Then: the summaries are not compacted twice
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestRecordEvent(t *testing.T) {
+
+	// Given:
+	repos := prepareRepositories()
+	before := time.Now()
+
+	// When:
+	RecordEvent(repos, structs.Event{Type: EventBookmarkDeleted, UserID: UserID, BookmarkID: 42})
+
+	// Then: the time is set
+	events, _ := repos.Events.Find(EventFilter{Type: EventBookmarkDeleted})
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, 42, events[0].BookmarkID)
+	assert.False(t, events[0].Time.Before(before.Add(-time.Second)))
+}
+
+func TestParseEventFilter(t *testing.T) {
+	for _, tt := range []struct {
+		args     string
+		expected EventFilter
+		isError  bool
+	}{
+		{"", EventFilter{Limit: maxEventsShown}, false},
+		{" 111 ", EventFilter{UserID: UserID, Limit: maxEventsShown}, false},
+		{"alert-sent", EventFilter{Type: EventAlertSent, Limit: maxEventsShown}, false},
+		{"something", EventFilter{Limit: maxEventsShown}, true},
+	} {
+		t.Run(tt.args, func(t *testing.T) {
+			filter, err := parseEventFilter(tt.args)
+			assert.Equal(t, tt.expected, filter)
+			assert.Equal(t, tt.isError, err != nil)
+		})
+	}
+}
+
+func TestRenderEvents(t *testing.T) {
+
+	// Given:
+	day := time.Date(2019, 11, 8, 10, 5, 0, 0, time.UTC)
+	events := []structs.Event{
+		{Type: EventAlertSent, Time: day, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "digest 2019-11-09"},
+		{Type: EventCommandUsed, Time: day, UserID: UserID, Details: "check"},
+		{Type: EventCommandUsed, Time: day, UserID: User2ID, Details: "add"},
+	}
+
+	// When:
+	text := renderEvents(events, events[:1])
+
+	// Then:
+	assert.Equal(t, strings.Join([]string{
+		"Events of the last 7 days:",
+		" alert-sent: 1",
+		" command: 2",
+		"",
+		"The last 1:",
+		"08 Nov 10:05 alert-sent user 111 bookmark 1 location 111: digest 2019-11-09",
+		"",
+	}, "\n"), text)
+
+	// and nothing
+	assert.True(t, strings.HasPrefix(renderEvents(nil, nil), "Events of the last 7 days:\n none\n"))
+}
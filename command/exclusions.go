@@ -0,0 +1,120 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+const (
+	excludeFog = 1 << iota
+	excludeOvercast
+	excludeShowers
+	excludeSnow
+	excludeThunder
+)
+
+// weatherCategory is a group of weather types that user can exclude, so the day is not
+// considered as a good one even if wind, temperature and rain are fine
+type weatherCategory struct {
+	flag  int
+	name  string
+	icon  rune
+	types []int // codes from the mapWeatherTypes
+}
+
+var excludedWeatherSetting = toggleSetting{
+	field:    "ExcludedWeather",
+	value:    func(bookmark *structs.UsersLocationBookmark) *int { return &bookmark.ExcludedWeather },
+	keyboard: renderExcludedWeatherKeyboard,
+}
+
+var weatherCategories = []weatherCategory{
+	{excludeFog, "Fog/mist", '🌫', []int{5, 6}},
+	{excludeOvercast, "Overcast", '☁', []int{7, 8}},
+	{excludeShowers, "Showers", '🌧', []int{9, 10, 13, 14, 19, 20}},
+	{excludeSnow, "Snow", '❄', []int{16, 17, 18, 22, 23, 24, 25, 26, 27}},
+	{excludeThunder, "Thunder", '⛈', []int{28, 29, 30}},
+}
+
+// returns true if the weather type falls in one of the excluded categories
+// Please refer to unit tests
+func isWeatherTypeExcluded(excluded int, weatherType int) bool {
+	for _, category := range weatherCategories {
+		if excluded&category.flag == 0 {
+			continue
+		}
+		for _, t := range category.types {
+			if t == weatherType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// human readable list of the excluded categories, for example "Fog/mist, Snow"
+func excludedWeatherNames(excluded int) string {
+	var names []string
+	for _, category := range weatherCategories {
+		if excluded&category.flag != 0 {
+			names = append(names, category.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// WeatherCategoryFlags converts names of weather categories to the bit mask, the name is either the full one
+// ("Fog/mist") or its first part ("fog"), case insensitive
+func WeatherCategoryFlags(names []string) (int, error) {
+	flags := 0
+	for _, name := range names {
+		found := false
+		for _, category := range weatherCategories {
+			full := strings.ToLower(category.name)
+			if strings.ToLower(name) == full || strings.ToLower(name) == strings.Split(full, "/")[0] {
+				flags |= category.flag
+				found = true
+			}
+		}
+		if !found {
+			return 0, errors.New("unknown weather category '" + name + "'")
+		}
+	}
+	return flags, nil
+}
+
+// renders toggle buttons, where every button is a category; ticked ones are excluded
+func renderExcludedWeatherKeyboard(bookmark *structs.UsersLocationBookmark) tgbotapi.InlineKeyboardMarkup {
+	strBookmarkID := strconv.Itoa(bookmark.ID)
+
+	var buttonRows [][]tgbotapi.InlineKeyboardButton
+	for _, category := range weatherCategories {
+		buttonRows = append(buttonRows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(toggleLabel(bookmark.ExcludedWeather&category.flag != 0, string(category.icon)+" "+category.name),
+				callbackData(ButtonExcludeWeather, strBookmarkID, strconv.Itoa(category.flag))),
+		})
+	}
+
+	buttonRows = append(buttonRows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("👌 Done", callbackData(ButtonExcludeWeather, strBookmarkID, ButtonDone)),
+	})
+
+	return newInlineKeyboard(buttonRows...)
+}
+
+// sends the message with toggle buttons for excluded weather
+func askExcludedWeather(bot *tgbotapi.BotAPI, chatID int64, bookmark *structs.UsersLocationBookmark) {
+	msg, err := sendMsg(bot, chatID, "Which weather would spoil the day for you even if it is warm, dry and calm? "+
+		"Tick the ones to exclude and click Done:")
+	if err != nil {
+		return
+	}
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, msg.MessageID, renderExcludedWeatherKeyboard(bookmark))
+	bot.Send(keyboardMsg)
+}
@@ -0,0 +1,97 @@
+package command
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+// golden hour bookmarks are checked by the 3-hourly forecast; clear nights don't have golden hours at all
+func isGoldenHourOnly(bookmark structs.UsersLocationBookmark) bool {
+	return bookmark.GoldenHourOnly && bookmark.Mode == ModeDay
+}
+
+// ForecastFor loads the forecast that EvaluateBookmark expects for the bookmark
+func ForecastFor(provider ForecastProvider, bookmark structs.UsersLocationBookmark) (*structs.RootSiteRep, error) {
+	if isGoldenHourOnly(bookmark) {
+		return provider.ThreeHourlyForecast(bookmark.LocationID)
+	}
+	return provider.DailyForecast(bookmark.LocationID)
+}
+
+// EvaluateGoldenHours decides whether golden hours of one day from the 3-hourly forecast are good
+// for the bookmark. Only 3-hour steps that overlap golden hours are checked, and the day is good
+// if at least one of them passes all the thresholds. Otherwise criteria of the closest step are returned.
+func EvaluateGoldenHours(bookmark structs.UsersLocationBookmark, day structs.Period, latitude, longitude string) (DayEvaluation, error) {
+	evaluation, err := newDayEvaluation(bookmark, day)
+	if err != nil || evaluation.Skipped {
+		return evaluation, err
+	}
+
+	sun, ok := sunTimesFor(evaluation.date, latitude, longitude)
+	if !ok {
+		evaluation.Criteria = []Criterion{{Name: "golden hour", Value: "unknown", Passed: false}}
+		return evaluation, nil
+	}
+	evaluation.GoldenHours = sun.goldenHours()
+
+	bestPassed := -1
+	for _, step := range day.Rep {
+		minutes, err := strconv.Atoi(step["$"])
+		if err != nil {
+			continue
+		}
+
+		stepRange := timeRange{from: evaluation.date.Add(time.Duration(minutes) * time.Minute)}
+		stepRange.to = stepRange.from.Add(3 * time.Hour)
+		if !sun.isGoldenHour(stepRange) {
+			continue
+		}
+
+		figures := parseStepFigures(step)
+		criteria := append([]Criterion{{
+			Name:      "golden hour",
+			Value:     stepRange.String(),
+			Operator:  "overlaps",
+			Threshold: evaluation.GoldenHours,
+			Passed:    true,
+		}}, daytimeCriteria(bookmark, figures)...)
+
+		if passed := countPassed(criteria); passed > bestPassed {
+			bestPassed = passed
+			evaluation.Criteria = criteria
+			evaluation.setDayFigures(figures)
+		}
+		if allPassed(criteria) {
+			break
+		}
+	}
+
+	if evaluation.Criteria == nil {
+		evaluation.Criteria = []Criterion{{Name: "golden hour", Value: "no forecast", Threshold: evaluation.GoldenHours, Passed: false}}
+	}
+
+	evaluation.IsSuitable = allPassed(evaluation.Criteria)
+	return evaluation, nil
+}
+
+// whether the step overlaps any of golden hours
+func (s sunTimes) isGoldenHour(step timeRange) bool {
+	for _, golden := range s.golden {
+		if golden.overlaps(step.from, step.to) {
+			return true
+		}
+	}
+	return false
+}
+
+func countPassed(criteria []Criterion) int {
+	count := 0
+	for _, criterion := range criteria {
+		if criterion.Passed {
+			count++
+		}
+	}
+	return count
+}
@@ -69,6 +69,10 @@ func drawFiveDaysTable(root *structs.RootSiteRep) string {
 	var bufferRow2 bytes.Buffer
 	var bufferRow3 bytes.Buffer
 	var bufferRow4 bytes.Buffer
+	var bufferRow5 bytes.Buffer
+	var bufferRow6 bytes.Buffer
+
+	location := root.SiteRep.Dv.Location
 
 	for i, day := range days {
 
@@ -107,8 +111,12 @@ func drawFiveDaysTable(root *structs.RootSiteRep) string {
 			weatherType = wt
 		}
 		bufferRow4.WriteRune(mapWeatherTypes[weatherType].icon)
-		bufferRow4.WriteString("  │")
+		bufferRow4.WriteString("  │ ")
 		//bufferRow4.WriteString(mapWeatherTypes[weatherType].name)
+
+		// Row 4, column 2: max UV index
+		bufferRow4.WriteString("UV: ")
+		bufferRow4.WriteString(day.Rep[0]["U"])
 		compensateSpaces(&bufferRow4)
 
 		// Row 1, column 2: max day temperature
@@ -135,10 +143,21 @@ func drawFiveDaysTable(root *structs.RootSiteRep) string {
 		bufferRow3.WriteString("%)")
 		compensateSpaces(&bufferRow3)
 
+		// Rows 5 and 6, column 2: sunrise, sunset and golden hours, if we know where the site is
+		if sun, ok := sunTimesFor(t, location.Latitude, location.Longitude); ok {
+			bufferRow5.WriteString("│     │ Sun: " + timeRange{sun.sunrise, sun.sunset}.String())
+			compensateSpaces(&bufferRow5)
+			bufferRow5.WriteString(" │")
+
+			bufferRow6.WriteString("│     │ Gold " + shortGoldenHours(sun))
+			compensateSpaces(&bufferRow6)
+			bufferRow6.WriteString(" │")
+		}
+
 		bufferRow1.WriteString(" │")
 		bufferRow2.WriteString(" │")
 		bufferRow3.WriteString(" │")
-		bufferRow4.WriteString("│")
+		bufferRow4.WriteString(" │")
 
 		buffer.Write(bufferRow4.Bytes())
 		buffer.WriteRune('\n')
@@ -148,6 +167,12 @@ func drawFiveDaysTable(root *structs.RootSiteRep) string {
 		buffer.WriteRune('\n')
 		buffer.Write(bufferRow3.Bytes())
 		buffer.WriteRune('\n')
+		if bufferRow5.Len() > 0 {
+			buffer.Write(bufferRow5.Bytes())
+			buffer.WriteRune('\n')
+			buffer.Write(bufferRow6.Bytes())
+			buffer.WriteRune('\n')
+		}
 		if i < 4 {
 			buffer.WriteString("├─────┼────────────────────┤ \n")
 		}
@@ -156,6 +181,8 @@ func drawFiveDaysTable(root *structs.RootSiteRep) string {
 		bufferRow2.Reset()
 		bufferRow3.Reset()
 		bufferRow4.Reset()
+		bufferRow5.Reset()
+		bufferRow6.Reset()
 	}
 
 	buffer.WriteString("╰─────┴────────────────────╯ \n```\n")
@@ -163,6 +190,14 @@ func drawFiveDaysTable(root *structs.RootSiteRep) string {
 	return buffer.String()
 }
 
+// golden hours that fit the table cell: "<08:10 >17:20" means until 08:10 and after 17:20
+func shortGoldenHours(sun sunTimes) string {
+	if len(sun.golden) != 2 {
+		return "all day"
+	}
+	return "<" + sun.golden[0].to.Format("15:04") + " >" + sun.golden[1].from.Format("15:04")
+}
+
 func compensateSpaces(bfr *bytes.Buffer) {
 	maxLen := len([]rune(vertTopLine))
 	for {
@@ -173,7 +208,7 @@ func compensateSpaces(bfr *bytes.Buffer) {
 	}
 }
 
-func printDetailedPlotsForADay(data []map[string]string, keyFromMap, unit string, isRound bool) string {
+func printDetailedPlotsForADay(data []map[string]string, keyFromMap, unit string, isRound, withWindDirection bool) string {
 	var buffer bytes.Buffer
 
 	buffer.WriteString("```\n")
@@ -210,6 +245,16 @@ func printDetailedPlotsForADay(data []map[string]string, keyFromMap, unit string
 	graph, offset := asciigraph.Plot(temp3Hourly)
 	buffer.WriteString(graph)
 
+	// arrows under every value, showing where the wind blows
+	if withWindDirection {
+		buffer.WriteRune('\n')
+		buffer.WriteString(strings.Repeat(" ", offset))
+		for _, mapHour := range data {
+			buffer.WriteRune(directionArrow(mapHour["D"]))
+			buffer.WriteString(strings.Repeat(" ", multiplier-1))
+		}
+	}
+
 	if len(data) == 8 {
 		buffer.WriteRune('\n')
 		buffer.WriteString(strings.Repeat(" ", offset))
@@ -0,0 +1,301 @@
+package command
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+const (
+	minGroupSize   = 2
+	maxBestMembers = 3 // how many best sites of a group are named in the digest
+)
+
+type (
+	// all the evaluated days of one site of a group
+	memberEvaluations struct {
+		locationID  string
+		evaluations []DayEvaluation
+	}
+
+	memberEvaluation struct {
+		locationID string
+		evaluation DayEvaluation
+	}
+
+	// one day of a group: the evaluation of the best site, and all the good sites from the best one
+	groupDay struct {
+		evaluation DayEvaluation
+		best       []string // location IDs
+	}
+)
+
+// MemberLocationIDs returns sites to check: all the members of a group, or just one location of a usual bookmark
+func MemberLocationIDs(bookmark structs.UsersLocationBookmark) []string {
+	if len(bookmark.GroupLocationIDs) > 0 {
+		return bookmark.GroupLocationIDs
+	}
+	return []string{bookmark.LocationID}
+}
+
+// combines evaluations of group members day by day. The day is good if at least one member is good;
+// good members are sorted from the best one: the driest, then the calmest, then the warmest.
+// Please refer to unit tests
+func combineGroupEvaluations(members []memberEvaluations) []groupDay {
+	var dates []string
+	mapDays := make(map[string]*groupDay)
+	mapGood := make(map[string][]memberEvaluation)
+
+	for _, member := range members {
+		for _, evaluation := range member.evaluations {
+			day, ok := mapDays[evaluation.Date]
+			if !ok {
+				day = &groupDay{evaluation: evaluation}
+				mapDays[evaluation.Date] = day
+				dates = append(dates, evaluation.Date)
+			}
+			if evaluation.IsSuitable {
+				mapGood[evaluation.Date] = append(mapGood[evaluation.Date], memberEvaluation{member.locationID, evaluation})
+			}
+		}
+	}
+
+	sort.Strings(dates)
+	days := make([]groupDay, len(dates))
+	for i, date := range dates {
+		good := mapGood[date]
+		sort.SliceStable(good, func(i, j int) bool {
+			a, b := good[i].evaluation, good[j].evaluation
+			if a.PrecipProb != b.PrecipProb {
+				return a.PrecipProb < b.PrecipProb
+			}
+			if a.Wind != b.Wind {
+				return a.Wind < b.Wind
+			}
+			return a.Temperature > b.Temperature
+		})
+
+		days[i] = *mapDays[date]
+		for _, member := range good {
+			days[i].best = append(days[i].best, member.locationID)
+		}
+		if len(good) > 0 {
+			days[i].evaluation = good[0].evaluation
+		}
+	}
+
+	return days
+}
+
+// the good day of a group is reported for its best site, naming a few other good ones
+func newGoodDayOfGroup(loc structs.UsersLocationBookmark, day groupDay, mapLocs map[string]structs.SiteLocation) goodDay {
+	best := day.best[0]
+	loc.LocationID = best
+	good := newGoodDay(loc, mapLocs[best], day.evaluation)
+
+	if len(loc.GroupLocationIDs) > 0 {
+		count := len(day.best)
+		if count > maxBestMembers {
+			count = maxBestMembers
+		}
+		good.members = memberNames(day.best[:count], mapLocs)
+	}
+	return good
+}
+
+// the run of a group is reported for the site that is the best on most of its days, naming a few other good ones
+func newGoodRunOfGroup(loc structs.UsersLocationBookmark, days []groupDay, run Run, mapLocs map[string]structs.SiteLocation) goodDay {
+	mapDays := make(map[string]groupDay, len(days))
+	for _, day := range days {
+		mapDays[day.evaluation.Date] = day
+	}
+
+	var ranked []string
+	bestDays := make(map[string]int)
+	for _, evaluation := range run.days {
+		for i, locationID := range mapDays[evaluation.Date].best {
+			if _, ok := bestDays[locationID]; !ok {
+				ranked = append(ranked, locationID)
+				bestDays[locationID] = 0
+			}
+			if i == 0 {
+				bestDays[locationID]++
+			}
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return bestDays[ranked[i]] > bestDays[ranked[j]]
+	})
+
+	loc.LocationID = ranked[0]
+	good := newGoodRun(goodDay{bookmark: loc, site: mapLocs[ranked[0]]}, run)
+
+	if len(loc.GroupLocationIDs) > 0 {
+		count := len(ranked)
+		if count > maxBestMembers {
+			count = maxBestMembers
+		}
+		good.members = memberNames(ranked[:count], mapLocs)
+	}
+	return good
+}
+
+// the name of a bookmark as user sees it: the name of a group or of the site
+func bookmarkTitle(bookmark structs.UsersLocationBookmark, mapLocs map[string]structs.SiteLocation) string {
+	if len(bookmark.GroupName) > 0 {
+		return bookmark.GroupName
+	}
+	return mapLocs[bookmark.LocationID].Name
+}
+
+// names of the group members, for example "Edale, Castleton, Buxton"
+func memberNames(locationIDs []string, mapLocs map[string]structs.SiteLocation) string {
+	names := make([]string, len(locationIDs))
+	for i, locationID := range locationIDs {
+		names[i] = locationID
+		if site, ok := mapLocs[locationID]; ok {
+			names[i] = site.Name
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Initiate the process of adding a new location group, where user picks several sites
+func StartProcessAddingNewGroup(bot *tgbotapi.BotAPI, repos Repositories, message *tgbotapi.Message) {
+	sm := startNewBookmark(bot, repos, message)
+	if sm == nil {
+		return
+	}
+
+	if err := sm.markNextStepState(StepEnterGroupName); err != nil {
+		sentry.CaptureException(err)
+		sendMsg(bot, message.Chat.ID, "Internal error: can't update state")
+		return
+	}
+
+	sendMsg(bot, message.Chat.ID, "Ok, let's create a group of places, so I tell you when the weather is good "+
+		"at least in one of them. \n\n How would you call this group? For example, Peak District")
+}
+
+// processes clicks on the group buttons: "M#<bookmark id>" shows members, "M#<bookmark id>#add" starts
+// adding new members and "M#<bookmark id>#<location id>" removes one member
+func processGroupButton(bot *tgbotapi.BotAPI, repos Repositories, callbackQuery *tgbotapi.CallbackQuery, parts []string) {
+	chatID := callbackQuery.Message.Chat.ID
+
+	intBookmarkID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		sentry.CaptureException(errors.Wrap(err, "Can't parse bookmarkID, expected valid number, but got: "+parts[1]))
+		return
+	}
+
+	bookmark, err := repos.Bookmarks.Get(intBookmarkID)
+	if err != nil || bookmark.UserID != callbackQuery.From.ID || len(bookmark.GroupLocationIDs) == 0 {
+		sendMsg(bot, chatID, "Can't find a group")
+		return
+	}
+
+	if len(parts) > 2 && parts[2] == "add" {
+		startAddingGroupMembers(bot, repos, chatID, callbackQuery.From.ID, bookmark)
+		return
+	}
+
+	if len(parts) > 2 {
+		if err := removeGroupMember(repos.Bookmarks, &bookmark, parts[2]); err != nil {
+			sendMsg(bot, chatID, err.Error())
+			return
+		}
+		removeButtons(bot, chatID, callbackQuery.Message.MessageID)
+	}
+
+	renderGroupMembers(bot, repos, chatID, bookmark)
+}
+
+// shows the members of a group, each one with buttons "forecast" and "remove"
+func renderGroupMembers(bot *tgbotapi.BotAPI, repos Repositories, chatID int64, bookmark structs.UsersLocationBookmark) {
+	mapLocs := getMapOfLocations([]structs.UsersLocationBookmark{bookmark}, repos.Sites)
+	strBookmarkID := strconv.Itoa(bookmark.ID)
+
+	var buttonRows [][]tgbotapi.InlineKeyboardButton
+	for _, locationID := range bookmark.GroupLocationIDs {
+		buttonRows = append(buttonRows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("📍 "+memberNames([]string{locationID}, mapLocs), callbackData(ButtonLocationPrefix, locationID)),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Remove", callbackData(ButtonGroupMembers, strBookmarkID, locationID)),
+		})
+	}
+	buttonRows = append(buttonRows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("➕ Add a site", callbackData(ButtonGroupMembers, strBookmarkID, "add")),
+	})
+
+	msg, err := sendMsg(bot, chatID, "👥 "+escapeMarkdown(bookmark.GroupName)+" consists of "+strconv.Itoa(len(bookmark.GroupLocationIDs))+" places:")
+	if err != nil {
+		return
+	}
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, msg.MessageID, newInlineKeyboard(buttonRows...))
+	bot.Send(keyboardMsg)
+}
+
+// adds one more site to the group, the first site is also the main location of the bookmark
+func addGroupMember(bookmarks BookmarkRepository, bookmark *structs.UsersLocationBookmark, locationID string) error {
+	for _, existing := range bookmark.GroupLocationIDs {
+		if existing == locationID {
+			return nil
+		}
+	}
+
+	if len(bookmark.LocationID) == 0 {
+		bookmark.LocationID = locationID
+		if err := bookmarks.UpdateField(bookmark, "LocationID", locationID); err != nil {
+			return err
+		}
+	}
+
+	bookmark.GroupLocationIDs = append(bookmark.GroupLocationIDs, locationID)
+	return bookmarks.UpdateField(bookmark, "GroupLocationIDs", bookmark.GroupLocationIDs)
+}
+
+func removeGroupMember(bookmarks BookmarkRepository, bookmark *structs.UsersLocationBookmark, locationID string) error {
+	if len(bookmark.GroupLocationIDs) <= minGroupSize {
+		return errors.New("A group needs at least two places. Please add another one first, or delete the whole group")
+	}
+
+	var members []string
+	for _, existing := range bookmark.GroupLocationIDs {
+		if existing != locationID {
+			members = append(members, existing)
+		}
+	}
+
+	if err := bookmarks.UpdateField(bookmark, "GroupLocationIDs", members); err != nil {
+		sentry.CaptureException(err)
+		return errors.New("Sorry, internal error occurred, can't save your choice. Please try again later.")
+	}
+	bookmark.GroupLocationIDs = members
+	if bookmark.LocationID == locationID {
+		bookmark.LocationID = members[0]
+		bookmarks.UpdateField(bookmark, "LocationID", members[0])
+	}
+	return nil
+}
+
+// switches user to the step where picked sites are added to the existing group
+func startAddingGroupMembers(bot *tgbotapi.BotAPI, repos Repositories, chatID int64, userID int, bookmark structs.UsersLocationBookmark) {
+	repos.States.DeleteState(userID)
+	repos.Bookmarks.DeleteUnfinished(userID)
+
+	if err := repos.States.SaveState(&structs.UserState{UserID: userID, CurrentState: StepAddGroupMember, BookmarkID: bookmark.ID}); err != nil {
+		sentry.CaptureException(err)
+		sendMsg(bot, chatID, "Internal error: can't update state")
+		return
+	}
+
+	resp, _ := sendMsg(bot, chatID, "Pick places to add to "+escapeMarkdown(bookmark.GroupName)+" one by one. Start typing name following by the bot name, "+
+		"or click the button below")
+	renderButtonThatOpensInlineQuery(bot, chatID, resp.MessageID)
+}
@@ -0,0 +1,129 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+func TestCombineGroupEvaluations(t *testing.T) {
+
+	// one evaluated day of one member
+	day := func(date string, isSuitable bool, precip, wind, temp int) DayEvaluation {
+		parsed, _ := time.Parse("2006-01-02", date)
+		return DayEvaluation{Date: date, IsSuitable: isSuitable, PrecipProb: precip, Wind: wind, Temperature: temp, date: parsed}
+	}
+
+	for _, tt := range []struct {
+		name         string
+		members      []memberEvaluations
+		expectedBest [][]string // best members per day
+	}{
+		{
+			name: "one site is a group of one",
+			members: []memberEvaluations{
+				{"1", []DayEvaluation{day("2019-10-12", true, 10, 5, 15), day("2019-10-13", false, 80, 5, 15)}},
+			},
+			expectedBest: [][]string{{"1"}, nil},
+		},
+		{
+			name: "the day is good if any member is good",
+			members: []memberEvaluations{
+				{"1", []DayEvaluation{day("2019-10-12", true, 10, 5, 15), day("2019-10-13", false, 80, 5, 15)}},
+				{"2", []DayEvaluation{day("2019-10-12", false, 60, 5, 15), day("2019-10-13", true, 20, 5, 15)}},
+			},
+			expectedBest: [][]string{{"1"}, {"2"}},
+		},
+		{
+			name: "the driest member is the best",
+			members: []memberEvaluations{
+				{"1", []DayEvaluation{day("2019-10-12", true, 30, 5, 15)}},
+				{"2", []DayEvaluation{day("2019-10-12", true, 10, 15, 10)}},
+				{"3", []DayEvaluation{day("2019-10-12", true, 20, 5, 15)}},
+			},
+			expectedBest: [][]string{{"2", "3", "1"}},
+		},
+		{
+			name: "then the calmest and the warmest",
+			members: []memberEvaluations{
+				{"1", []DayEvaluation{day("2019-10-12", true, 10, 10, 15)}},
+				{"2", []DayEvaluation{day("2019-10-12", true, 10, 5, 10)}},
+				{"3", []DayEvaluation{day("2019-10-12", true, 10, 5, 12)}},
+			},
+			expectedBest: [][]string{{"3", "2", "1"}},
+		},
+		{
+			name: "days are sorted even if a member has a shorter forecast",
+			members: []memberEvaluations{
+				{"1", []DayEvaluation{day("2019-10-13", true, 10, 5, 15)}},
+				{"2", []DayEvaluation{day("2019-10-12", true, 10, 5, 15), day("2019-10-13", true, 20, 5, 15)}},
+			},
+			expectedBest: [][]string{{"2"}, {"1", "2"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// When:
+			days := combineGroupEvaluations(tt.members)
+
+			// Then:
+			assert.Equal(t, len(tt.expectedBest), len(days))
+			for i, groupDay := range days {
+				assert.Equal(t, tt.expectedBest[i], groupDay.best)
+				assert.Equal(t, len(groupDay.best) > 0, groupDay.evaluation.IsSuitable)
+				if i > 0 {
+					assert.True(t, days[i-1].evaluation.date.Before(groupDay.evaluation.date))
+				}
+			}
+		})
+	}
+}
+
+func TestCombineGroupEvaluationsTakesTheBestMember(t *testing.T) {
+
+	// Given:
+	members := []memberEvaluations{
+		{"1", []DayEvaluation{{Date: "2019-10-12", IsSuitable: true, PrecipProb: 30, Wind: 10}}},
+		{"2", []DayEvaluation{{Date: "2019-10-12", IsSuitable: true, PrecipProb: 5, Wind: 12}}},
+	}
+
+	// When:
+	days := combineGroupEvaluations(members)
+
+	// Then: the figures of the group day are taken from the best member
+	assert.Equal(t, 5, days[0].evaluation.PrecipProb)
+	assert.Equal(t, 12, days[0].evaluation.Wind)
+}
+
+func TestNewGoodRunOfGroupTakesTheBestMember(t *testing.T) {
+
+	// one evaluated day of one member
+	day := func(date string, precip int) DayEvaluation {
+		parsed, _ := time.Parse("2006-01-02", date)
+		return DayEvaluation{Date: date, IsSuitable: true, PrecipProb: precip, date: parsed}
+	}
+
+	// Given: the second member is the best on two days of three
+	loc := structs.UsersLocationBookmark{LocationID: "1", GroupName: "Peak District", GroupLocationIDs: []string{"1", "2"}}
+	days := combineGroupEvaluations([]memberEvaluations{
+		{"1", []DayEvaluation{day("2019-10-12", 10), day("2019-10-13", 30), day("2019-10-14", 30)}},
+		{"2", []DayEvaluation{day("2019-10-12", 20), day("2019-10-13", 10), day("2019-10-14", 10)}},
+	})
+	evaluations := make([]DayEvaluation, len(days))
+	for i, day := range days {
+		evaluations[i] = day.evaluation
+	}
+	run := FindRuns(evaluations, 3)[0]
+	mapLocs := map[string]structs.SiteLocation{"1": {ID: "1", Name: "Edale"}, "2": {ID: "2", Name: "Castleton"}}
+
+	// When:
+	good := newGoodRunOfGroup(loc, days, run, mapLocs)
+
+	// Then: the run is reported for it, not for the first member
+	assert.Equal(t, "2", good.bookmark.LocationID)
+	assert.Equal(t, "Castleton", good.site.Name)
+	assert.Equal(t, "Castleton, Edale", good.members)
+	assert.Equal(t, 3, good.run.Days)
+}
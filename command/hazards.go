@@ -0,0 +1,248 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+const (
+	PolarityGood   = 0 // notify when the weather is good, this is the default one
+	PolarityHazard = 1 // warn when the weather is dangerous, for allotments, boats and so on
+
+	frostTemp = 0  // night temperature below this is a frost
+	galeGust  = 40 // gusts stronger than this are a gale, in mph
+
+	answerHazards = "hazards" // the answer of the wizard for the mode step, when user wants warnings
+)
+
+const (
+	hazardFrost = 1 << iota
+	hazardGale
+	hazardHeavyRain
+	hazardSnow
+	hazardThunder
+)
+
+// hazard is a preset rule that user can tick, the day is dangerous if any of ticked rules is triggered
+type hazard struct {
+	flag  int
+	name  string
+	icon  rune
+	check func(day dayFigures, night *nightFigures) Criterion // passes when the weather is safe
+}
+
+var hazardsSetting = toggleSetting{
+	field:    "Hazards",
+	value:    func(bookmark *structs.UsersLocationBookmark) *int { return &bookmark.Hazards },
+	keyboard: renderHazardsKeyboard,
+}
+
+var hazards = []hazard{
+	{hazardFrost, "Frost", '🧊', func(day dayFigures, night *nightFigures) Criterion {
+		if night == nil {
+			return Criterion{Name: "frost", Value: "no night forecast", Passed: true}
+		}
+		return newCriterion("frost", night.minTemp, ">=", frostTemp, "˚C", night.minTemp >= frostTemp)
+	}},
+	{hazardGale, "Gale", '💨', func(day dayFigures, night *nightFigures) Criterion {
+		gust := day.gust
+		if night != nil && night.gust > gust {
+			gust = night.gust
+		}
+		return newCriterion("gale", gust, "<=", galeGust, "mph", gust <= galeGust)
+	}},
+	{hazardHeavyRain, "Heavy rain", '☔', weatherTypeRule("heavy rain", 13, 14, 15)},
+	{hazardSnow, "Snow", '☃', weatherTypeRule("snow", 22, 23, 24, 25, 26, 27)},
+	{hazardThunder, "Thunder", '⚡', weatherTypeRule("thunder", 28, 29, 30)},
+}
+
+// the rule that is triggered when any of weather types is forecast, either for the day or for the night
+func weatherTypeRule(name string, types ...int) func(day dayFigures, night *nightFigures) Criterion {
+	return func(day dayFigures, night *nightFigures) Criterion {
+		forecast := []int{day.weatherType}
+		if night != nil {
+			forecast = append(forecast, night.weatherType)
+		}
+
+		criterion := Criterion{Name: name, Operator: "not", Threshold: name, Passed: true}
+		var names []string
+		for _, weatherType := range forecast {
+			names = append(names, mapWeatherTypes[weatherType].name)
+			for _, t := range types {
+				if t == weatherType {
+					criterion.Passed = false
+				}
+			}
+		}
+		criterion.Value = strings.Join(names, ", ")
+		return criterion
+	}
+}
+
+// checks the day and the night parts of the forecast against the ticked hazards
+func evaluateHazards(bookmark structs.UsersLocationBookmark, day structs.Period, evaluation *DayEvaluation) []Criterion {
+	figures := parseNumberFigures(day)
+	evaluation.setDayFigures(figures)
+
+	var night *nightFigures
+	if len(day.Rep) > 1 {
+		parsed := parseNightFigures(day)
+		night = &parsed
+	}
+
+	var criteria []Criterion
+	for _, h := range hazards {
+		if bookmark.Hazards&h.flag != 0 {
+			criteria = append(criteria, h.check(figures, night))
+		}
+	}
+	return criteria
+}
+
+// returns the hazards that were triggered, for example "🧊 frost (-2˚C), 💨 gale (45mph)"
+func triggeredHazards(criteria []Criterion) string {
+	var triggered []string
+	for _, criterion := range criteria {
+		if criterion.Passed {
+			continue
+		}
+		icon := '⚠'
+		for _, h := range hazards {
+			if strings.ToLower(h.name) == criterion.Name {
+				icon = h.icon
+			}
+		}
+		triggered = append(triggered, fmt.Sprintf("%c %s (%s%s)", icon, criterion.Name, criterion.Value, criterion.Unit))
+	}
+	return strings.Join(triggered, ", ")
+}
+
+// human readable list of the ticked hazards, for example "Frost, Gale"
+func hazardNames(ticked int) string {
+	var names []string
+	for _, h := range hazards {
+		if ticked&h.flag != 0 {
+			names = append(names, h.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// HazardFlags converts names of hazards, such as "frost" or "heavy rain", to the bit mask, case insensitive
+func HazardFlags(names []string) (int, error) {
+	flags := 0
+	for _, name := range names {
+		found := false
+		for _, h := range hazards {
+			if strings.ToLower(name) == strings.ToLower(h.name) {
+				flags |= h.flag
+				found = true
+			}
+		}
+		if !found {
+			return 0, errors.New("unknown hazard '" + name + "'")
+		}
+	}
+	return flags, nil
+}
+
+// renders toggle buttons, where every button is a hazard; ticked ones are watched
+func renderHazardsKeyboard(bookmark *structs.UsersLocationBookmark) tgbotapi.InlineKeyboardMarkup {
+	strBookmarkID := strconv.Itoa(bookmark.ID)
+
+	var buttonRows [][]tgbotapi.InlineKeyboardButton
+	for _, h := range hazards {
+		buttonRows = append(buttonRows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(toggleLabel(bookmark.Hazards&h.flag != 0, string(h.icon)+" "+h.name),
+				callbackData(ButtonHazards, strBookmarkID, strconv.Itoa(h.flag))),
+		})
+	}
+
+	buttonRows = append(buttonRows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("👌 Done", callbackData(ButtonHazards, strBookmarkID, ButtonDone)),
+	})
+
+	return newInlineKeyboard(buttonRows...)
+}
+
+// sends the message with toggle buttons for hazards
+func askHazards(bot *tgbotapi.BotAPI, chatID int64, bookmark *structs.UsersLocationBookmark) {
+	if bookmark.Polarity != PolarityHazard {
+		sendMsg(bot, chatID, "This location is watched for good weather, hazards are for bad weather warnings only")
+		return
+	}
+
+	msg, err := sendMsg(bot, chatID, fmt.Sprintf("What should I warn you about? Frost is a night below %d˚C, "+
+		"gale is gusts stronger than %dmph. Tick the ones to watch and click Done:", frostTemp, galeGust))
+	if err != nil {
+		return
+	}
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, msg.MessageID, renderHazardsKeyboard(bookmark))
+	bot.Send(keyboardMsg)
+}
+
+// the bad weather day of one site, the triggered hazards are in the text of the warning
+func newHazardDay(loc structs.UsersLocationBookmark, site structs.SiteLocation, evaluation DayEvaluation) goodDay {
+	day := newGoodDay(loc, site, evaluation)
+	day.hazards = triggeredHazards(evaluation.Criteria)
+	return day
+}
+
+// the site name of the warning, for location groups the group name is added, for example "Edale (Peak District)"
+func hazardSiteTitle(day goodDay) string {
+	if len(day.bookmark.GroupName) > 0 && len(day.site.Name) > 0 {
+		return day.site.Name + " (" + day.bookmark.GroupName + ")"
+	}
+	return siteTitle(day)
+}
+
+// sends bad weather warnings, one message per chat. The template and icons are different from the
+// good weather digest, so warnings are not confused with good news
+func sendHazardWarnings(bot *tgbotapi.BotAPI, days []goodDay) {
+	var chats []int64
+	mapDays := make(map[int64][]goodDay)
+	for _, day := range days {
+		chatID := day.bookmark.ChatID
+		if _, ok := mapDays[chatID]; !ok {
+			chats = append(chats, chatID)
+		}
+		mapDays[chatID] = append(mapDays[chatID], day)
+	}
+
+	for _, chatID := range chats {
+		for _, chunk := range splitMessage(renderHazardWarnings(mapDays[chatID]), maxMessageLength) {
+			sendMsg(bot, chatID, chunk)
+		}
+	}
+}
+
+// renders warnings for one chat, sorted by date
+// Please refer to unit tests
+func renderHazardWarnings(days []goodDay) string {
+	sorted := make([]goodDay, len(days))
+	copy(sorted, days)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].date.Before(sorted[j].date)
+	})
+
+	var buffer bytes.Buffer
+	buffer.WriteString("⚠️ Bad weather warning: \n\n")
+	for _, day := range sorted {
+		buffer.WriteString(fmt.Sprintf(" - %s in %s: %s \n",
+			day.date.Format("Mon, 02 Jan"),
+			escapeMarkdown(hazardSiteTitle(day)),
+			day.hazards))
+	}
+	buffer.WriteString("\nTake care of your plants, boats and yourself!")
+
+	return buffer.String()
+}
@@ -0,0 +1,106 @@
+package command
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// JanitorStats is the number of records removed by one run of the janitor
+type JanitorStats struct {
+	States    int // wizards that were not answered for too long
+	Bookmarks int // unfinished bookmarks without a wizard
+	Events    int // events older than the retention period
+	Compacted int // alerts and checked days replaced with the summaries of their days
+	Backups   int // backups older than the retention period
+}
+
+// RunJanitor cancels the wizards that were not answered for opts.WizardIdleHours, removes the unfinished bookmarks
+// that have no wizard anymore, compacts the alerts and checked days older than opts.EventCompactDays and removes the
+// events and backups older than their retention periods. Otherwise the forgotten wizard would take any text that user
+// sends later as the answer of its step
+func RunJanitor(bot *tgbotapi.BotAPI, repos Repositories, opts *structs.Opts, now time.Time) (JanitorStats, error) {
+	var stats JanitorStats
+
+	if opts.WizardIdleHours > 0 {
+		states, err := repos.States.FindIdle(now.Add(-time.Duration(opts.WizardIdleHours) * time.Hour))
+		if err != nil {
+			return stats, err
+		}
+
+		for _, state := range states {
+
+			// the chat is known only from the bookmark, and the text typed without it was not a real wizard
+			bookmark, found := wizardBookmark(repos, state)
+			if err := repos.States.DeleteState(state.UserID); err != nil && err != ErrNotFound {
+				return stats, err
+			}
+			stats.States++
+
+			RecordEvent(repos, structs.Event{Type: EventWizardExpired, UserID: state.UserID, BookmarkID: state.BookmarkID,
+				Details: "step " + strconv.Itoa(state.CurrentState)})
+			if opts.WizardExpiredNotice && found && bookmark.ChatID != 0 && bot != nil {
+				sendMsg(bot, bookmark.ChatID, expiredWizardNotice(state, bookmark))
+			}
+		}
+	}
+
+	// the bookmarks of the cancelled wizards, and the ones left by older versions of the bot
+	unfinished, err := repos.Bookmarks.FindAllUnfinished()
+	if err != nil {
+		return stats, err
+	}
+	for _, bookmark := range unfinished {
+		if _, err := repos.States.GetState(bookmark.UserID); err == nil {
+			continue
+		} else if err != ErrNotFound {
+			return stats, err
+		}
+
+		if err := repos.Bookmarks.Delete(&bookmark); err != nil && err != ErrNotFound {
+			return stats, err
+		}
+		stats.Bookmarks++
+	}
+
+	if stats.Compacted, err = CompactEvents(repos, opts.EventCompactDays, now); err != nil {
+		return stats, err
+	}
+	if stats.Events, err = PurgeEvents(repos, opts.EventRetentionDays, now); err != nil {
+		return stats, err
+	}
+	stats.Backups, err = ExpireBackups(opts.BackupDir, opts.BackupRetentionDays, now)
+	return stats, err
+}
+
+// returns the bookmark that the wizard adds or changes, or false if there is none
+func wizardBookmark(repos Repositories, state structs.UserState) (structs.UsersLocationBookmark, bool) {
+	if bookmark, err := repos.Bookmarks.FindUnfinished(state.UserID); err == nil {
+		return bookmark, true
+	}
+	if state.BookmarkID == 0 {
+		return structs.UsersLocationBookmark{}, false
+	}
+	if bookmark, err := repos.Bookmarks.Get(state.BookmarkID); err == nil && bookmark.UserID == state.UserID {
+		return bookmark, true
+	}
+	return structs.UsersLocationBookmark{}, false
+}
+
+// tells which wizard was cancelled and how to start it again: adding places to a saved group is started from
+// /locations, a new group has a name or is asked for it, everything else is /add
+func expiredWizardNotice(state structs.UserState, bookmark structs.UsersLocationBookmark) string {
+	var cancelled, again string
+	switch {
+	case state.CurrentState == StepAddGroupMember:
+		cancelled, again = "adding places to the group "+escapeMarkdown(bookmark.GroupName), "open the group in /locations"
+	case state.CurrentState == StepEnterGroupName || len(bookmark.GroupName) > 0:
+		cancelled, again = "the new group of places", "start again with /addgroup"
+	default:
+		cancelled, again = "the new place", "start again with /add"
+	}
+	return "⌛ I didn't get the answer for a long time, so " + cancelled + " was cancelled. Please " + again + " when you are ready"
+}
@@ -0,0 +1,155 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+func TestRunJanitor(t *testing.T) {
+
+	// Given: one user walked away in the middle of adding a bookmark, the other one is changing a saved one,
+	// and there is an unfinished bookmark left without a wizard
+	repos := prepareRepositories()
+	saved := structs.UsersLocationBookmark{UserID: User2ID, LocationID: TestLocationID, IsReady: true, ChatID: User2ID}
+	for _, bookmark := range []*structs.UsersLocationBookmark{
+		{UserID: UserID, LocationID: TestLocationID, IsReady: true},
+		{UserID: UserID, ChatID: UserID},
+		&saved,
+		{UserID: 333},
+	} {
+		assert.Nil(t, repos.Bookmarks.Save(bookmark))
+	}
+	assert.Nil(t, repos.States.SaveState(&structs.UserState{UserID: UserID, CurrentState: StepEnterMaxWindSpeed}))
+	assert.Nil(t, repos.States.SaveState(&structs.UserState{UserID: User2ID, CurrentState: StepAddGroupMember, BookmarkID: saved.ID}))
+	assert.Nil(t, repos.Events.Record(&structs.Event{Type: EventCommandUsed, Time: time.Now().AddDate(0, 0, -100)}))
+
+	opts := &structs.Opts{WizardIdleHours: 24, EventRetentionDays: 90, WizardExpiredNotice: true}
+
+	// When: the wizards are still fresh
+	stats, err := RunJanitor(nil, repos, opts, time.Now())
+
+	// Then: only the orphan and the old event are removed
+	assert.Nil(t, err)
+	assert.Equal(t, JanitorStats{Bookmarks: 1, Events: 1}, stats)
+	_, err = repos.States.GetState(UserID)
+	assert.Nil(t, err)
+	_, err = repos.Bookmarks.FindUnfinished(UserID)
+	assert.Nil(t, err)
+
+	// and when: a day later
+	stats, err = RunJanitor(nil, repos, opts, time.Now().Add(25*time.Hour))
+
+	// Then: both wizards are cancelled with the unfinished bookmark, but the saved ones stay
+	assert.Nil(t, err)
+	assert.Equal(t, JanitorStats{States: 2, Bookmarks: 1}, stats)
+
+	for _, userID := range []int{UserID, User2ID} {
+		_, err = repos.States.GetState(userID)
+		assert.Equal(t, ErrNotFound, err)
+
+		ready, _ := repos.Bookmarks.FindReady(userID)
+		assert.Equal(t, 1, len(ready))
+	}
+	unfinished, _ := repos.Bookmarks.FindAllUnfinished()
+	assert.Empty(t, unfinished)
+
+	expired, _ := repos.Events.Find(EventFilter{Type: EventWizardExpired})
+	assert.Equal(t, 2, len(expired))
+}
+
+func TestRunJanitorKeepsWizardsForever(t *testing.T) {
+
+	// Given:
+	repos := prepareRepositories()
+	assert.Nil(t, repos.Bookmarks.Save(&structs.UsersLocationBookmark{UserID: UserID}))
+	assert.Nil(t, repos.States.SaveState(&structs.UserState{UserID: UserID, CurrentState: StepEnterMaxWindSpeed}))
+
+	// When:
+	stats, err := RunJanitor(nil, repos, &structs.Opts{}, time.Now().AddDate(1, 0, 0))
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, JanitorStats{}, stats)
+}
+
+func TestRunJanitorCompactsOldData(t *testing.T) {
+
+	// Given: the alerts of a night long ago and an old backup
+	now := time.Date(2019, 11, 8, 10, 0, 0, 0, time.UTC)
+	repos := prepareRepositories()
+	night := now.AddDate(0, 0, -40)
+	assert.Nil(t, repos.Events.RecordAll([]structs.Event{
+		{Type: EventAlertSent, Time: night, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "digest 2019-09-30"},
+		{Type: EventAlertSent, Time: night, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "uv 2019-09-30"},
+	}))
+
+	dir, _ := ioutil.TempDir(os.TempDir(), "backups")
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"weather-20190929-004000.db", "weather-20191108-004000.db"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("backup"), 0644)
+	}
+
+	opts := &structs.Opts{EventRetentionDays: 90, EventCompactDays: 30, BackupDir: dir, BackupRetentionDays: 30}
+
+	// When:
+	stats, err := RunJanitor(nil, repos, opts, now)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, JanitorStats{Compacted: 2, Backups: 1}, stats)
+}
+
+func TestWizardBookmark(t *testing.T) {
+
+	// Given:
+	repos := prepareRepositories()
+	adding := structs.UsersLocationBookmark{UserID: UserID, ChatID: 10}
+	foreign := structs.UsersLocationBookmark{UserID: User2ID, ChatID: 20, IsReady: true}
+	assert.Nil(t, repos.Bookmarks.Save(&adding))
+	assert.Nil(t, repos.Bookmarks.Save(&foreign))
+
+	for _, tt := range []struct {
+		name     string
+		state    structs.UserState
+		expected int64
+	}{
+		{"adding a bookmark", structs.UserState{UserID: UserID}, 10},
+		{"the text without a wizard", structs.UserState{UserID: 333}, 0},
+		{"the bookmark of somebody else", structs.UserState{UserID: 333, BookmarkID: foreign.ID}, 0},
+		{"changing a bookmark", structs.UserState{UserID: User2ID, BookmarkID: foreign.ID}, 20},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			bookmark, found := wizardBookmark(repos, tt.state)
+			assert.Equal(t, tt.expected != 0, found)
+			assert.Equal(t, tt.expected, bookmark.ChatID)
+		})
+	}
+}
+
+func TestExpiredWizardNotice(t *testing.T) {
+	group := structs.UsersLocationBookmark{GroupName: "Peak District", GroupLocationIDs: []string{TestLocationID, TestLocation2ID}}
+
+	for _, tt := range []struct {
+		name     string
+		state    structs.UserState
+		bookmark structs.UsersLocationBookmark
+		expected string // the cancelled wizard and the way to start it again
+	}{
+		{"adding a place", structs.UserState{CurrentState: StepEnterMaxWindSpeed}, structs.UsersLocationBookmark{}, "the new place was cancelled. Please start again with /add"},
+		{"naming a group", structs.UserState{CurrentState: StepEnterGroupName}, structs.UsersLocationBookmark{}, "the new group of places was cancelled. Please start again with /addgroup"},
+		{"adding a group", structs.UserState{CurrentState: StepEnterMaxWindSpeed}, group, "the new group of places was cancelled. Please start again with /addgroup"},
+		{"changing a group", structs.UserState{CurrentState: StepAddGroupMember}, group, "adding places to the group Peak District was cancelled. Please open the group in /locations"},
+		{"changing a group with Markdown in the name", structs.UserState{CurrentState: StepAddGroupMember},
+			structs.UsersLocationBookmark{GroupName: "Peak_District"}, "the group Peak\\_District was cancelled"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Contains(t, expiredWizardNotice(tt.state, tt.bookmark), tt.expected)
+		})
+	}
+}
@@ -4,8 +4,34 @@ import (
 	"encoding/json"
 	"github.com/w32blaster/bot-weather-watcher/structs"
 	"net/http"
+	"sync"
+	"time"
 )
 
+// just to avoid any dDOS filters block us :) we are not in a hurry
+const metofficeRequestInterval = 1 * time.Second
+
+// all the requests to MetOffice go one by one with the interval, the nightly checker and /check of users together
+var metofficeThrottle = &throttle{interval: metofficeRequestInterval}
+
+// throttle lets callers through not more often than once per interval
+type throttle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+// wait blocks until the interval since the previous call has passed
+func (t *throttle) wait() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if delay := t.interval - time.Since(t.last); delay > 0 {
+		time.Sleep(delay)
+	}
+	t.last = time.Now()
+}
+
 func getDailyForecastFor(locationID string, opts *structs.Opts) (*structs.RootSiteRep, error) {
 
 	resp, err := http.Get("http://datapoint.metoffice.gov.uk/public/data/val/wxfcs/all/json/" + locationID + "?res=daily&key=" + opts.MetofficeAppID)
@@ -35,3 +61,25 @@ func get3HoursForecastFor(locationID string, opts *structs.Opts) (*structs.RootS
 
 	return &result, nil
 }
+
+// ForecastProvider returns forecasts for a site location. The bot uses MetOffice, but tools
+// could load forecasts from fixtures instead
+type ForecastProvider interface {
+	DailyForecast(locationID string) (*structs.RootSiteRep, error)
+	ThreeHourlyForecast(locationID string) (*structs.RootSiteRep, error)
+}
+
+// MetOfficeProvider loads forecasts from the MetOffice DataPoint API
+type MetOfficeProvider struct {
+	Opts *structs.Opts
+}
+
+func (p MetOfficeProvider) DailyForecast(locationID string) (*structs.RootSiteRep, error) {
+	metofficeThrottle.wait()
+	return getDailyForecastFor(locationID, p.Opts)
+}
+
+func (p MetOfficeProvider) ThreeHourlyForecast(locationID string) (*structs.RootSiteRep, error) {
+	metofficeThrottle.wait()
+	return get3HoursForecastFor(locationID, p.Opts)
+}
@@ -0,0 +1,208 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/pkg/errors"
+)
+
+const (
+	metaBucket       = "meta"
+	schemaVersionKey = "schemaVersion"
+	gustLimitKeptKey = "gustLimitKept" // set by the bot that kept the gust limits on startup, before migrations
+
+	legacyOnlyWeekends = 0 // CheckPeriod of bookmarks saved before the migration 2
+)
+
+// SiteListPath is the list of MetOffice sites imported into a new database
+var SiteListPath = "api-examples/site-list.json"
+
+// Migration changes the saved data when the structs change, so old records keep their meaning.
+// The version of the applied migrations is kept in the meta bucket
+type Migration struct {
+	Version     int
+	Description string
+	up          func(tx storm.Node) error
+}
+
+// the migrations in the order they are applied, the next one should have the next version;
+// never change or remove the ones that are released already
+var migrations = []Migration{
+	{1, "import the MetOffice sites", importSites},
+	{2, "save 'only weekends' as an explicit value instead of zero", explicitOnlyWeekends},
+	{3, "keep the gust limit of bookmarks that were checked against gusts before", keepGustLimit},
+	{4, "stamp the wizard states, so the janitor doesn't expire them at once", stampUserStates},
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 for databases that have never been migrated
+func SchemaVersion(db storm.Node) (int, error) {
+	var version int
+	if err := db.Get(metaBucket, schemaVersionKey, &version); err != nil {
+		if err == storm.ErrNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// PendingMigrations returns the migrations that are not applied to the database yet
+func PendingMigrations(db storm.Node) ([]Migration, error) {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := migrations[len(migrations)-1].Version
+	if version > latest {
+		return nil, fmt.Errorf("the database schema version %d is newer than the latest known one %d, please update the bot", version, latest)
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all the pending migrations and returns them. Every migration runs in its own transaction
+// together with the new version, so a failed one leaves the database at the previous version
+func Migrate(db *storm.DB) ([]Migration, error) {
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, migration := range pending {
+		if err := applyMigration(db, migration); err != nil {
+			return pending[:i], errors.Wrapf(err, "migration %d (%s) failed", migration.Version, migration.Description)
+		}
+	}
+	return pending, nil
+}
+
+func applyMigration(db *storm.DB, migration Migration) error {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := migration.up(tx); err != nil {
+		return err
+	}
+	if err := tx.Set(metaBucket, schemaVersionKey, migration.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 1: a new database gets the sites, the ones created before migrations have them already
+func importSites(tx storm.Node) error {
+	count, err := tx.Count(new(structs.SiteLocation))
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	sites, err := readSiteList(SiteListPath)
+	if err != nil {
+		return err
+	}
+	for i := range sites {
+		if err := tx.Save(&sites[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSiteList(path string) ([]structs.SiteLocation, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read the list of sites")
+	}
+
+	var result structs.RootLocations
+	if err = json.Unmarshal(bytes, &result); err != nil {
+		return nil, errors.Wrap(err, "can't parse the list of sites")
+	}
+
+	return result.Locations.Location, nil
+}
+
+// 2: zero was "only weekends", so a bookmark that never got the value looked like a weekend one
+func explicitOnlyWeekends(tx storm.Node) error {
+	var bookmarks []structs.UsersLocationBookmark
+	if err := tx.Find("CheckPeriod", legacyOnlyWeekends, &bookmarks); err != nil {
+		if err == storm.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	for i := range bookmarks {
+		if err := tx.UpdateField(&bookmarks[i], "CheckPeriod", OnlyWeekends); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 3: the max wind speed used to be compared with gusts, now it is the sustained wind and gusts have their own
+// optional limit. The old bookmarks get it, so they are not more permissive than before; "gust < wind" of the
+// old checker is "gust <= wind - 1" of the new one. The bot did it on startup before migrations, so the databases
+// that have the flag of it are skipped, otherwise users would get back the limits they removed
+func keepGustLimit(tx storm.Node) error {
+	var kept bool
+	if err := tx.Get(metaBucket, gustLimitKeptKey, &kept); err == nil && kept {
+		return nil
+	} else if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	var bookmarks []structs.UsersLocationBookmark
+	if err := tx.Select(q.Eq("IsReady", true)).Find(&bookmarks); err != nil {
+		if err == storm.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	for i := range bookmarks {
+		if bookmarks[i].MaxGust != nil || bookmarks[i].Mode != ModeDay || bookmarks[i].Polarity != PolarityGood {
+			continue
+		}
+		if err := tx.UpdateField(&bookmarks[i], "MaxGust", intPtr(bookmarks[i].MaxWindSpeed-1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 4: the states had no time of the last step, so they get the full idle time from now
+func stampUserStates(tx storm.Node) error {
+	var states []structs.UserState
+	if err := tx.All(&states); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for i := range states {
+		if err := tx.UpdateField(&states[i], "UpdatedAt", now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
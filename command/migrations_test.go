@@ -0,0 +1,162 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+func TestMigrationVersionsAreInOrder(t *testing.T) {
+	for i, migration := range migrations {
+		assert.Equal(t, i+1, migration.Version, migration.Description)
+	}
+}
+
+func TestMigrateDatabaseBeforeMigrations(t *testing.T) {
+
+	// Given: the database as it was before migrations, with sites and weekend bookmarks saved as zero
+	dir, db := prepareFixtureDB(t, func(db *storm.DB) {
+		db.Save(&structs.SiteLocation{ID: TestLocationID, Name: "London"})
+		db.Save(&structs.UsersLocationBookmark{UserID: UserID, LocationID: TestLocationID, CheckPeriod: legacyOnlyWeekends, IsReady: true, MaxWindSpeed: 20})
+		db.Save(&structs.UsersLocationBookmark{UserID: UserID, LocationID: TestLocationID, CheckPeriod: AllDays, IsReady: true, MaxGust: intPtr(30)})
+		db.Save(&structs.UserState{UserID: UserID, CurrentState: StepEnterMaxWindSpeed})
+	})
+	defer os.RemoveAll(dir)
+	defer db.Close()
+	before := time.Now()
+
+	// When:
+	applied, err := Migrate(db)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(applied))
+
+	version, _ := SchemaVersion(db)
+	assert.Equal(t, 4, version)
+
+	// sites are not imported again
+	count, _ := db.Count(new(structs.SiteLocation))
+	assert.Equal(t, 1, count)
+
+	// and old bookmarks keep their meaning
+	var bookmarks []structs.UsersLocationBookmark
+	assert.Nil(t, db.All(&bookmarks))
+	assert.Equal(t, OnlyWeekends, bookmarks[0].CheckPeriod)
+	assert.Equal(t, AllDays, bookmarks[1].CheckPeriod)
+
+	// and keep the limit of gusts they were checked against, or their own one
+	assert.Equal(t, 19, *bookmarks[0].MaxGust)
+	assert.Equal(t, 30, *bookmarks[1].MaxGust)
+
+	// and the wizard in progress gets the full idle time
+	state, _ := NewStormRepositories(db).States.GetState(UserID)
+	assert.False(t, state.UpdatedAt.Before(before.Add(-time.Second)))
+
+	// When: the bot is restarted
+	applied, err = Migrate(db)
+
+	// Then: nothing to do
+	assert.Nil(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	if testing.Short() {
+		t.Skip("imports all the MetOffice sites")
+	}
+
+	// Given:
+	dir, db := prepareFixtureDB(t, func(db *storm.DB) {})
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	defer func(path string) { SiteListPath = path }(SiteListPath)
+	SiteListPath = "../api-examples/site-list.json"
+
+	// When:
+	applied, err := Migrate(db)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(applied))
+
+	site, err := NewStormRepositories(db).Sites.Get("3066")
+	assert.Nil(t, err)
+	assert.Equal(t, "Kinloss", site.Name)
+}
+
+func TestFailedMigrationKeepsVersion(t *testing.T) {
+
+	// Given: a new database, but there is no list of sites to import
+	dir, db := prepareFixtureDB(t, func(db *storm.DB) {})
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	defer func(path string) { SiteListPath = path }(SiteListPath)
+	SiteListPath = filepath.Join(dir, "missing.json")
+
+	// When:
+	applied, err := Migrate(db)
+
+	// Then:
+	assert.NotNil(t, err)
+	assert.Empty(t, applied)
+
+	pending, _ := PendingMigrations(db)
+	assert.Equal(t, 4, len(pending))
+}
+
+func TestMigrateDatabaseWithGustLimitKept(t *testing.T) {
+
+	// Given: the bot kept the gust limits on startup before migrations, and then user removed the limit
+	dir, db := prepareFixtureDB(t, func(db *storm.DB) {
+		db.Save(&structs.SiteLocation{ID: TestLocationID, Name: "London"})
+		db.Save(&structs.UsersLocationBookmark{UserID: UserID, LocationID: TestLocationID, CheckPeriod: AllDays, IsReady: true, MaxWindSpeed: 20})
+		db.Set(metaBucket, gustLimitKeptKey, true)
+	})
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	// When:
+	_, err := Migrate(db)
+
+	// Then: the limit is not set again
+	assert.Nil(t, err)
+
+	var bookmarks []structs.UsersLocationBookmark
+	assert.Nil(t, db.All(&bookmarks))
+	assert.Nil(t, bookmarks[0].MaxGust)
+}
+
+func TestMigrateDatabaseOfNewerBot(t *testing.T) {
+
+	// Given: the database was migrated by a newer version of the bot
+	dir, db := prepareFixtureDB(t, func(db *storm.DB) {
+		db.Set(metaBucket, schemaVersionKey, 99)
+	})
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	// When:
+	_, err := Migrate(db)
+
+	// Then:
+	assert.NotNil(t, err)
+}
+
+// creates the database with the given content, as it would be left by an older version of the bot
+func prepareFixtureDB(t *testing.T, fill func(db *storm.DB)) (string, *storm.DB) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "storm")
+	db, err := OpenDB(filepath.Join(dir, "weather.db"))
+	assert.Nil(t, err)
+
+	fill(db)
+	return dir, db
+}
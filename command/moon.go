@@ -0,0 +1,59 @@
+package command
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const synodicMonth = 29.530588853 // days between two new moons
+
+// one of the known new moons, all the others are calculated from it
+var knownNewMoon = time.Date(2000, time.January, 6, 18, 14, 0, 0, time.UTC)
+
+type moonPhaseName struct {
+	name string
+	icon rune
+}
+
+// eight phases, starting from the new moon
+var moonPhases = []moonPhaseName{
+	{"new moon", '🌑'},
+	{"waxing crescent", '🌒'},
+	{"first quarter", '🌓'},
+	{"waxing gibbous", '🌔'},
+	{"full moon", '🌕'},
+	{"waning gibbous", '🌖'},
+	{"last quarter", '🌗'},
+	{"waning crescent", '🌘'},
+}
+
+// returns the age of the moon as a fraction of the synodic month: 0 is the new moon, 0.5 is the full moon.
+// It is an approximation, but good enough to say whether the night will be dark
+// Please refer to unit tests
+func moonPhase(t time.Time) float64 {
+	days := t.Sub(knownNewMoon).Hours() / 24
+	phase := math.Mod(days/synodicMonth, 1)
+	if phase < 0 {
+		phase++
+	}
+	return phase
+}
+
+// returns the illuminated fraction of the moon disk, from 0 to 1
+func moonIllumination(phase float64) float64 {
+	return (1 - math.Cos(2*math.Pi*phase)) / 2
+}
+
+// returns the name of the moon phase
+func moonPhaseNameFor(phase float64) moonPhaseName {
+	index := int(math.Floor(phase*8+0.5)) % len(moonPhases)
+	return moonPhases[index]
+}
+
+// human readable description of the moon at the night after the given date, for example "🌒 waxing crescent, 23% lit"
+func describeMoon(date time.Time) string {
+	phase := moonPhase(date.Add(23 * time.Hour))
+	name := moonPhaseNameFor(phase)
+	return fmt.Sprintf("%c %s, %d%% lit", name.icon, name.name, int(math.Round(moonIllumination(phase)*100)))
+}
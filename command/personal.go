@@ -0,0 +1,147 @@
+package command
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+const auditForgetMe = "forgetme" // the audit action of /forgetme
+
+// PersonalData is everything saved about one user, it is sent by /mydata
+type PersonalData struct {
+	UserID    int                             `json:"userId"`
+	Bookmarks []structs.UsersLocationBookmark `json:"bookmarks"`
+	State     *structs.UserState              `json:"state,omitempty"`    // only while user is adding or changing a bookmark
+	Settings  *structs.UserSettings           `json:"settings,omitempty"` // only if user changed the defaults
+	Events    []structs.Event                 `json:"events"`             // what user did and was sent, for the retention period
+}
+
+// CollectPersonalData finds all the records tied to the user ID
+func CollectPersonalData(repos Repositories, userID int) (PersonalData, error) {
+	data := PersonalData{UserID: userID}
+
+	var err error
+	if data.Bookmarks, err = repos.Bookmarks.FindByUser(userID); err != nil {
+		return data, err
+	}
+
+	if state, err := repos.States.GetState(userID); err == nil {
+		data.State = &state
+	} else if err != ErrNotFound {
+		return data, err
+	}
+
+	if settings, err := repos.States.GetSettings(userID); err == nil {
+		data.Settings = &settings
+	} else if err != ErrNotFound {
+		return data, err
+	}
+
+	if data.Events, err = repos.Events.Find(EventFilter{UserID: userID}); err != nil {
+		return data, err
+	}
+
+	return data, nil
+}
+
+// ForgetUser removes every record tied to the user ID and writes the audit entry with numbers of removed records
+func ForgetUser(repos Repositories, userID int, now time.Time) (structs.AuditEntry, error) {
+	entry := structs.AuditEntry{Action: auditForgetMe, Time: now.UTC()}
+
+	data, err := CollectPersonalData(repos, userID)
+	if err != nil {
+		return entry, err
+	}
+
+	if err := repos.Bookmarks.DeleteAllForUser(userID); err != nil {
+		return entry, err
+	}
+	entry.Bookmarks = len(data.Bookmarks)
+
+	if data.State != nil {
+		if err := repos.States.DeleteState(userID); err != nil {
+			return entry, err
+		}
+		entry.States = 1
+	}
+
+	if data.Settings != nil {
+		if err := repos.States.DeleteSettings(userID); err != nil {
+			return entry, err
+		}
+		entry.Settings = 1
+	}
+
+	if entry.Events, err = repos.Events.DeleteForUser(userID); err != nil {
+		return entry, err
+	}
+
+	return entry, repos.Audit.Record(&entry)
+}
+
+// SendPersonalData sends the JSON document with everything we store about the user
+func SendPersonalData(bot *tgbotapi.BotAPI, repos Repositories, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+
+	data, err := CollectPersonalData(repos, message.From.ID)
+	if err != nil {
+		sentry.CaptureException(err)
+		sendMsg(bot, chatID, "Sorry, internal error occurred, please try again later")
+		return
+	}
+
+	bytes, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		sentry.CaptureException(err)
+		sendMsg(bot, chatID, "Sorry, internal error occurred, please try again later")
+		return
+	}
+
+	sendMsg(bot, chatID, "This is everything I store about you. To delete all of it please use /forgetme")
+	document := tgbotapi.NewDocumentUpload(chatID, tgbotapi.FileBytes{Name: "mydata.json", Bytes: bytes})
+	if _, err := bot.Send(document); err != nil {
+		sentry.CaptureException(err)
+	}
+}
+
+// AskForgetMe asks to confirm that all the data should be deleted, the answer is processed by processForgetMeButton
+func AskForgetMe(bot *tgbotapi.BotAPI, chatID int64) {
+	msg, err := sendMsg(bot, chatID, "This will delete all your bookmarks, settings and everything else I store about you. "+
+		"It can't be undone. Are you sure?")
+	if err != nil {
+		return
+	}
+
+	rowButtons := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🗑 Yes, delete everything", callbackData(ButtonForgetMe, "yes")),
+		tgbotapi.NewInlineKeyboardButtonData("No, keep it", callbackData(ButtonForgetMe, "no")),
+	}
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, msg.MessageID, newInlineKeyboard(rowButtons))
+	bot.Send(keyboardMsg)
+}
+
+func processForgetMeButton(bot *tgbotapi.BotAPI, repos Repositories, callbackQuery *tgbotapi.CallbackQuery, choice string) {
+	chatID := callbackQuery.Message.Chat.ID
+	removeButtons(bot, chatID, callbackQuery.Message.MessageID)
+
+	if choice != "yes" {
+		sendMsg(bot, chatID, "OK, nothing was deleted")
+		return
+	}
+
+	// the user ID is not logged, the audit entry is enough
+	if _, err := ForgetUser(repos, callbackQuery.From.ID, time.Now()); err != nil {
+		sentry.CaptureException(errors.Wrap(err, "can't delete all the data of a user"))
+		sendMsg(bot, chatID, "Sorry, internal error occurred, not everything was deleted. Please try again later.")
+		return
+	}
+
+	sendMsg(bot, chatID, "✅ Deleted. I don't store anything about you anymore")
+}
@@ -0,0 +1,77 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+func TestCollectPersonalData(t *testing.T) {
+
+	// Given: the user is in the middle of adding the second bookmark
+	repos := preparePersonalData()
+
+	// When:
+	data, err := CollectPersonalData(repos, UserID)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, UserID, data.UserID)
+	assert.Equal(t, 2, len(data.Bookmarks))
+	assert.Equal(t, StepEnterMaxWindSpeed, data.State.CurrentState)
+	assert.Equal(t, groupByLocation, data.Settings.DigestGroup)
+	assert.Equal(t, 2, len(data.Events))
+	assert.Equal(t, "locations", data.Events[0].Details)
+
+	// and nothing of other users
+	data, err = CollectPersonalData(repos, 333)
+	assert.Nil(t, err)
+	assert.Empty(t, data.Bookmarks)
+	assert.Empty(t, data.Events)
+	assert.Nil(t, data.State)
+	assert.Nil(t, data.Settings)
+}
+
+func TestForgetUser(t *testing.T) {
+
+	// Given:
+	repos := preparePersonalData()
+	now := time.Date(2019, 11, 8, 10, 0, 0, 0, time.UTC)
+
+	// When:
+	entry, err := ForgetUser(repos, UserID, now)
+
+	// Then: no traces of the user
+	assert.Nil(t, err)
+	data, _ := CollectPersonalData(repos, UserID)
+	assert.Empty(t, data.Bookmarks)
+	assert.Nil(t, data.State)
+	assert.Nil(t, data.Settings)
+	assert.Empty(t, data.Events)
+
+	// but other users keep everything
+	others, _ := repos.Bookmarks.FindByUser(User2ID)
+	assert.Equal(t, 1, len(others))
+	othersEvents, _ := repos.Events.Find(EventFilter{UserID: User2ID})
+	assert.Equal(t, 1, len(othersEvents))
+
+	// and the deletion is recorded without the user
+	entries, _ := repos.Audit.FindByAction(auditForgetMe)
+	assert.Equal(t, []structs.AuditEntry{entry}, entries)
+	assert.Equal(t, structs.AuditEntry{ID: entry.ID, Action: auditForgetMe, Time: now, Bookmarks: 2, States: 1, Settings: 1, Events: 2}, entry)
+}
+
+func preparePersonalData() Repositories {
+	repos := prepareRepositories()
+	repos.Bookmarks.Save(&structs.UsersLocationBookmark{UserID: UserID, UserName: UserName, LocationID: TestLocationID, IsReady: true})
+	repos.Bookmarks.Save(&structs.UsersLocationBookmark{UserID: UserID, UserName: UserName, LocationID: TestLocation2ID})
+	repos.Bookmarks.Save(&structs.UsersLocationBookmark{UserID: User2ID, LocationID: TestLocationID, IsReady: true})
+	repos.States.SaveState(&structs.UserState{UserID: UserID, CurrentState: StepEnterMaxWindSpeed})
+	SaveDigestGrouping(repos.States, UserID, groupByLocation)
+	RecordEvent(repos, structs.Event{Type: EventCommandUsed, UserID: UserID, Details: "add"})
+	RecordEvent(repos, structs.Event{Type: EventCommandUsed, UserID: UserID, Details: "locations"})
+	RecordEvent(repos, structs.Event{Type: EventCommandUsed, UserID: User2ID, Details: "add"})
+	return repos
+}
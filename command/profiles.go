@@ -0,0 +1,229 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	answerCustomProfile = "custom" // the answer of the wizard when user wants to enter all the numbers
+	maxProfileKeyLength = 32       // the key is sent in the button data, that is limited by Telegram
+
+	profileDaysWeekends = "weekends"
+	profileDaysAll      = "all"
+
+	profileWindowDay    = "day"
+	profileWindowNight  = "night"
+	profileWindowGolden = "golden"
+)
+
+type (
+	// Profile is a preset of thresholds for one activity, so user doesn't have to invent numbers
+	// for wind and temperature from scratch. Admins can add more profiles, see LoadProfiles
+	Profile struct {
+		Key             string   `yaml:"key"`
+		Name            string   `yaml:"name"`
+		Icon            string   `yaml:"icon"`
+		MinTemp         int      `yaml:"mintemp"`
+		MaxTemp         *int     `yaml:"maxtemp"`
+		MaxWind         int      `yaml:"maxwind"`
+		MaxGust         *int     `yaml:"maxgust"`
+		MaxPrecipProb   *int     `yaml:"maxprecipprob"`
+		ExcludedWeather []string `yaml:"excludedweather"` // names of the weather categories, for example "fog" or "snow"
+		Days            string   `yaml:"days"`            // "weekends" or "all"
+		Window          string   `yaml:"window"`          // "day", "night" or "golden"; the day is the default one
+	}
+
+	yamlProfiles struct {
+		Profiles []Profile `yaml:"profiles"`
+	}
+)
+
+var builtinProfiles = []Profile{
+	{Key: "motorcycling", Name: "Motorcycling", Icon: "🏍", MinTemp: 10, MaxWind: 20, MaxGust: intPtr(30), MaxPrecipProb: intPtr(20),
+		ExcludedWeather: []string{"fog", "showers", "snow", "thunder"}, Days: profileDaysAll},
+	{Key: "cycling", Name: "Road cycling", Icon: "🚴", MinTemp: 8, MaxTemp: intPtr(28), MaxWind: 15, MaxGust: intPtr(25), MaxPrecipProb: intPtr(30),
+		ExcludedWeather: []string{"showers", "snow", "thunder"}, Days: profileDaysAll},
+	{Key: "hiking", Name: "Hiking", Icon: "🥾", MinTemp: 5, MaxTemp: intPtr(26), MaxWind: 25, MaxGust: intPtr(40),
+		ExcludedWeather: []string{"snow", "thunder"}, Days: profileDaysWeekends},
+	{Key: "photography", Name: "Landscape photography", Icon: "📷", MinTemp: -5, MaxWind: 20, MaxPrecipProb: intPtr(30),
+		ExcludedWeather: []string{"overcast"}, Days: profileDaysAll, Window: profileWindowGolden},
+	{Key: "sailing", Name: "Sailing", Icon: "⛵", MinTemp: 10, MaxWind: 20, MaxGust: intPtr(28),
+		ExcludedWeather: []string{"fog", "thunder"}, Days: profileDaysWeekends},
+}
+
+// the profiles offered by the wizard, built-in ones plus the ones from the config file
+var profiles = builtinProfiles
+
+// LoadProfiles reads more profiles from the YAML config file. A profile with the same key as a built-in
+// one replaces it, so admins can change the built-in numbers too. Please refer to api-examples/profiles-example.yaml
+func LoadProfiles(path string) error {
+	custom, err := readProfiles(path)
+	if err != nil {
+		return err
+	}
+
+	profiles = mergeProfiles(builtinProfiles, custom)
+	return nil
+}
+
+func readProfiles(path string) ([]Profile, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read profiles file")
+	}
+
+	var parsed yamlProfiles
+	if err := yaml.Unmarshal(bytes, &parsed); err != nil {
+		return nil, errors.Wrap(err, "can't parse profiles file")
+	}
+
+	keys := make(map[string]bool)
+	for _, profile := range parsed.Profiles {
+		if err := profile.validate(); err != nil {
+			return nil, errors.Wrap(err, "wrong profile '"+profile.Key+"'")
+		}
+		if keys[profile.Key] {
+			return nil, errors.New("profile '" + profile.Key + "' is defined twice")
+		}
+		keys[profile.Key] = true
+	}
+
+	return parsed.Profiles, nil
+}
+
+// custom profiles replace the built-in ones with the same key, new ones go to the end of the list
+func mergeProfiles(builtin, custom []Profile) []Profile {
+	merged := make([]Profile, len(builtin))
+	copy(merged, builtin)
+
+	for _, profile := range custom {
+		replaced := false
+		for i := range merged {
+			if merged[i].Key == profile.Key {
+				merged[i] = profile
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, profile)
+		}
+	}
+	return merged
+}
+
+// ApplyProfile sets all the thresholds of the bookmark from the profile with the key
+func ApplyProfile(key string, bookmark *structs.UsersLocationBookmark) error {
+	profile, ok := findProfile(key)
+	if !ok {
+		return errors.New("unknown profile '" + key + "'")
+	}
+	profile.applyTo(bookmark)
+	return nil
+}
+
+// returns the profile by its key
+func findProfile(key string) (Profile, bool) {
+	for _, profile := range profiles {
+		if profile.Key == key {
+			return profile, true
+		}
+	}
+	return Profile{}, false
+}
+
+func (p Profile) validate() error {
+	if len(p.Key) == 0 || len(p.Key) > maxProfileKeyLength || strings.Contains(p.Key, Separator) {
+		return fmt.Errorf("the key should be from 1 to %d characters without '%s'", maxProfileKeyLength, Separator)
+	}
+	if p.Key == answerCustomProfile || p.Key == ButtonDone || p.Key == ButtonSkip {
+		return errors.New("the key '" + p.Key + "' is reserved")
+	}
+	if len(p.Name) == 0 {
+		return errors.New("the name is required")
+	}
+	if p.MaxWind <= 0 {
+		return errors.New("the max wind should be a positive number")
+	}
+	if p.Days != profileDaysWeekends && p.Days != profileDaysAll {
+		return errors.New("days should be either '" + profileDaysWeekends + "' or '" + profileDaysAll + "'")
+	}
+	if p.Window != "" && p.Window != profileWindowDay && p.Window != profileWindowNight && p.Window != profileWindowGolden {
+		return errors.New("window should be one of '" + profileWindowDay + "', '" + profileWindowNight + "' or '" + profileWindowGolden + "'")
+	}
+	_, err := WeatherCategoryFlags(p.ExcludedWeather)
+	return err
+}
+
+// sets all the thresholds of the bookmark from the profile
+func (p Profile) applyTo(bookmark *structs.UsersLocationBookmark) {
+	bookmark.Profile = p.Key
+	bookmark.LowestTemp = p.MinTemp
+	bookmark.MaxTemp = copyOptional(p.MaxTemp)
+	bookmark.MaxWindSpeed = p.MaxWind
+	bookmark.MaxGust = copyOptional(p.MaxGust)
+	bookmark.MaxPrecipProb = copyOptional(p.MaxPrecipProb)
+	bookmark.ExcludedWeather, _ = WeatherCategoryFlags(p.ExcludedWeather) // the profile was validated on load
+
+	bookmark.CheckPeriod = OnlyWeekends
+	if p.Days == profileDaysAll {
+		bookmark.CheckPeriod = AllDays
+	}
+
+	bookmark.Mode = ModeDay
+	bookmark.MinNightTemp = nil
+	if p.Window == profileWindowNight {
+		bookmark.Mode = ModeNight
+		bookmark.MinNightTemp = intPtr(p.MinTemp) // nights are checked by the lowest temperature
+	}
+	bookmark.GoldenHourOnly = p.Window == profileWindowGolden
+}
+
+// the profile name with its icon, for example "🏍 Motorcycling"
+func (p Profile) title() string {
+	if len(p.Icon) == 0 {
+		return p.Name
+	}
+	return p.Icon + " " + p.Name
+}
+
+// the title of the profile that the bookmark was created from; the key is returned if admins removed the profile
+func profileTitle(key string) string {
+	if profile, ok := findProfile(key); ok {
+		return profile.title()
+	}
+	return key
+}
+
+func renderProfilesKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var buttonRows [][]tgbotapi.InlineKeyboardButton
+	for _, profile := range profiles {
+		buttonRows = append(buttonRows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(profile.title(), callbackData(ButtonWizardAnswer, profile.Key)),
+		})
+	}
+
+	buttonRows = append(buttonRows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("✏ Custom, I'll enter all the numbers", callbackData(ButtonWizardAnswer, answerCustomProfile)),
+	})
+
+	return newInlineKeyboard(buttonRows...)
+}
+
+func intPtr(value int) *int {
+	return &value
+}
+
+func copyOptional(value *int) *int {
+	if value == nil {
+		return nil
+	}
+	return intPtr(*value)
+}
@@ -0,0 +1,111 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+func TestBuiltinProfilesAreValid(t *testing.T) {
+	for _, profile := range builtinProfiles {
+		assert.Nil(t, profile.validate(), profile.Key)
+	}
+}
+
+func TestReadProfilesExample(t *testing.T) {
+
+	// When:
+	custom, err := readProfiles("../api-examples/profiles-example.yaml")
+	merged := mergeProfiles(builtinProfiles, custom)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(custom))
+	assert.Equal(t, len(builtinProfiles)+2, len(merged))
+
+	// the hiking profile is replaced, the new ones go to the end
+	assert.Equal(t, "hiking", merged[2].Key)
+	assert.Equal(t, "Hiking in the hills", merged[2].Name)
+	assert.Equal(t, "kitesurfing", merged[len(merged)-2].Key)
+	assert.Equal(t, "stargazing", merged[len(merged)-1].Key)
+}
+
+func TestProfileValidation(t *testing.T) {
+	valid := Profile{Key: "kayaking", Name: "Kayaking", MaxWind: 15, Days: profileDaysAll}
+
+	var tests = []struct {
+		name    string
+		change  func(p *Profile)
+		isValid bool
+	}{
+		{"valid one", func(p *Profile) {}, true},
+		{"golden hours", func(p *Profile) { p.Window = profileWindowGolden }, true},
+		{"weather by the full name", func(p *Profile) { p.ExcludedWeather = []string{"Fog/mist", "SNOW"} }, true},
+		{"no key", func(p *Profile) { p.Key = "" }, false},
+		{"key with separator", func(p *Profile) { p.Key = "a" + Separator + "b" }, false},
+		{"reserved key", func(p *Profile) { p.Key = answerCustomProfile }, false},
+		{"no name", func(p *Profile) { p.Name = "" }, false},
+		{"no wind", func(p *Profile) { p.MaxWind = 0 }, false},
+		{"unknown days", func(p *Profile) { p.Days = "weekdays" }, false},
+		{"unknown window", func(p *Profile) { p.Window = "evening" }, false},
+		{"unknown weather", func(p *Profile) { p.ExcludedWeather = []string{"hail"} }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := valid
+			tt.change(&profile)
+			assert.Equal(t, tt.isValid, profile.validate() == nil)
+		})
+	}
+}
+
+func TestReadProfilesWithDuplicates(t *testing.T) {
+
+	// Given:
+	dir, _ := ioutil.TempDir("", "profiles")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "profiles.yaml")
+	ioutil.WriteFile(path, []byte(`
+profiles:
+  - {key: kayaking, name: Kayaking, maxwind: 15, days: all}
+  - {key: kayaking, name: Kayaking again, maxwind: 10, days: all}
+`), 0644)
+
+	// When:
+	_, err := readProfiles(path)
+
+	// Then:
+	assert.NotNil(t, err)
+}
+
+func TestApplyProfile(t *testing.T) {
+
+	// Given:
+	maxGust := 60
+	bookmark := structs.UsersLocationBookmark{ID: 1, LocationID: TestLocationID, MaxGust: &maxGust, WindDirections: 3}
+	profile, ok := findProfile("photography")
+
+	// When:
+	profile.applyTo(&bookmark)
+
+	// Then:
+	assert.True(t, ok)
+	assert.Equal(t, "photography", bookmark.Profile)
+	assert.Equal(t, -5, bookmark.LowestTemp)
+	assert.Equal(t, 20, bookmark.MaxWindSpeed)
+	assert.Nil(t, bookmark.MaxGust)
+	assert.Equal(t, 30, *bookmark.MaxPrecipProb)
+	assert.Equal(t, excludeOvercast, bookmark.ExcludedWeather)
+	assert.Equal(t, AllDays, bookmark.CheckPeriod)
+	assert.True(t, bookmark.GoldenHourOnly)
+	assert.Equal(t, 3, bookmark.WindDirections) // not a part of profiles
+
+	// and the bookmark doesn't share the numbers with the profile
+	*bookmark.MaxPrecipProb = 50
+	assert.Equal(t, 30, *profile.MaxPrecipProb)
+}
@@ -0,0 +1,219 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+type (
+	// CheckReport is the result of one run of the checker: every bookmark and every day, with figures,
+	// criteria and decisions. It is built without sending anything, see BuildCheckReport
+	CheckReport struct {
+		Bookmarks []BookmarkReport `json:"bookmarks"`
+	}
+
+	// BookmarkReport is the result for one bookmark, group bookmarks have a report per every site
+	BookmarkReport struct {
+		BookmarkID int          `json:"bookmarkId"`
+		UserID     int          `json:"userId"`
+		Title      string       `json:"title"`
+		Sites      []SiteReport `json:"sites"`
+		Runs       []Run        `json:"runs,omitempty"` // only for bookmarks that need several days in a row
+
+		bookmark   structs.UsersLocationBookmark
+		goodDays   []goodDay // the good days or runs that should be sent in the digest
+		hazardDays []goodDay // the bad weather warnings
+	}
+
+	// SiteReport is the result for one site of a bookmark
+	SiteReport struct {
+		LocationID string          `json:"locationId"`
+		Name       string          `json:"name"`
+		Error      string          `json:"error,omitempty"`
+		Days       []DayEvaluation `json:"days"`
+
+		err      error
+		forecast *structs.RootSiteRep
+	}
+)
+
+// the operator of the failed criterion, for example "wind 24 ≥ 20mph" for the criterion "wind < 20mph"
+var failedOperators = map[string]string{
+	"<":        "≥",
+	"<=":       ">",
+	">":        "≤",
+	">=":       "<",
+	"in":       "not in",
+	"not":      "is",
+	"overlaps": "doesn't overlap",
+}
+
+// BuildCheckReport evaluates the bookmarks against forecasts and decides what should be sent.
+// It doesn't send anything anywhere, so it is safe to use in tools and tests; forecasts are loaded by
+// the provider, and names of sites are taken from mapLocs
+func BuildCheckReport(bookmarks []structs.UsersLocationBookmark, mapLocs map[string]structs.SiteLocation, provider ForecastProvider) CheckReport {
+	var report CheckReport
+	for _, bookmark := range bookmarks {
+		report.Bookmarks = append(report.Bookmarks, buildBookmarkReport(bookmark, mapLocs, provider))
+	}
+	return report
+}
+
+func buildBookmarkReport(loc structs.UsersLocationBookmark, mapLocs map[string]structs.SiteLocation, provider ForecastProvider) BookmarkReport {
+	report := BookmarkReport{
+		BookmarkID: loc.ID,
+		UserID:     loc.UserID,
+		Title:      bookmarkTitle(loc, mapLocs),
+		bookmark:   loc,
+	}
+
+	// every site of a group is checked with the same thresholds, a usual bookmark is a group of one site
+	var members []memberEvaluations
+	for _, locationID := range MemberLocationIDs(loc) {
+		member := loc
+		member.LocationID = locationID
+
+		site := SiteReport{LocationID: locationID, Name: mapLocs[locationID].Name}
+		site.forecast, site.err = ForecastFor(provider, member)
+		if site.err == nil {
+			site.Days, site.err = EvaluateBookmark(member, site.forecast)
+			members = append(members, memberEvaluations{locationID: locationID, evaluations: site.Days})
+		}
+		if site.err != nil {
+			site.Error = site.err.Error()
+		}
+
+		report.Sites = append(report.Sites, site)
+	}
+
+	if loc.Polarity == PolarityHazard {
+
+		// every site of a group is warned about separately, the bad weather at one of them is enough
+		for _, member := range members {
+			for _, evaluation := range member.evaluations {
+				if !evaluation.Skipped && evaluation.IsHazardous {
+					report.hazardDays = append(report.hazardDays, newHazardDay(loc, mapLocs[member.locationID], evaluation))
+				}
+			}
+		}
+		return report
+	}
+
+	days := combineGroupEvaluations(members)
+	if loc.MinRunLength > 1 {
+
+		// for longer trips report runs of good days instead of single days
+		evaluations := make([]DayEvaluation, len(days))
+		for i, day := range days {
+			evaluations[i] = day.evaluation
+		}
+		report.Runs = FindRuns(evaluations, loc.MinRunLength)
+		for _, run := range report.Runs {
+			report.goodDays = append(report.goodDays, newGoodRunOfGroup(loc, days, run, mapLocs))
+		}
+	} else {
+		for _, day := range days {
+			if day.evaluation.IsSuitable {
+				report.goodDays = append(report.goodDays, newGoodDayOfGroup(loc, day, mapLocs))
+			}
+		}
+	}
+
+	return report
+}
+
+// HasNews returns true if there is something to send: good days or bad weather warnings
+func (r CheckReport) HasNews() bool {
+	return len(r.goodDays()) > 0 || len(r.hazardDays()) > 0
+}
+
+func (r CheckReport) goodDays() []goodDay {
+	var days []goodDay
+	for _, bookmark := range r.Bookmarks {
+		days = append(days, bookmark.goodDays...)
+	}
+	return days
+}
+
+func (r CheckReport) hazardDays() []goodDay {
+	var days []goodDay
+	for _, bookmark := range r.Bookmarks {
+		days = append(days, bookmark.hazardDays...)
+	}
+	return days
+}
+
+// Explain renders why the days are not good, for example "Sat failed: wind 24 ≥ 20mph"
+// Please refer to unit tests
+func (r CheckReport) Explain() string {
+	var buffer bytes.Buffer
+	for _, bookmark := range r.Bookmarks {
+		buffer.WriteString("📍 " + bookmark.Title + "\n")
+
+		for _, site := range bookmark.Sites {
+			prefix := " "
+			if len(bookmark.bookmark.GroupLocationIDs) > 0 {
+				buffer.WriteString("  " + site.Name + "\n")
+				prefix = "   "
+			}
+
+			if site.err != nil && len(site.Days) == 0 {
+				buffer.WriteString(prefix + "can't get the forecast\n")
+				continue
+			}
+
+			for _, day := range site.Days {
+				if day.Skipped {
+					continue
+				}
+				buffer.WriteString(prefix + explainDay(bookmark.bookmark, day) + "\n")
+			}
+		}
+
+		if bookmark.bookmark.MinRunLength > 1 && len(bookmark.Runs) == 0 {
+			buffer.WriteString(" no " + strconv.Itoa(bookmark.bookmark.MinRunLength) + " good days in a row\n")
+		}
+		buffer.WriteString("\n")
+	}
+	return buffer.String()
+}
+
+// one day of the explanation, for example "Sat failed: wind 24 ≥ 20mph, precipitation 60 ≥ 40%"
+func explainDay(bookmark structs.UsersLocationBookmark, day DayEvaluation) string {
+	weekday := day.date.Format("Mon")
+	if bookmark.Polarity == PolarityHazard {
+		if day.IsHazardous {
+			return weekday + ": " + triggeredHazards(day.Criteria)
+		}
+		return weekday + ": no hazards"
+	}
+
+	if day.IsSuitable {
+		return weekday + " is good"
+	}
+
+	var failed []string
+	for _, criterion := range day.Criteria {
+		if !criterion.Passed {
+			failed = append(failed, criterion.describeFailure())
+		}
+	}
+	return weekday + " failed: " + strings.Join(failed, ", ")
+}
+
+// the failed criterion with the inverted operator, for example "wind 24 ≥ 20mph"
+func (c Criterion) describeFailure() string {
+	if len(c.Operator) == 0 {
+		return fmt.Sprintf("%s %s", c.Name, c.Value)
+	}
+
+	operator, ok := failedOperators[c.Operator]
+	if !ok {
+		operator = "not " + c.Operator
+	}
+	return fmt.Sprintf("%s %s %s %s%s", c.Name, c.Value, operator, c.Threshold, c.Unit)
+}
@@ -0,0 +1,100 @@
+package command
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+type failingForecastProvider struct{}
+
+func (p failingForecastProvider) DailyForecast(locationID string) (*structs.RootSiteRep, error) {
+	return nil, errors.New("MetOffice is down")
+}
+
+func (p failingForecastProvider) ThreeHourlyForecast(locationID string) (*structs.RootSiteRep, error) {
+	return nil, errors.New("MetOffice is down")
+}
+
+func TestBuildCheckReport(t *testing.T) {
+
+	// Given:
+	provider := fakeForecastProvider{daily: loadDailyForecast(t)}
+	bookmarks := []structs.UsersLocationBookmark{
+		{ID: 1, UserID: UserID, LocationID: TestLocationID, MaxWindSpeed: 20, LowestTemp: 10, CheckPeriod: AllDays},
+	}
+	mapLocs := map[string]structs.SiteLocation{TestLocationID: {ID: TestLocationID, Name: "Keswick"}}
+
+	// When:
+	report := BuildCheckReport(bookmarks, mapLocs, provider)
+
+	// Then:
+	assert.True(t, report.HasNews())
+	assert.Equal(t, 1, len(report.Bookmarks))
+	assert.Equal(t, "Keswick", report.Bookmarks[0].Title)
+	assert.Equal(t, 1, len(report.Bookmarks[0].Sites))
+	assert.Equal(t, 5, len(report.Bookmarks[0].Sites[0].Days))
+	assert.Equal(t, 3, len(report.goodDays())) // Fri, Sat and Sun
+	assert.Empty(t, report.hazardDays())
+}
+
+func TestExplainCheckReport(t *testing.T) {
+
+	// Given:
+	provider := fakeForecastProvider{daily: loadDailyForecast(t)}
+	bookmarks := []structs.UsersLocationBookmark{
+		{ID: 1, UserID: UserID, LocationID: TestLocationID, MaxWindSpeed: 20, LowestTemp: 20, CheckPeriod: OnlyWeekends},
+	}
+	mapLocs := map[string]structs.SiteLocation{TestLocationID: {ID: TestLocationID, Name: "Keswick"}}
+
+	// When:
+	report := BuildCheckReport(bookmarks, mapLocs, provider)
+	text := report.Explain()
+
+	// Then:
+	assert.False(t, report.HasNews())
+	assert.True(t, strings.HasPrefix(text, "📍 Keswick"))
+	assert.Contains(t, text, "Fri failed: temperature 12 ≤ 20˚C")
+	assert.Contains(t, text, "Sat failed: temperature 14 ≤ 20˚C")
+	assert.NotContains(t, text, "Thu") // not in the checked period
+}
+
+func TestExplainCheckReportWithoutForecast(t *testing.T) {
+
+	// Given:
+	bookmarks := []structs.UsersLocationBookmark{
+		{ID: 1, UserID: UserID, LocationID: TestLocationID, MaxWindSpeed: 20, LowestTemp: 10, CheckPeriod: AllDays},
+	}
+
+	// When:
+	report := BuildCheckReport(bookmarks, map[string]structs.SiteLocation{}, failingForecastProvider{})
+
+	// Then:
+	assert.False(t, report.HasNews())
+	assert.Equal(t, "MetOffice is down", report.Bookmarks[0].Sites[0].Error)
+	assert.Contains(t, report.Explain(), "can't get the forecast")
+}
+
+func TestCriterionFailureDescription(t *testing.T) {
+
+	var tests = []struct {
+		criterion Criterion
+		expected  string
+	}{
+		{newCriterion("wind", 24, "<", 20, "mph", false), "wind 24 ≥ 20mph"},
+		{newCriterion("gust", 45, "<=", 40, "mph", false), "gust 45 > 40mph"},
+		{newCriterion("temperature", 8, ">", 10, "˚C", false), "temperature 8 ≤ 10˚C"},
+		{Criterion{Name: "weather", Value: "Fog", Operator: "not", Threshold: "Fog/mist"}, "weather Fog is Fog/mist"},
+		{Criterion{Name: "wind direction", Value: "NE", Operator: "in", Threshold: "W, SW"}, "wind direction NE not in W, SW"},
+		{Criterion{Name: "night forecast", Value: "missing"}, "night forecast missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.criterion.describeFailure())
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package command
+
+import (
+	"errors"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+var (
+	// ErrNotFound is returned by repositories when the one requested record doesn't exist; searches
+	// return empty results instead
+	ErrNotFound = errors.New("not found")
+
+	// ErrAlreadyExists is returned when a unique field, such as the user of a state, is taken already
+	ErrAlreadyExists = errors.New("already exists")
+)
+
+type (
+	// BookmarkRepository keeps bookmarks of users, both finished and the ones being added right now
+	BookmarkRepository interface {
+
+		// Save inserts a new bookmark (and sets its ID) or replaces the saved one with the same ID
+		Save(bookmark *structs.UsersLocationBookmark) error
+
+		// UpdateField changes one field of the saved bookmark; the passed struct is not changed
+		UpdateField(bookmark *structs.UsersLocationBookmark, field string, value interface{}) error
+
+		Get(id int) (structs.UsersLocationBookmark, error)
+		Delete(bookmark *structs.UsersLocationBookmark) error
+
+		// FindByUser returns all the bookmarks of the user, finished or not
+		FindByUser(userID int) ([]structs.UsersLocationBookmark, error)
+
+		// FindReady returns finished bookmarks of the user, or of all the users if userID is -1
+		FindReady(userID int) ([]structs.UsersLocationBookmark, error)
+
+		// FindUnfinished returns the bookmark that the user is adding right now
+		FindUnfinished(userID int) (structs.UsersLocationBookmark, error)
+
+		// FindAllUnfinished returns the bookmarks of all the users that are not finished yet
+		FindAllUnfinished() ([]structs.UsersLocationBookmark, error)
+
+		DeleteUnfinished(userID int) error
+		DeleteAllForUser(userID int) error
+	}
+
+	// UserStateRepository keeps the step of the wizard and the settings of every user
+	UserStateRepository interface {
+		GetState(userID int) (structs.UserState, error)
+
+		// SaveState inserts a new state (one per user, ErrAlreadyExists otherwise) or replaces the saved one;
+		// it and UpdateCurrentState set UpdatedAt to the current time
+		SaveState(state *structs.UserState) error
+		UpdateCurrentState(userID int, newState int) error
+		DeleteState(userID int) error
+
+		// FindIdle returns the states that were not updated since the given time
+		FindIdle(before time.Time) ([]structs.UserState, error)
+
+		GetSettings(userID int) (structs.UserSettings, error)
+		SaveSettings(settings *structs.UserSettings) error
+		DeleteSettings(userID int) error
+	}
+
+	// SiteRepository keeps the MetOffice sites, that are imported by the first migration
+	SiteRepository interface {
+		Get(id string) (structs.SiteLocation, error)
+		GetMany(ids []string) ([]structs.SiteLocation, error)
+		All() ([]structs.SiteLocation, error)
+	}
+
+	// AuditRepository keeps the log of actions with users data, such as deleting all of it
+	AuditRepository interface {
+		Record(entry *structs.AuditEntry) error
+
+		// FindByAction returns the entries of the action, the oldest first
+		FindByAction(action string) ([]structs.AuditEntry, error)
+	}
+
+	// EventRepository keeps the append-only log of events; events are never changed, only removed when they
+	// are too old or when the user asks to forget everything
+	EventRepository interface {
+
+		// Record saves a new event and sets its ID
+		Record(event *structs.Event) error
+
+		// RecordAll saves new events in one transaction and sets their IDs
+		RecordAll(events []structs.Event) error
+
+		// Find returns the events matching the filter, the newest first
+		Find(filter EventFilter) ([]structs.Event, error)
+
+		// Compact removes the old events and records the summaries of them instead, in one transaction
+		Compact(old, summaries []structs.Event) error
+
+		// DeleteBefore removes the events older than the time and returns how many were removed
+		DeleteBefore(t time.Time) (int, error)
+
+		// DeleteForUser removes all the events of the user and returns how many were removed
+		DeleteForUser(userID int) (int, error)
+	}
+
+	// EventFilter selects events, zero fields match everything
+	EventFilter struct {
+		Type   string
+		UserID int
+		Since  time.Time // not older than
+		Before time.Time // older than
+		Limit  int
+	}
+
+	// Repositories are all the repositories used by handlers
+	Repositories struct {
+		Bookmarks BookmarkRepository
+		States    UserStateRepository
+		Sites     SiteRepository
+		Audit     AuditRepository
+		Events    EventRepository
+	}
+)
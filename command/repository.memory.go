@@ -0,0 +1,441 @@
+package command
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+type (
+	// keeps everything in maps, for tests and tools; the behaviour is the same as of the storm repositories
+	memoryBookmarkRepository struct {
+		mu        sync.RWMutex
+		lastID    int
+		bookmarks map[int]structs.UsersLocationBookmark
+	}
+
+	memoryUserStateRepository struct {
+		mu             sync.RWMutex
+		lastStateID    int
+		lastSettingsID int
+		states         map[int]structs.UserState    // by user ID
+		settings       map[int]structs.UserSettings // by user ID
+	}
+
+	memorySiteRepository struct {
+		mu    sync.RWMutex
+		sites map[string]structs.SiteLocation
+	}
+
+	memoryAuditRepository struct {
+		mu      sync.RWMutex
+		entries []structs.AuditEntry
+	}
+
+	memoryEventRepository struct {
+		mu     sync.RWMutex
+		lastID int
+		events []structs.Event // the oldest first
+	}
+)
+
+// NewMemoryRepositories returns repositories that keep everything in memory, the sites are added as they are
+func NewMemoryRepositories(sites ...structs.SiteLocation) Repositories {
+	siteRepository := &memorySiteRepository{sites: make(map[string]structs.SiteLocation)}
+	for _, site := range sites {
+		siteRepository.sites[site.ID] = site
+	}
+
+	return Repositories{
+		Bookmarks: &memoryBookmarkRepository{bookmarks: make(map[int]structs.UsersLocationBookmark)},
+		States: &memoryUserStateRepository{
+			states:   make(map[int]structs.UserState),
+			settings: make(map[int]structs.UserSettings),
+		},
+		Sites:  siteRepository,
+		Audit:  &memoryAuditRepository{},
+		Events: &memoryEventRepository{},
+	}
+}
+
+func (r *memoryBookmarkRepository) Save(bookmark *structs.UsersLocationBookmark) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if bookmark.ID == 0 {
+		r.lastID++
+		bookmark.ID = r.lastID
+	}
+	r.bookmarks[bookmark.ID] = copyBookmark(*bookmark)
+	return nil
+}
+
+func (r *memoryBookmarkRepository) UpdateField(bookmark *structs.UsersLocationBookmark, field string, value interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.bookmarks[bookmark.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if err := setField(&saved, field, value); err != nil {
+		return err
+	}
+	r.bookmarks[bookmark.ID] = copyBookmark(saved)
+	return nil
+}
+
+func (r *memoryBookmarkRepository) Get(id int) (structs.UsersLocationBookmark, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	bookmark, ok := r.bookmarks[id]
+	if !ok {
+		return structs.UsersLocationBookmark{}, ErrNotFound
+	}
+	return copyBookmark(bookmark), nil
+}
+
+func (r *memoryBookmarkRepository) Delete(bookmark *structs.UsersLocationBookmark) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.bookmarks[bookmark.ID]; !ok {
+		return ErrNotFound
+	}
+	delete(r.bookmarks, bookmark.ID)
+	return nil
+}
+
+func (r *memoryBookmarkRepository) FindByUser(userID int) ([]structs.UsersLocationBookmark, error) {
+	return r.find(func(b structs.UsersLocationBookmark) bool { return b.UserID == userID }), nil
+}
+
+func (r *memoryBookmarkRepository) FindReady(userID int) ([]structs.UsersLocationBookmark, error) {
+	return r.find(func(b structs.UsersLocationBookmark) bool { return b.IsReady && (userID == -1 || b.UserID == userID) }), nil
+}
+
+func (r *memoryBookmarkRepository) FindUnfinished(userID int) (structs.UsersLocationBookmark, error) {
+	found := r.find(func(b structs.UsersLocationBookmark) bool { return !b.IsReady && b.UserID == userID })
+	if len(found) == 0 {
+		return structs.UsersLocationBookmark{}, ErrNotFound
+	}
+	return found[0], nil
+}
+
+func (r *memoryBookmarkRepository) FindAllUnfinished() ([]structs.UsersLocationBookmark, error) {
+	return r.find(func(b structs.UsersLocationBookmark) bool { return !b.IsReady }), nil
+}
+
+func (r *memoryBookmarkRepository) DeleteUnfinished(userID int) error {
+	r.delete(func(b structs.UsersLocationBookmark) bool { return !b.IsReady && b.UserID == userID })
+	return nil
+}
+
+func (r *memoryBookmarkRepository) DeleteAllForUser(userID int) error {
+	r.delete(func(b structs.UsersLocationBookmark) bool { return b.UserID == userID })
+	return nil
+}
+
+// returns the matching bookmarks sorted by ID, like storm does
+func (r *memoryBookmarkRepository) find(matches func(b structs.UsersLocationBookmark) bool) []structs.UsersLocationBookmark {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found []structs.UsersLocationBookmark
+	for _, bookmark := range r.bookmarks {
+		if matches(bookmark) {
+			found = append(found, copyBookmark(bookmark))
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].ID < found[j].ID
+	})
+	return found
+}
+
+func (r *memoryBookmarkRepository) delete(matches func(b structs.UsersLocationBookmark) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, bookmark := range r.bookmarks {
+		if matches(bookmark) {
+			delete(r.bookmarks, id)
+		}
+	}
+}
+
+func (r *memoryUserStateRepository) GetState(userID int) (structs.UserState, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	state, ok := r.states[userID]
+	if !ok {
+		return structs.UserState{}, ErrNotFound
+	}
+	return state, nil
+}
+
+func (r *memoryUserStateRepository) SaveState(state *structs.UserState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if saved, ok := r.states[state.UserID]; ok && saved.ID != state.ID {
+		return ErrAlreadyExists
+	}
+
+	if state.ID == 0 {
+		r.lastStateID++
+		state.ID = r.lastStateID
+	}
+	state.UpdatedAt = time.Now().UTC()
+	r.states[state.UserID] = *state
+	return nil
+}
+
+func (r *memoryUserStateRepository) UpdateCurrentState(userID int, newState int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.states[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	state.CurrentState = newState
+	state.UpdatedAt = time.Now().UTC()
+	r.states[userID] = state
+	return nil
+}
+
+// returns the idle states sorted by ID, like storm does
+func (r *memoryUserStateRepository) FindIdle(before time.Time) ([]structs.UserState, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found []structs.UserState
+	for _, state := range r.states {
+		if state.UpdatedAt.Before(before) {
+			found = append(found, state)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+	return found, nil
+}
+
+func (r *memoryUserStateRepository) DeleteState(userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.states[userID]; !ok {
+		return ErrNotFound
+	}
+	delete(r.states, userID)
+	return nil
+}
+
+func (r *memoryUserStateRepository) GetSettings(userID int) (structs.UserSettings, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	settings, ok := r.settings[userID]
+	if !ok {
+		return structs.UserSettings{}, ErrNotFound
+	}
+	return settings, nil
+}
+
+func (r *memoryUserStateRepository) SaveSettings(settings *structs.UserSettings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if saved, ok := r.settings[settings.UserID]; ok && saved.ID != settings.ID {
+		return ErrAlreadyExists
+	}
+
+	if settings.ID == 0 {
+		r.lastSettingsID++
+		settings.ID = r.lastSettingsID
+	}
+	r.settings[settings.UserID] = *settings
+	return nil
+}
+
+func (r *memoryUserStateRepository) DeleteSettings(userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.settings[userID]; !ok {
+		return ErrNotFound
+	}
+	delete(r.settings, userID)
+	return nil
+}
+
+func (r *memorySiteRepository) Get(id string) (structs.SiteLocation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	site, ok := r.sites[id]
+	if !ok {
+		return structs.SiteLocation{}, ErrNotFound
+	}
+	return site, nil
+}
+
+func (r *memorySiteRepository) GetMany(ids []string) ([]structs.SiteLocation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var sites []structs.SiteLocation
+	for _, id := range ids {
+		if site, ok := r.sites[id]; ok {
+			sites = append(sites, site)
+		}
+	}
+	return sites, nil
+}
+
+// returns the sites sorted by ID, like storm does
+func (r *memorySiteRepository) All() ([]structs.SiteLocation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sites := make([]structs.SiteLocation, 0, len(r.sites))
+	for _, site := range r.sites {
+		sites = append(sites, site)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].ID < sites[j].ID
+	})
+	return sites, nil
+}
+
+func (r *memoryAuditRepository) Record(entry *structs.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry.ID = len(r.entries) + 1
+	r.entries = append(r.entries, *entry)
+	return nil
+}
+
+func (r *memoryAuditRepository) FindByAction(action string) ([]structs.AuditEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var entries []structs.AuditEntry
+	for _, entry := range r.entries {
+		if entry.Action == action {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (r *memoryEventRepository) Record(event *structs.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastID++
+	event.ID = r.lastID
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *memoryEventRepository) RecordAll(events []structs.Event) error {
+	for i := range events {
+		if err := r.Record(&events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memoryEventRepository) Find(filter EventFilter) ([]structs.Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var events []structs.Event
+	for i := len(r.events) - 1; i >= 0 && (filter.Limit <= 0 || len(events) < filter.Limit); i-- {
+		event := r.events[i]
+		if (len(filter.Type) == 0 || event.Type == filter.Type) &&
+			(filter.UserID == 0 || event.UserID == filter.UserID) &&
+			!event.Time.Before(filter.Since) &&
+			(filter.Before.IsZero() || event.Time.Before(filter.Before)) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (r *memoryEventRepository) Compact(old, summaries []structs.Event) error {
+	removed := make(map[int]bool, len(old))
+	for _, event := range old {
+		removed[event.ID] = true
+	}
+	r.delete(func(e structs.Event) bool { return removed[e.ID] })
+	return r.RecordAll(summaries)
+}
+
+func (r *memoryEventRepository) DeleteBefore(t time.Time) (int, error) {
+	return r.delete(func(e structs.Event) bool { return e.Time.Before(t) }), nil
+}
+
+func (r *memoryEventRepository) DeleteForUser(userID int) (int, error) {
+	return r.delete(func(e structs.Event) bool { return e.UserID == userID }), nil
+}
+
+func (r *memoryEventRepository) delete(matches func(e structs.Event) bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.events[:0]
+	for _, event := range r.events {
+		if !matches(event) {
+			kept = append(kept, event)
+		}
+	}
+	deleted := len(r.events) - len(kept)
+	r.events = kept
+	return deleted
+}
+
+// bookmarks have slices and pointers, so the saved ones should not share them with the callers
+func copyBookmark(bookmark structs.UsersLocationBookmark) structs.UsersLocationBookmark {
+	bookmark.MaxGust = copyOptional(bookmark.MaxGust)
+	bookmark.MaxTemp = copyOptional(bookmark.MaxTemp)
+	bookmark.MaxHumidity = copyOptional(bookmark.MaxHumidity)
+	bookmark.MaxUV = copyOptional(bookmark.MaxUV)
+	bookmark.UVWarnLevel = copyOptional(bookmark.UVWarnLevel)
+	bookmark.MinNightTemp = copyOptional(bookmark.MinNightTemp)
+	bookmark.MaxPrecipProb = copyOptional(bookmark.MaxPrecipProb)
+	if bookmark.GroupLocationIDs != nil {
+		bookmark.GroupLocationIDs = append([]string{}, bookmark.GroupLocationIDs...)
+	}
+	return bookmark
+}
+
+// sets the field by its name, the same way as storm.UpdateField does
+func setField(bookmark *structs.UsersLocationBookmark, field string, value interface{}) error {
+	fieldValue := reflect.ValueOf(bookmark).Elem().FieldByName(field)
+	if !fieldValue.IsValid() {
+		return fmt.Errorf("no such field '%s'", field)
+	}
+
+	newValue := reflect.ValueOf(value)
+	if !newValue.IsValid() {
+		fieldValue.Set(reflect.Zero(fieldValue.Type()))
+		return nil
+	}
+	if newValue.Type() != fieldValue.Type() {
+		return fmt.Errorf("incompatible value for the field '%s'", field)
+	}
+
+	fieldValue.Set(newValue)
+	return nil
+}
@@ -0,0 +1,675 @@
+package command
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/pkg/errors"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type (
+	sqliteBookmarkRepository  struct{ db *sql.DB }
+	sqliteUserStateRepository struct{ db *sql.DB }
+	sqliteSiteRepository      struct{ db *sql.DB }
+	sqliteAuditRepository     struct{ db *sql.DB }
+	sqliteEventRepository     struct{ db *sql.DB }
+
+	// both *sql.DB and *sql.Tx, so the queries can run inside a transaction too
+	sqlQuerier interface {
+		Exec(query string, args ...interface{}) (sql.Result, error)
+		Query(query string, args ...interface{}) (*sql.Rows, error)
+		QueryRow(query string, args ...interface{}) *sql.Row
+	}
+
+	// anything with the Scan method, one row of either *sql.Row or *sql.Rows
+	sqlScanner interface {
+		Scan(dest ...interface{}) error
+	}
+)
+
+// the schema changes in the order they are applied, the version of the last applied one is kept in "PRAGMA user_version";
+// never change or remove the ones that are released already. Data migrations of the storm database are not repeated
+// here, because the tables are created for the current structs and the data is copied from the migrated database
+var sqliteMigrations = []string{
+	`CREATE TABLE sites (
+		id            TEXT PRIMARY KEY,
+		elevation     TEXT NOT NULL,
+		latitude      TEXT NOT NULL,
+		longitude     TEXT NOT NULL,
+		name          TEXT NOT NULL,
+		region        TEXT NOT NULL,
+		auth_area     TEXT NOT NULL,
+		national_park TEXT NOT NULL,
+		obs_source    TEXT NOT NULL
+	);
+
+	CREATE TABLE bookmarks (
+		id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+		location_id        TEXT NOT NULL,
+		user_id            INTEGER NOT NULL,
+		user_name          TEXT NOT NULL,
+		chat_id            INTEGER NOT NULL,
+		max_wind_speed     INTEGER NOT NULL,
+		lowest_temp        INTEGER NOT NULL,
+		is_ready           BOOLEAN NOT NULL,
+		check_period       INTEGER NOT NULL,
+		excluded_weather   INTEGER NOT NULL,
+		wind_directions    INTEGER NOT NULL,
+		max_gust           INTEGER,
+		max_temp           INTEGER,
+		max_humidity       INTEGER,
+		max_uv             INTEGER,
+		max_precip_prob    INTEGER,
+		uv_warn_level      INTEGER,
+		min_visibility     INTEGER NOT NULL,
+		mode               INTEGER NOT NULL,
+		min_night_temp     INTEGER,
+		golden_hour_only   BOOLEAN NOT NULL,
+		min_run_length     INTEGER NOT NULL,
+		group_name         TEXT NOT NULL,
+		group_location_ids TEXT, -- JSON array of site IDs, NULL for single sites
+		polarity           INTEGER NOT NULL,
+		hazards            INTEGER NOT NULL,
+		profile            TEXT NOT NULL
+	);
+	CREATE INDEX bookmarks_user_id ON bookmarks (user_id, is_ready);
+	CREATE INDEX bookmarks_location_id ON bookmarks (location_id);
+
+	CREATE TABLE user_states (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id       INTEGER NOT NULL UNIQUE,
+		current_state INTEGER NOT NULL,
+		bookmark_id   INTEGER NOT NULL
+	);
+
+	CREATE TABLE user_settings (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id      INTEGER NOT NULL UNIQUE,
+		digest_group INTEGER NOT NULL
+	);
+
+	CREATE TABLE audit (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		action    TEXT NOT NULL,
+		time      TEXT NOT NULL, -- UTC, RFC 3339
+		bookmarks INTEGER NOT NULL,
+		states    INTEGER NOT NULL,
+		settings  INTEGER NOT NULL
+	);
+	CREATE INDEX audit_action ON audit (action);`,
+
+	`ALTER TABLE audit ADD COLUMN events INTEGER NOT NULL DEFAULT 0;
+
+	CREATE TABLE events (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		type        TEXT NOT NULL,
+		time        TEXT NOT NULL, -- UTC, sqliteTimeLayout
+		user_id     INTEGER NOT NULL,
+		bookmark_id INTEGER NOT NULL,
+		location_id TEXT NOT NULL,
+		details     TEXT NOT NULL
+	);
+	CREATE INDEX events_type ON events (type);
+	CREATE INDEX events_time ON events (time);
+	CREATE INDEX events_user_id ON events (user_id);`,
+
+	// the states that exist already get the full idle time before the janitor expires them
+	`ALTER TABLE user_states ADD COLUMN updated_at TEXT NOT NULL DEFAULT '';
+	UPDATE user_states SET updated_at = strftime('%Y-%m-%dT%H:%M:%S.000000000Z', 'now');
+	CREATE INDEX user_states_updated_at ON user_states (updated_at);`,
+}
+
+const (
+	bookmarkColumns = `id, location_id, user_id, user_name, chat_id, max_wind_speed, lowest_temp, is_ready, check_period,
+		excluded_weather, wind_directions, max_gust, max_temp, max_humidity, max_uv, max_precip_prob, uv_warn_level,
+		min_visibility, mode, min_night_temp, golden_hour_only, min_run_length, group_name, group_location_ids,
+		polarity, hazards, profile`
+	siteColumns     = "id, elevation, latitude, longitude, name, region, auth_area, national_park, obs_source"
+	stateColumns    = "id, user_id, current_state, bookmark_id, updated_at"
+	settingsColumns = "id, user_id, digest_group"
+	auditColumns    = "id, action, time, bookmarks, states, settings, events"
+	eventColumns    = "id, type, time, user_id, bookmark_id, location_id, details"
+
+	// times are saved as text of the same length, so they can be compared as strings
+	sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
+// OpenSQLite opens the SQLite database and creates or updates its tables. The driver is pure Go, so the bot
+// is still built without CGO
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+
+	// all the queries go one by one, so UpdateField reads and writes the bookmark without races
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("the SQLite schema version %d is newer than the latest known one %d, please update the bot", version, len(sqliteMigrations))
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		err := withSQLiteTx(db, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+				return err
+			}
+
+			// pragmas can't have parameters
+			_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1))
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "SQLite migration %d failed", version+1)
+		}
+	}
+	return nil
+}
+
+// NewSQLiteRepositories returns repositories that keep everything in the SQLite database
+func NewSQLiteRepositories(db *sql.DB) Repositories {
+	return Repositories{
+		Bookmarks: sqliteBookmarkRepository{db: db},
+		States:    sqliteUserStateRepository{db: db},
+		Sites:     sqliteSiteRepository{db: db},
+		Audit:     sqliteAuditRepository{db: db},
+		Events:    sqliteEventRepository{db: db},
+	}
+}
+
+// commits if fn succeeds, rolls back otherwise
+func withSQLiteTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// converts SQLite errors to the errors of repositories
+func sqliteError(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if sqliteErr, ok := err.(*sqlite.Error); ok && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// ErrNotFound if nothing was changed
+func sqliteAffected(result sql.Result, err error) error {
+	if err != nil {
+		return sqliteError(err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// "?, ?, ?" for n values
+func sqlPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// inserts the row, or replaces the one with the same ID; zero ID means the new row, then the new ID is returned
+func sqliteUpsert(db sqlQuerier, table, columns string, values ...interface{}) (int, error) {
+	names := strings.Split(columns, ",")
+	updates := make([]string, 0, len(names)-1)
+	for _, name := range names[1:] {
+		name = strings.TrimSpace(name)
+		updates = append(updates, name+" = excluded."+name)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (NULLIF(?, 0), %s) ON CONFLICT (id) DO UPDATE SET %s",
+		table, columns, sqlPlaceholders(len(values)-1), strings.Join(updates, ", "))
+	result, err := db.Exec(query, values...)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+
+	if id, ok := values[0].(int); ok && id != 0 {
+		return id, nil
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func saveSQLiteBookmark(db sqlQuerier, bookmark *structs.UsersLocationBookmark) error {
+	var groupLocationIDs interface{}
+	if bookmark.GroupLocationIDs != nil {
+		bytes, err := json.Marshal(bookmark.GroupLocationIDs)
+		if err != nil {
+			return err
+		}
+		groupLocationIDs = string(bytes)
+	}
+
+	id, err := sqliteUpsert(db, "bookmarks", bookmarkColumns,
+		bookmark.ID, bookmark.LocationID, bookmark.UserID, bookmark.UserName, bookmark.ChatID, bookmark.MaxWindSpeed,
+		bookmark.LowestTemp, bookmark.IsReady, bookmark.CheckPeriod, bookmark.ExcludedWeather, bookmark.WindDirections,
+		bookmark.MaxGust, bookmark.MaxTemp, bookmark.MaxHumidity, bookmark.MaxUV, bookmark.MaxPrecipProb, bookmark.UVWarnLevel,
+		bookmark.MinVisibility, bookmark.Mode, bookmark.MinNightTemp, bookmark.GoldenHourOnly, bookmark.MinRunLength,
+		bookmark.GroupName, groupLocationIDs, bookmark.Polarity, bookmark.Hazards, bookmark.Profile)
+	if err != nil {
+		return err
+	}
+	bookmark.ID = id
+	return nil
+}
+
+func scanSQLiteBookmark(row sqlScanner) (structs.UsersLocationBookmark, error) {
+	var bookmark structs.UsersLocationBookmark
+	var groupLocationIDs sql.NullString
+	err := row.Scan(
+		&bookmark.ID, &bookmark.LocationID, &bookmark.UserID, &bookmark.UserName, &bookmark.ChatID, &bookmark.MaxWindSpeed,
+		&bookmark.LowestTemp, &bookmark.IsReady, &bookmark.CheckPeriod, &bookmark.ExcludedWeather, &bookmark.WindDirections,
+		&bookmark.MaxGust, &bookmark.MaxTemp, &bookmark.MaxHumidity, &bookmark.MaxUV, &bookmark.MaxPrecipProb, &bookmark.UVWarnLevel,
+		&bookmark.MinVisibility, &bookmark.Mode, &bookmark.MinNightTemp, &bookmark.GoldenHourOnly, &bookmark.MinRunLength,
+		&bookmark.GroupName, &groupLocationIDs, &bookmark.Polarity, &bookmark.Hazards, &bookmark.Profile)
+	if err != nil {
+		return bookmark, sqliteError(err)
+	}
+
+	if groupLocationIDs.Valid {
+		err = json.Unmarshal([]byte(groupLocationIDs.String), &bookmark.GroupLocationIDs)
+	}
+	return bookmark, err
+}
+
+func getSQLiteBookmark(db sqlQuerier, id int) (structs.UsersLocationBookmark, error) {
+	return scanSQLiteBookmark(db.QueryRow("SELECT "+bookmarkColumns+" FROM bookmarks WHERE id = ?", id))
+}
+
+func findSQLiteBookmarks(db sqlQuerier, where string, args ...interface{}) ([]structs.UsersLocationBookmark, error) {
+	rows, err := db.Query("SELECT "+bookmarkColumns+" FROM bookmarks WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bookmarks []structs.UsersLocationBookmark
+	for rows.Next() {
+		bookmark, err := scanSQLiteBookmark(rows)
+		if err != nil {
+			return nil, err
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+	return bookmarks, rows.Err()
+}
+
+func (r sqliteBookmarkRepository) Save(bookmark *structs.UsersLocationBookmark) error {
+	return saveSQLiteBookmark(r.db, bookmark)
+}
+
+func (r sqliteBookmarkRepository) UpdateField(bookmark *structs.UsersLocationBookmark, field string, value interface{}) error {
+	return withSQLiteTx(r.db, func(tx *sql.Tx) error {
+		saved, err := getSQLiteBookmark(tx, bookmark.ID)
+		if err != nil {
+			return err
+		}
+		if err := setField(&saved, field, value); err != nil {
+			return err
+		}
+		return saveSQLiteBookmark(tx, &saved)
+	})
+}
+
+func (r sqliteBookmarkRepository) Get(id int) (structs.UsersLocationBookmark, error) {
+	return getSQLiteBookmark(r.db, id)
+}
+
+func (r sqliteBookmarkRepository) Delete(bookmark *structs.UsersLocationBookmark) error {
+	return sqliteAffected(r.db.Exec("DELETE FROM bookmarks WHERE id = ?", bookmark.ID))
+}
+
+func (r sqliteBookmarkRepository) FindByUser(userID int) ([]structs.UsersLocationBookmark, error) {
+	return findSQLiteBookmarks(r.db, "user_id = ?", userID)
+}
+
+func (r sqliteBookmarkRepository) FindReady(userID int) ([]structs.UsersLocationBookmark, error) {
+	if userID == -1 {
+		return findSQLiteBookmarks(r.db, "is_ready")
+	}
+	return findSQLiteBookmarks(r.db, "user_id = ? AND is_ready", userID)
+}
+
+func (r sqliteBookmarkRepository) FindUnfinished(userID int) (structs.UsersLocationBookmark, error) {
+	return scanSQLiteBookmark(r.db.QueryRow(
+		"SELECT "+bookmarkColumns+" FROM bookmarks WHERE user_id = ? AND NOT is_ready ORDER BY id LIMIT 1", userID))
+}
+
+func (r sqliteBookmarkRepository) FindAllUnfinished() ([]structs.UsersLocationBookmark, error) {
+	return findSQLiteBookmarks(r.db, "NOT is_ready")
+}
+
+func (r sqliteBookmarkRepository) DeleteUnfinished(userID int) error {
+	_, err := r.db.Exec("DELETE FROM bookmarks WHERE user_id = ? AND NOT is_ready", userID)
+	return err
+}
+
+func (r sqliteBookmarkRepository) DeleteAllForUser(userID int) error {
+	_, err := r.db.Exec("DELETE FROM bookmarks WHERE user_id = ?", userID)
+	return err
+}
+
+func (r sqliteUserStateRepository) GetState(userID int) (structs.UserState, error) {
+	return scanSQLiteState(r.db.QueryRow("SELECT "+stateColumns+" FROM user_states WHERE user_id = ?", userID))
+}
+
+func (r sqliteUserStateRepository) SaveState(state *structs.UserState) error {
+	updatedAt := time.Now().UTC()
+	id, err := sqliteUpsert(r.db, "user_states", stateColumns, state.ID, state.UserID, state.CurrentState, state.BookmarkID,
+		updatedAt.Format(sqliteTimeLayout))
+	if err != nil {
+		return err
+	}
+	state.ID, state.UpdatedAt = id, updatedAt
+	return nil
+}
+
+func (r sqliteUserStateRepository) UpdateCurrentState(userID int, newState int) error {
+	return sqliteAffected(r.db.Exec("UPDATE user_states SET current_state = ?, updated_at = ? WHERE user_id = ?",
+		newState, time.Now().UTC().Format(sqliteTimeLayout), userID))
+}
+
+func (r sqliteUserStateRepository) FindIdle(before time.Time) ([]structs.UserState, error) {
+	return findSQLiteStates(r.db, "updated_at < ?", before.UTC().Format(sqliteTimeLayout))
+}
+
+func findSQLiteStates(db sqlQuerier, where string, args ...interface{}) ([]structs.UserState, error) {
+	rows, err := db.Query("SELECT "+stateColumns+" FROM user_states WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []structs.UserState
+	for rows.Next() {
+		state, err := scanSQLiteState(rows)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+func scanSQLiteState(row sqlScanner) (structs.UserState, error) {
+	var state structs.UserState
+	var updatedAt string
+	if err := row.Scan(&state.ID, &state.UserID, &state.CurrentState, &state.BookmarkID, &updatedAt); err != nil {
+		return state, sqliteError(err)
+	}
+
+	var err error
+	state.UpdatedAt, err = time.Parse(time.RFC3339Nano, updatedAt)
+	return state, err
+}
+
+func (r sqliteUserStateRepository) DeleteState(userID int) error {
+	return sqliteAffected(r.db.Exec("DELETE FROM user_states WHERE user_id = ?", userID))
+}
+
+func (r sqliteUserStateRepository) GetSettings(userID int) (structs.UserSettings, error) {
+	var settings structs.UserSettings
+	err := r.db.QueryRow("SELECT "+settingsColumns+" FROM user_settings WHERE user_id = ?", userID).
+		Scan(&settings.ID, &settings.UserID, &settings.DigestGroup)
+	return settings, sqliteError(err)
+}
+
+func (r sqliteUserStateRepository) SaveSettings(settings *structs.UserSettings) error {
+	id, err := sqliteUpsert(r.db, "user_settings", settingsColumns, settings.ID, settings.UserID, settings.DigestGroup)
+	if err != nil {
+		return err
+	}
+	settings.ID = id
+	return nil
+}
+
+func (r sqliteUserStateRepository) DeleteSettings(userID int) error {
+	return sqliteAffected(r.db.Exec("DELETE FROM user_settings WHERE user_id = ?", userID))
+}
+
+// saveSQLiteSites inserts the sites, or replaces the ones with the same IDs
+func saveSQLiteSites(db *sql.DB, sites []structs.SiteLocation) error {
+	return withSQLiteTx(db, func(tx *sql.Tx) error {
+		statement, err := tx.Prepare("INSERT OR REPLACE INTO sites (" + siteColumns + ") VALUES (" + sqlPlaceholders(9) + ")")
+		if err != nil {
+			return err
+		}
+		defer statement.Close()
+
+		for _, site := range sites {
+			_, err := statement.Exec(site.ID, site.Elevation, site.Latitude, site.Longitude, site.Name, site.Region,
+				site.AuthArea, site.NationalPark, site.ObsSource)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func findSQLiteSites(db *sql.DB, query string, args ...interface{}) ([]structs.SiteLocation, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sites []structs.SiteLocation
+	for rows.Next() {
+		var site structs.SiteLocation
+		err := rows.Scan(&site.ID, &site.Elevation, &site.Latitude, &site.Longitude, &site.Name, &site.Region,
+			&site.AuthArea, &site.NationalPark, &site.ObsSource)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, rows.Err()
+}
+
+func (r sqliteSiteRepository) Get(id string) (structs.SiteLocation, error) {
+	sites, err := findSQLiteSites(r.db, "SELECT "+siteColumns+" FROM sites WHERE id = ?", id)
+	if err != nil {
+		return structs.SiteLocation{}, err
+	}
+	if len(sites) == 0 {
+		return structs.SiteLocation{}, ErrNotFound
+	}
+	return sites[0], nil
+}
+
+func (r sqliteSiteRepository) GetMany(ids []string) ([]structs.SiteLocation, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return findSQLiteSites(r.db, "SELECT "+siteColumns+" FROM sites WHERE id IN ("+sqlPlaceholders(len(ids))+") ORDER BY id", args...)
+}
+
+func (r sqliteSiteRepository) All() ([]structs.SiteLocation, error) {
+	return findSQLiteSites(r.db, "SELECT "+siteColumns+" FROM sites ORDER BY id")
+}
+
+func (r sqliteAuditRepository) Record(entry *structs.AuditEntry) error {
+	id, err := sqliteUpsert(r.db, "audit", auditColumns, entry.ID, entry.Action, entry.Time.UTC().Format(sqliteTimeLayout),
+		entry.Bookmarks, entry.States, entry.Settings, entry.Events)
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+	return nil
+}
+
+func (r sqliteAuditRepository) FindByAction(action string) ([]structs.AuditEntry, error) {
+	return findSQLiteAudit(r.db, "action = ?", action)
+}
+
+func findSQLiteAudit(db sqlQuerier, where string, args ...interface{}) ([]structs.AuditEntry, error) {
+	rows, err := db.Query("SELECT "+auditColumns+" FROM audit WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []structs.AuditEntry
+	for rows.Next() {
+		var entry structs.AuditEntry
+		var strTime string
+		if err := rows.Scan(&entry.ID, &entry.Action, &strTime, &entry.Bookmarks, &entry.States, &entry.Settings, &entry.Events); err != nil {
+			return nil, err
+		}
+		if entry.Time, err = time.Parse(time.RFC3339Nano, strTime); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func saveSQLiteEvent(db sqlQuerier, event *structs.Event) error {
+	id, err := sqliteUpsert(db, "events", eventColumns, event.ID, event.Type, event.Time.UTC().Format(sqliteTimeLayout),
+		event.UserID, event.BookmarkID, event.LocationID, event.Details)
+	if err != nil {
+		return err
+	}
+	event.ID = id
+	return nil
+}
+
+func (r sqliteEventRepository) Record(event *structs.Event) error {
+	event.ID = 0
+	return saveSQLiteEvent(r.db, event)
+}
+
+func (r sqliteEventRepository) RecordAll(events []structs.Event) error {
+	return withSQLiteTx(r.db, func(tx *sql.Tx) error {
+		for i := range events {
+			events[i].ID = 0
+			if err := saveSQLiteEvent(tx, &events[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r sqliteEventRepository) Find(filter EventFilter) ([]structs.Event, error) {
+	var where []string
+	var args []interface{}
+	if len(filter.Type) > 0 {
+		where, args = append(where, "type = ?"), append(args, filter.Type)
+	}
+	if filter.UserID != 0 {
+		where, args = append(where, "user_id = ?"), append(args, filter.UserID)
+	}
+	if !filter.Since.IsZero() {
+		where, args = append(where, "time >= ?"), append(args, filter.Since.UTC().Format(sqliteTimeLayout))
+	}
+	if !filter.Before.IsZero() {
+		where, args = append(where, "time < ?"), append(args, filter.Before.UTC().Format(sqliteTimeLayout))
+	}
+
+	query := "SELECT " + eventColumns + " FROM events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(filter.Limit)
+	}
+
+	return findSQLiteEvents(r.db, query, args...)
+}
+
+func findSQLiteEvents(db sqlQuerier, query string, args ...interface{}) ([]structs.Event, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []structs.Event
+	for rows.Next() {
+		var event structs.Event
+		var strTime string
+		err := rows.Scan(&event.ID, &event.Type, &strTime, &event.UserID, &event.BookmarkID, &event.LocationID, &event.Details)
+		if err != nil {
+			return nil, err
+		}
+		if event.Time, err = time.Parse(time.RFC3339Nano, strTime); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (r sqliteEventRepository) Compact(old, summaries []structs.Event) error {
+	return withSQLiteTx(r.db, func(tx *sql.Tx) error {
+		for _, event := range old {
+			if _, err := tx.Exec("DELETE FROM events WHERE id = ?", event.ID); err != nil {
+				return err
+			}
+		}
+		for i := range summaries {
+			summaries[i].ID = 0
+			if err := saveSQLiteEvent(tx, &summaries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r sqliteEventRepository) DeleteBefore(t time.Time) (int, error) {
+	return sqliteDeleted(r.db.Exec("DELETE FROM events WHERE time < ?", t.UTC().Format(sqliteTimeLayout)))
+}
+
+func (r sqliteEventRepository) DeleteForUser(userID int) (int, error) {
+	return sqliteDeleted(r.db.Exec("DELETE FROM events WHERE user_id = ?", userID))
+}
+
+func sqliteDeleted(result sql.Result, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
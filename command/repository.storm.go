@@ -0,0 +1,279 @@
+package command
+
+import (
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+type (
+	stormBookmarkRepository  struct{ db *storm.DB }
+	stormUserStateRepository struct{ db *storm.DB }
+	stormSiteRepository      struct{ db *storm.DB }
+	stormAuditRepository     struct{ db *storm.DB }
+	stormEventRepository     struct{ db *storm.DB }
+)
+
+// NewStormRepositories returns repositories that keep everything in the storm (bbolt) database
+func NewStormRepositories(db *storm.DB) Repositories {
+	return Repositories{
+		Bookmarks: stormBookmarkRepository{db: db},
+		States:    stormUserStateRepository{db: db},
+		Sites:     stormSiteRepository{db: db},
+		Audit:     stormAuditRepository{db: db},
+		Events:    stormEventRepository{db: db},
+	}
+}
+
+// converts storm errors to the errors of repositories
+func stormError(err error) error {
+	switch err {
+	case storm.ErrNotFound:
+		return ErrNotFound
+	case storm.ErrAlreadyExists:
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// empty result of the search is not an error, unlike storm.Find
+func stormFindError(err error) error {
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	return stormError(err)
+}
+
+func (r stormBookmarkRepository) Save(bookmark *structs.UsersLocationBookmark) error {
+	return stormError(r.db.Save(bookmark))
+}
+
+func (r stormBookmarkRepository) UpdateField(bookmark *structs.UsersLocationBookmark, field string, value interface{}) error {
+	return stormError(r.db.UpdateField(bookmark, field, value))
+}
+
+func (r stormBookmarkRepository) Get(id int) (structs.UsersLocationBookmark, error) {
+	var bookmark structs.UsersLocationBookmark
+	err := r.db.One("ID", id, &bookmark)
+	return bookmark, stormError(err)
+}
+
+func (r stormBookmarkRepository) Delete(bookmark *structs.UsersLocationBookmark) error {
+	return stormError(r.db.DeleteStruct(bookmark))
+}
+
+func (r stormBookmarkRepository) FindByUser(userID int) ([]structs.UsersLocationBookmark, error) {
+	var bookmarks []structs.UsersLocationBookmark
+	err := r.db.Find("UserID", userID, &bookmarks)
+	return bookmarks, stormFindError(err)
+}
+
+func (r stormBookmarkRepository) FindReady(userID int) ([]structs.UsersLocationBookmark, error) {
+	var bookmarks []structs.UsersLocationBookmark
+	var err error
+	if userID == -1 {
+
+		// find all ready bookmarks
+		err = r.db.Find("IsReady", true, &bookmarks)
+	} else {
+
+		// find all the bookrmarks for the given user
+		err = r.db.Select(q.And(
+			q.Eq("UserID", userID),
+			q.Eq("IsReady", true),
+		)).Find(&bookmarks)
+	}
+	return bookmarks, stormFindError(err)
+}
+
+func (r stormBookmarkRepository) FindUnfinished(userID int) (structs.UsersLocationBookmark, error) {
+	var bookmark structs.UsersLocationBookmark
+	err := r.db.Select(q.And(
+		q.Eq("UserID", userID),
+		q.Eq("IsReady", false),
+	)).First(&bookmark)
+	return bookmark, stormError(err)
+}
+
+func (r stormBookmarkRepository) FindAllUnfinished() ([]structs.UsersLocationBookmark, error) {
+	var bookmarks []structs.UsersLocationBookmark
+	err := r.db.Select(q.Eq("IsReady", false)).Find(&bookmarks)
+	return bookmarks, stormFindError(err)
+}
+
+func (r stormBookmarkRepository) DeleteUnfinished(userID int) error {
+	query := r.db.Select(q.Eq("UserID", userID), q.Eq("IsReady", false))
+	return stormFindError(query.Delete(new(structs.UsersLocationBookmark)))
+}
+
+func (r stormBookmarkRepository) DeleteAllForUser(userID int) error {
+	query := r.db.Select(q.Eq("UserID", userID))
+	return stormFindError(query.Delete(new(structs.UsersLocationBookmark)))
+}
+
+func (r stormUserStateRepository) GetState(userID int) (structs.UserState, error) {
+	var state structs.UserState
+	err := r.db.One("UserID", userID, &state)
+	return state, stormError(err)
+}
+
+func (r stormUserStateRepository) SaveState(state *structs.UserState) error {
+	state.UpdatedAt = time.Now().UTC()
+	return stormError(r.db.Save(state))
+}
+
+func (r stormUserStateRepository) UpdateCurrentState(userID int, newState int) error {
+	state, err := r.GetState(userID)
+	if err != nil {
+		return err
+	}
+	state.CurrentState = newState
+	return r.SaveState(&state)
+}
+
+func (r stormUserStateRepository) FindIdle(before time.Time) ([]structs.UserState, error) {
+	var states []structs.UserState
+	err := r.db.Select(q.Lt("UpdatedAt", before)).Find(&states)
+	return states, stormFindError(err)
+}
+
+func (r stormUserStateRepository) DeleteState(userID int) error {
+	state, err := r.GetState(userID)
+	if err != nil {
+		return err
+	}
+	return stormError(r.db.DeleteStruct(&state))
+}
+
+func (r stormUserStateRepository) GetSettings(userID int) (structs.UserSettings, error) {
+	var settings structs.UserSettings
+	err := r.db.One("UserID", userID, &settings)
+	return settings, stormError(err)
+}
+
+func (r stormUserStateRepository) SaveSettings(settings *structs.UserSettings) error {
+	return stormError(r.db.Save(settings))
+}
+
+func (r stormUserStateRepository) DeleteSettings(userID int) error {
+	settings, err := r.GetSettings(userID)
+	if err != nil {
+		return err
+	}
+	return stormError(r.db.DeleteStruct(&settings))
+}
+
+func (r stormSiteRepository) Get(id string) (structs.SiteLocation, error) {
+	var site structs.SiteLocation
+	err := r.db.One("ID", id, &site)
+	return site, stormError(err)
+}
+
+func (r stormSiteRepository) GetMany(ids []string) ([]structs.SiteLocation, error) {
+	var sites []structs.SiteLocation
+	err := r.db.Select(q.In("ID", ids)).Find(&sites)
+	return sites, stormFindError(err)
+}
+
+func (r stormSiteRepository) All() ([]structs.SiteLocation, error) {
+	var sites []structs.SiteLocation
+	err := r.db.All(&sites)
+	return sites, stormError(err)
+}
+
+func (r stormAuditRepository) Record(entry *structs.AuditEntry) error {
+	return stormError(r.db.Save(entry))
+}
+
+func (r stormAuditRepository) FindByAction(action string) ([]structs.AuditEntry, error) {
+	var entries []structs.AuditEntry
+	err := r.db.Find("Action", action, &entries)
+	return entries, stormFindError(err)
+}
+
+func (r stormEventRepository) Record(event *structs.Event) error {
+	event.ID = 0
+	return stormError(r.db.Save(event))
+}
+
+func (r stormEventRepository) RecordAll(events []structs.Event) error {
+	tx, err := r.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range events {
+		events[i].ID = 0
+		if err := tx.Save(&events[i]); err != nil {
+			return stormError(err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (r stormEventRepository) Find(filter EventFilter) ([]structs.Event, error) {
+	var matchers []q.Matcher
+	if len(filter.Type) > 0 {
+		matchers = append(matchers, q.Eq("Type", filter.Type))
+	}
+	if filter.UserID != 0 {
+		matchers = append(matchers, q.Eq("UserID", filter.UserID))
+	}
+	if !filter.Since.IsZero() {
+		matchers = append(matchers, q.Gte("Time", filter.Since))
+	}
+	if !filter.Before.IsZero() {
+		matchers = append(matchers, q.Lt("Time", filter.Before))
+	}
+
+	query := r.db.Select(matchers...).OrderBy("ID").Reverse()
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var events []structs.Event
+	err := query.Find(&events)
+	return events, stormFindError(err)
+}
+
+func (r stormEventRepository) Compact(old, summaries []structs.Event) error {
+	tx, err := r.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range old {
+		if err := tx.DeleteStruct(&old[i]); err != nil {
+			return stormError(err)
+		}
+	}
+	for i := range summaries {
+		summaries[i].ID = 0
+		if err := tx.Save(&summaries[i]); err != nil {
+			return stormError(err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (r stormEventRepository) DeleteBefore(t time.Time) (int, error) {
+	return r.delete(q.Lt("Time", t))
+}
+
+func (r stormEventRepository) DeleteForUser(userID int) (int, error) {
+	return r.delete(q.Eq("UserID", userID))
+}
+
+func (r stormEventRepository) delete(matcher q.Matcher) (int, error) {
+	query := r.db.Select(matcher)
+	count, err := query.Count(new(structs.Event))
+	if err != nil || count == 0 {
+		return 0, stormFindError(err)
+	}
+	return count, stormFindError(query.Delete(new(structs.Event)))
+}
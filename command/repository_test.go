@@ -0,0 +1,386 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+var repositorySites = []structs.SiteLocation{
+	{ID: "1", Name: "Buxton", AuthArea: "Derbyshire", NationalPark: "Peak District National Park"},
+	{ID: "2", Name: "Castleton", AuthArea: "Derbyshire", NationalPark: "Peak District National Park"},
+	{ID: "3", Name: "Keswick", AuthArea: "Cumbria", NationalPark: "Lake District National Park"},
+	{ID: "4", Name: "Upper Derwent", AuthArea: "Derbyshire"},
+}
+
+// both implementations should behave the same, so every test runs against each of them
+func forEachRepositories(t *testing.T, test func(t *testing.T, repos Repositories)) {
+	t.Run("storm", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "storm")
+		defer os.RemoveAll(dir)
+
+		db, err := OpenDB(filepath.Join(dir, "weather.db"))
+		assert.Nil(t, err)
+		defer db.Close()
+
+		for i := range repositorySites {
+			assert.Nil(t, db.Save(&repositorySites[i]))
+		}
+
+		test(t, NewStormRepositories(db))
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "sqlite")
+		defer os.RemoveAll(dir)
+
+		db, err := OpenSQLite(filepath.Join(dir, "weather.sqlite"))
+		assert.Nil(t, err)
+		defer db.Close()
+
+		assert.Nil(t, saveSQLiteSites(db, repositorySites))
+
+		test(t, NewSQLiteRepositories(db))
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryRepositories(repositorySites...))
+	})
+}
+
+func TestBookmarkRepository(t *testing.T) {
+	forEachRepositories(t, func(t *testing.T, repos Repositories) {
+
+		// Given:
+		ready := structs.UsersLocationBookmark{UserID: UserID, LocationID: "1", MaxWindSpeed: 20, IsReady: true}
+		unfinished := structs.UsersLocationBookmark{UserID: UserID, LocationID: "2"}
+		other := structs.UsersLocationBookmark{UserID: User2ID, LocationID: "3", IsReady: true}
+		for _, bookmark := range []*structs.UsersLocationBookmark{&ready, &unfinished, &other} {
+			assert.Nil(t, repos.Bookmarks.Save(bookmark))
+		}
+
+		// Then: IDs are set on save
+		assert.NotEqual(t, 0, ready.ID)
+		assert.NotEqual(t, ready.ID, unfinished.ID)
+
+		saved, err := repos.Bookmarks.Get(ready.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, 20, saved.MaxWindSpeed)
+
+		_, err = repos.Bookmarks.Get(12345)
+		assert.Equal(t, ErrNotFound, err)
+
+		// and the searches
+		all, err := repos.Bookmarks.FindByUser(UserID)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(all))
+
+		readyOfUser, err := repos.Bookmarks.FindReady(UserID)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(readyOfUser))
+		assert.Equal(t, ready.ID, readyOfUser[0].ID)
+
+		readyOfAll, err := repos.Bookmarks.FindReady(-1)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(readyOfAll))
+
+		found, err := repos.Bookmarks.FindUnfinished(UserID)
+		assert.Nil(t, err)
+		assert.Equal(t, unfinished.ID, found.ID)
+
+		_, err = repos.Bookmarks.FindUnfinished(User2ID)
+		assert.Equal(t, ErrNotFound, err)
+
+		unfinishedOfAll, err := repos.Bookmarks.FindAllUnfinished()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(unfinishedOfAll))
+		assert.Equal(t, unfinished.ID, unfinishedOfAll[0].ID)
+
+		nobody, err := repos.Bookmarks.FindByUser(333)
+		assert.Nil(t, err)
+		assert.Empty(t, nobody)
+
+		// When: one field is updated
+		assert.Nil(t, repos.Bookmarks.UpdateField(&ready, "MaxWindSpeed", 25))
+
+		// Then: the saved one is changed, but not the passed struct
+		saved, _ = repos.Bookmarks.Get(ready.ID)
+		assert.Equal(t, 25, saved.MaxWindSpeed)
+		assert.Equal(t, 20, ready.MaxWindSpeed)
+
+		// When: the unfinished ones are removed
+		assert.Nil(t, repos.Bookmarks.DeleteUnfinished(UserID))
+		assert.Nil(t, repos.Bookmarks.DeleteUnfinished(User2ID))
+
+		// Then:
+		all, _ = repos.Bookmarks.FindByUser(UserID)
+		assert.Equal(t, 1, len(all))
+
+		// When: all the bookmarks of the user are removed
+		assert.Nil(t, repos.Bookmarks.DeleteAllForUser(UserID))
+		assert.Nil(t, repos.Bookmarks.DeleteAllForUser(UserID))
+
+		// Then: other users keep theirs
+		all, _ = repos.Bookmarks.FindByUser(UserID)
+		assert.Empty(t, all)
+
+		assert.Nil(t, repos.Bookmarks.Delete(&other))
+		_, err = repos.Bookmarks.Get(other.ID)
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestBookmarkRepositoryKeepsAllFields(t *testing.T) {
+	forEachRepositories(t, func(t *testing.T, repos Repositories) {
+
+		// Given: every field is set
+		gust, temp, humidity, uv, precip, warn, night := 30, 25, 80, 6, 50, 7, -2
+		bookmark := structs.UsersLocationBookmark{
+			LocationID: "1", UserID: UserID, UserName: UserName, ChatID: 555, MaxWindSpeed: 20, LowestTemp: 5,
+			IsReady: true, CheckPeriod: OnlyWeekends, ExcludedWeather: 3, WindDirections: 12,
+			MaxGust: &gust, MaxTemp: &temp, MaxHumidity: &humidity, MaxUV: &uv, MaxPrecipProb: &precip, UVWarnLevel: &warn,
+			MinVisibility: 4, Mode: ModeNight, MinNightTemp: &night, GoldenHourOnly: true, MinRunLength: 2,
+			GroupName: "Peak District", GroupLocationIDs: []string{"1", "2"}, Polarity: 1, Hazards: 5, Profile: "hiking",
+		}
+
+		// When:
+		assert.Nil(t, repos.Bookmarks.Save(&bookmark))
+		saved, err := repos.Bookmarks.Get(bookmark.ID)
+
+		// Then:
+		assert.Nil(t, err)
+		assert.Equal(t, bookmark, saved)
+
+		// and the optional ones can be unset
+		assert.Nil(t, repos.Bookmarks.UpdateField(&bookmark, "MaxGust", (*int)(nil)))
+		assert.Nil(t, repos.Bookmarks.UpdateField(&bookmark, "GroupLocationIDs", []string{"2"}))
+		assert.NotNil(t, repos.Bookmarks.UpdateField(&bookmark, "NoSuchField", 1))
+		assert.NotNil(t, repos.Bookmarks.UpdateField(&bookmark, "MaxWindSpeed", "fast"))
+
+		saved, _ = repos.Bookmarks.Get(bookmark.ID)
+		assert.Nil(t, saved.MaxGust)
+		assert.Equal(t, []string{"2"}, saved.GroupLocationIDs)
+		assert.Equal(t, 20, saved.MaxWindSpeed)
+	})
+}
+
+func TestUserStateRepository(t *testing.T) {
+	forEachRepositories(t, func(t *testing.T, repos Repositories) {
+
+		// Given:
+		_, err := repos.States.GetState(UserID)
+		assert.Equal(t, ErrNotFound, err)
+
+		// When:
+		before := time.Now()
+		assert.Nil(t, repos.States.SaveState(&structs.UserState{UserID: UserID, CurrentState: StepEnterLocation}))
+		assert.Nil(t, repos.States.UpdateCurrentState(UserID, StepEnterMaxWindSpeed))
+
+		// Then:
+		state, err := repos.States.GetState(UserID)
+		assert.Nil(t, err)
+		assert.Equal(t, StepEnterMaxWindSpeed, state.CurrentState)
+		assert.False(t, state.UpdatedAt.Before(before.Add(-time.Second)))
+
+		// and it is idle only when it was not updated since the given time
+		idle, err := repos.States.FindIdle(before.Add(-time.Minute))
+		assert.Nil(t, err)
+		assert.Empty(t, idle)
+
+		idle, err = repos.States.FindIdle(time.Now().Add(time.Minute))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(idle))
+		assert.Equal(t, UserID, idle[0].UserID)
+
+		// and one user can't have two states
+		assert.Equal(t, ErrAlreadyExists, repos.States.SaveState(&structs.UserState{UserID: UserID}))
+
+		// When:
+		assert.Nil(t, repos.States.DeleteState(UserID))
+
+		// Then:
+		_, err = repos.States.GetState(UserID)
+		assert.Equal(t, ErrNotFound, err)
+		assert.Equal(t, ErrNotFound, repos.States.UpdateCurrentState(UserID, StepEnterMinTemp))
+
+		// and the settings
+		assert.Equal(t, groupByDate, GetUserSettings(repos.States, UserID).DigestGroup)
+		assert.Nil(t, SaveDigestGrouping(repos.States, UserID, groupByLocation))
+		assert.Nil(t, SaveDigestGrouping(repos.States, UserID, groupByLocation))
+		assert.Equal(t, groupByLocation, GetUserSettings(repos.States, UserID).DigestGroup)
+
+		assert.Nil(t, repos.States.DeleteSettings(UserID))
+		assert.Equal(t, ErrNotFound, repos.States.DeleteSettings(UserID))
+		assert.Equal(t, groupByDate, GetUserSettings(repos.States, UserID).DigestGroup)
+	})
+}
+
+func TestAuditRepository(t *testing.T) {
+	forEachRepositories(t, func(t *testing.T, repos Repositories) {
+		entries, err := repos.Audit.FindByAction(auditForgetMe)
+		assert.Nil(t, err)
+		assert.Empty(t, entries)
+
+		assert.Nil(t, repos.Audit.Record(&structs.AuditEntry{Action: auditForgetMe, Bookmarks: 2}))
+		assert.Nil(t, repos.Audit.Record(&structs.AuditEntry{Action: "other"}))
+		assert.Nil(t, repos.Audit.Record(&structs.AuditEntry{Action: auditForgetMe, Bookmarks: 3}))
+
+		entries, err = repos.Audit.FindByAction(auditForgetMe)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(entries))
+		assert.Equal(t, 2, entries[0].Bookmarks)
+		assert.Equal(t, 3, entries[1].Bookmarks)
+	})
+}
+
+func TestEventRepository(t *testing.T) {
+	forEachRepositories(t, func(t *testing.T, repos Repositories) {
+		events, err := repos.Events.Find(EventFilter{})
+		assert.Nil(t, err)
+		assert.Empty(t, events)
+
+		// Given: events of two days
+		day := time.Date(2019, 11, 8, 10, 0, 0, 0, time.UTC)
+		for _, event := range []structs.Event{
+			{Type: EventCommandUsed, Time: day, UserID: UserID, Details: "add"},
+			{Type: EventBookmarkCreated, Time: day, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID},
+			{Type: EventCommandUsed, Time: day.AddDate(0, 0, 1), UserID: User2ID, Details: "help"},
+			{Type: EventAlertSent, Time: day.AddDate(0, 0, 1), UserID: UserID, BookmarkID: 1, Details: "digest 2019-11-10"},
+		} {
+			assert.Nil(t, repos.Events.Record(&event))
+			assert.True(t, event.ID > 0)
+		}
+
+		// Then: the newest are first
+		events, err = repos.Events.Find(EventFilter{})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(events))
+		assert.Equal(t, EventAlertSent, events[0].Type)
+		assert.Equal(t, "digest 2019-11-10", events[0].Details)
+		assert.True(t, day.AddDate(0, 0, 1).Equal(events[0].Time))
+		assert.Equal(t, "add", events[3].Details)
+
+		for _, tt := range []struct {
+			name     string
+			filter   EventFilter
+			expected []string // details
+		}{
+			{"type", EventFilter{Type: EventCommandUsed}, []string{"help", "add"}},
+			{"user", EventFilter{UserID: UserID}, []string{"digest 2019-11-10", "", "add"}},
+			{"since", EventFilter{Since: day.Add(time.Hour)}, []string{"digest 2019-11-10", "help"}},
+			{"limit", EventFilter{UserID: UserID, Limit: 1}, []string{"digest 2019-11-10"}},
+			{"nothing", EventFilter{Type: EventMigrated}, []string{}},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				events, err := repos.Events.Find(tt.filter)
+				assert.Nil(t, err)
+
+				details := []string{}
+				for _, event := range events {
+					details = append(details, event.Details)
+				}
+				assert.Equal(t, tt.expected, details)
+			})
+		}
+
+		// When: the old ones are removed
+		count, err := repos.Events.DeleteBefore(day.Add(time.Hour))
+
+		// Then:
+		assert.Nil(t, err)
+		assert.Equal(t, 2, count)
+		events, _ = repos.Events.Find(EventFilter{})
+		assert.Equal(t, 2, len(events))
+
+		// and when: the user is forgotten
+		count, err = repos.Events.DeleteForUser(UserID)
+
+		// Then:
+		assert.Nil(t, err)
+		assert.Equal(t, 1, count)
+		events, _ = repos.Events.Find(EventFilter{})
+		assert.Equal(t, 1, len(events))
+		assert.Equal(t, User2ID, events[0].UserID)
+	})
+}
+
+func TestEventRepositoryRecordAll(t *testing.T) {
+	forEachRepositories(t, func(t *testing.T, repos Repositories) {
+
+		// Given: checked days of one bookmark
+		day := time.Date(2019, 11, 8, 10, 0, 0, 0, time.UTC)
+		events := []structs.Event{
+			{Type: EventDayChecked, Time: day, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "2019-11-09 suitable"},
+			{Type: EventDayChecked, Time: day, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "2019-11-10 not suitable"},
+		}
+
+		// When:
+		err := repos.Events.RecordAll(events)
+
+		// Then: all of them are saved with IDs
+		assert.Nil(t, err)
+		assert.True(t, events[0].ID > 0)
+		assert.True(t, events[1].ID > events[0].ID)
+
+		saved, err := repos.Events.Find(EventFilter{Type: EventDayChecked})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(saved))
+	})
+}
+
+func TestEventRepositoryCompact(t *testing.T) {
+	forEachRepositories(t, func(t *testing.T, repos Repositories) {
+
+		// Given:
+		day := time.Date(2019, 11, 8, 10, 0, 0, 0, time.UTC)
+		events := []structs.Event{
+			{Type: EventDayChecked, Time: day, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "2019-11-09 suitable"},
+			{Type: EventDayChecked, Time: day, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "2019-11-10 not suitable"},
+			{Type: EventDayChecked, Time: day.AddDate(0, 0, 1), UserID: UserID, BookmarkID: 1, LocationID: TestLocationID, Details: "2019-11-10 suitable"},
+		}
+		assert.Nil(t, repos.Events.RecordAll(events))
+
+		old, err := repos.Events.Find(EventFilter{Type: EventDayChecked, Before: day.AddDate(0, 0, 1)})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(old))
+
+		// When:
+		summaries := []structs.Event{{Type: EventDayChecked, Time: day, UserID: UserID, BookmarkID: 1, LocationID: TestLocationID,
+			Details: "2 days checked, 1 suitable"}}
+		err = repos.Events.Compact(old, summaries)
+
+		// Then: the old events are replaced, the newer one stays
+		assert.Nil(t, err)
+		assert.True(t, summaries[0].ID > events[2].ID)
+
+		saved, err := repos.Events.Find(EventFilter{Type: EventDayChecked})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(saved))
+		assert.Equal(t, "2 days checked, 1 suitable", saved[0].Details)
+		assert.Equal(t, events[2].ID, saved[1].ID)
+	})
+}
+
+func TestSiteRepository(t *testing.T) {
+	forEachRepositories(t, func(t *testing.T, repos Repositories) {
+		site, err := repos.Sites.Get("3")
+		assert.Nil(t, err)
+		assert.Equal(t, "Keswick", site.Name)
+
+		_, err = repos.Sites.Get("404")
+		assert.Equal(t, ErrNotFound, err)
+
+		sites, err := repos.Sites.GetMany([]string{"1", "3", "404"})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(sites))
+
+		sites, err = repos.Sites.All()
+		assert.Nil(t, err)
+		assert.Equal(t, repositorySites, sites)
+	})
+}
@@ -0,0 +1,104 @@
+package command
+
+import (
+	"fmt"
+	"time"
+)
+
+// Run is a sequence of good days in a row, for trips longer than one day
+type Run struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Days      int    `json:"days"`
+	OpenEnded bool   `json:"openEnded"` // the run reaches the end of the forecast, so it could be even longer
+
+	days []DayEvaluation
+}
+
+// FindRuns finds runs of suitable days that are at least minLength days long. Days that are not
+// in the period chosen by user (CheckPeriod) break the run, as well as bad days and gaps in the forecast.
+// Please refer to unit tests
+func FindRuns(evaluations []DayEvaluation, minLength int) []Run {
+	var runs []Run
+	var current []DayEvaluation
+
+	flush := func(openEnded bool) {
+		if len(current) > 0 && len(current) >= minLength {
+			runs = append(runs, Run{
+				From:      current[0].Date,
+				To:        current[len(current)-1].Date,
+				Days:      len(current),
+				OpenEnded: openEnded,
+				days:      current,
+			})
+		}
+		current = nil
+	}
+
+	for _, evaluation := range evaluations {
+		if len(current) > 0 && !evaluation.date.Equal(current[len(current)-1].date.AddDate(0, 0, 1)) {
+			flush(false) // some day is missing in the forecast
+		}
+
+		if evaluation.Skipped || !evaluation.IsSuitable {
+			flush(false)
+			continue
+		}
+
+		current = append(current, evaluation)
+	}
+	flush(true)
+
+	return runs
+}
+
+func (r Run) from() time.Time {
+	return r.days[0].date
+}
+
+func (r Run) to() time.Time {
+	return r.days[len(r.days)-1].date
+}
+
+// human readable run, for example "Sat 12 – Mon 14 Oct, 3 good days"
+func (r Run) describe(isNight bool) string {
+	layoutFrom := "Mon 2"
+	if r.from().Month() != r.to().Month() {
+		layoutFrom = "Mon 2 Jan"
+	}
+
+	what := "good days"
+	if isNight {
+		what = "clear nights"
+	}
+
+	text := fmt.Sprintf("%s – %s, %d %s", r.from().Format(layoutFrom), r.to().Format("Mon 2 Jan"), r.Days, what)
+	if r.OpenEnded {
+		text = text + ", maybe more"
+	}
+	return text
+}
+
+// the run as one entry of the digest, with the worst figures of all its days
+func newGoodRun(day goodDay, run Run) goodDay {
+	day.date = run.from()
+	day.run = &run
+	day.weatherType = run.days[0].WeatherType
+	day.moon = run.days[0].Moon
+	day.temp = run.days[0].Temperature
+	for _, evaluation := range run.days {
+		if evaluation.Temperature < day.temp {
+			day.temp = evaluation.Temperature
+		}
+		if evaluation.Wind > day.wind {
+			day.wind = evaluation.Wind
+		}
+		if evaluation.PrecipProb > day.precip {
+			day.precip = evaluation.PrecipProb
+		}
+		if evaluation.UV > day.uv {
+			day.uv = evaluation.UV
+		}
+	}
+	return day
+}
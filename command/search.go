@@ -0,0 +1,333 @@
+package command
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+const (
+	scoreExact  = 1.0 // the query word is the whole word of the site
+	scorePrefix = 0.8 // the query word is the beginning of the word, user is still typing it
+	scoreFuzzy  = 0.6 // the word with a typo, minus a bit for every edit
+
+	weightName  = 1.0 // words of the name are more important than words of the area or the national park
+	weightOther = 0.6
+
+	bonusNameStart  = 0.5 // the whole query is the beginning of the name
+	bonusPopularity = 0.1 // per every order of magnitude of bookmarks watching the site
+
+	minFuzzyLength = 4   // shorter words are matched only exactly or by prefix
+	minTrigramDice = 0.4 // words that share fewer trigrams are not even compared by edits
+)
+
+// letters with diacritics that appear in the site names, for example "Traigh Mhòr"
+var foldedLetters = map[rune]rune{
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e',
+	'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u',
+	'ý': 'y', 'ÿ': 'y', 'ŷ': 'y', 'ŵ': 'w', 'ñ': 'n', 'ç': 'c',
+}
+
+type (
+	// SiteSearchIndex finds sites by words of the name, the area and the national park. It tolerates typos
+	// ("keswik"), punctuation ("st ives" finds "St. Ives") and unfinished words, and ranks the sites by how
+	// well they match and how many bookmarks watch them
+	SiteSearchIndex struct {
+		sites      []structs.SiteLocation
+		popularity []float64  // the popularity bonus of every site
+		nameWords  [][]string // the normalised words of the name of every site
+
+		words    []string         // all the unique normalised words, sorted, for prefix lookups
+		postings [][]wordPosting  // the sites of every word, by the index of the word
+		trigrams map[string][]int // the words containing the trigram
+	}
+
+	wordPosting struct {
+		site   int
+		weight float64
+	}
+
+	// the match of one query word with one word of the index
+	wordMatch struct {
+		word  int
+		score float64
+	}
+
+	searchResult struct {
+		site  int
+		score float64
+	}
+)
+
+// NewSiteSearchIndex builds the index; popularity is the number of bookmarks of every site ID
+func NewSiteSearchIndex(sites []structs.SiteLocation, popularity map[string]int) *SiteSearchIndex {
+	idx := &SiteSearchIndex{
+		sites:      sites,
+		popularity: make([]float64, len(sites)),
+		nameWords:  make([][]string, len(sites)),
+		trigrams:   make(map[string][]int),
+	}
+
+	// the best weight of every word of every site
+	weights := make(map[string]map[int]float64)
+	addWords := func(site int, text string, weight float64) []string {
+		words := normaliseWords(text)
+		for _, word := range words {
+			if weights[word] == nil {
+				weights[word] = make(map[int]float64)
+			}
+			if weights[word][site] < weight {
+				weights[word][site] = weight
+			}
+		}
+		return words
+	}
+
+	for i, site := range sites {
+		idx.nameWords[i] = addWords(i, site.Name, weightName)
+		addWords(i, site.AuthArea, weightOther)
+		addWords(i, site.NationalPark, weightOther)
+		idx.popularity[i] = bonusPopularity * math.Log10(1+float64(popularity[site.ID]))
+	}
+
+	for word := range weights {
+		idx.words = append(idx.words, word)
+	}
+	sort.Strings(idx.words)
+
+	idx.postings = make([][]wordPosting, len(idx.words))
+	for i, word := range idx.words {
+		for site, weight := range weights[word] {
+			idx.postings[i] = append(idx.postings[i], wordPosting{site: site, weight: weight})
+		}
+		for _, trigram := range wordTrigrams(word) {
+			idx.trigrams[trigram] = append(idx.trigrams[trigram], i)
+		}
+	}
+
+	return idx
+}
+
+// sitePopularity counts bookmarks watching every site, members of groups are counted too
+func sitePopularity(bookmarks []structs.UsersLocationBookmark) map[string]int {
+	popularity := make(map[string]int)
+	for _, bookmark := range bookmarks {
+		for _, locationID := range MemberLocationIDs(bookmark) {
+			popularity[locationID]++
+		}
+	}
+	return popularity
+}
+
+// Search returns up to limit sites matching all the words of the query, the best first
+func (idx *SiteSearchIndex) Search(query string, limit int) []structs.SiteLocation {
+	queryWords := normaliseWords(query)
+	if len(queryWords) == 0 || limit <= 0 {
+		return nil
+	}
+
+	// every word of the query should match some word of the site
+	var scores map[int]float64
+	for _, queryWord := range queryWords {
+		wordScores := make(map[int]float64)
+		for _, match := range idx.matchWord(queryWord) {
+			for _, posting := range idx.postings[match.word] {
+				if score := match.score * posting.weight; score > wordScores[posting.site] {
+					wordScores[posting.site] = score
+				}
+			}
+		}
+
+		if scores == nil {
+			scores = wordScores
+			continue
+		}
+		for site, score := range scores {
+			if wordScore, ok := wordScores[site]; ok {
+				scores[site] = score + wordScore
+			} else {
+				delete(scores, site)
+			}
+		}
+	}
+
+	results := make([]searchResult, 0, len(scores))
+	for site, score := range scores {
+		if startsWithWords(idx.nameWords[site], queryWords) {
+			score += bonusNameStart
+		}
+		results = append(results, searchResult{site: site, score: score + idx.popularity[site]})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return idx.sites[results[i].site].Name < idx.sites[results[j].site].Name
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	sites := make([]structs.SiteLocation, len(results))
+	for i, result := range results {
+		sites[i] = idx.sites[result.site]
+	}
+	return sites
+}
+
+// finds the words of the index matching one word of the query: the same word, the words starting with it,
+// and the words with a typo or two
+func (idx *SiteSearchIndex) matchWord(queryWord string) []wordMatch {
+	best := make(map[int]float64)
+
+	// sorted words starting with the query word are next to each other
+	first := sort.SearchStrings(idx.words, queryWord)
+	for i := first; i < len(idx.words) && strings.HasPrefix(idx.words[i], queryWord); i++ {
+		if idx.words[i] == queryWord {
+			best[i] = scoreExact
+		} else {
+			best[i] = scorePrefix
+		}
+	}
+
+	if len([]rune(queryWord)) >= minFuzzyLength {
+		for word, score := range idx.fuzzyMatches(queryWord) {
+			if score > best[word] {
+				best[word] = score
+			}
+		}
+	}
+
+	matches := make([]wordMatch, 0, len(best))
+	for word, score := range best {
+		matches = append(matches, wordMatch{word: word, score: score})
+	}
+	return matches
+}
+
+// the words sharing enough trigrams with the query word and not farther than a few edits from it
+func (idx *SiteSearchIndex) fuzzyMatches(queryWord string) map[int]float64 {
+	queryTrigrams := wordTrigrams(queryWord)
+	shared := make(map[int]int)
+	for _, trigram := range queryTrigrams {
+		for _, word := range idx.trigrams[trigram] {
+			shared[word]++
+		}
+	}
+
+	maxEdits := 1
+	if len([]rune(queryWord)) >= 7 {
+		maxEdits = 2
+	}
+
+	matches := make(map[int]float64)
+	for word, count := range shared {
+		dice := 2 * float64(count) / float64(len(queryTrigrams)+len(wordTrigrams(idx.words[word])))
+		if dice < minTrigramDice {
+			continue
+		}
+
+		// the typo can be in the part that user has typed already, so the beginning of the word is compared too
+		candidate := []rune(idx.words[word])
+		edits := editDistance([]rune(queryWord), candidate)
+		score := scoreFuzzy
+		if n := len([]rune(queryWord)); len(candidate) > n {
+			if prefixEdits := editDistance([]rune(queryWord), candidate[:n]); prefixEdits < edits {
+				edits = prefixEdits
+				score = scoreFuzzy * scorePrefix
+			}
+		}
+
+		if edits <= maxEdits {
+			matches[word] = score - 0.1*float64(edits)
+		}
+	}
+	return matches
+}
+
+// lower case words without punctuation and diacritics; apostrophes are dropped, so "King's" is "kings"
+func normaliseWords(text string) []string {
+	var builder strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if folded, ok := foldedLetters[r]; ok {
+			r = folded
+		}
+		switch {
+		case r == '\'' || r == '’':
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			builder.WriteRune(r)
+		default:
+			builder.WriteRune(' ')
+		}
+	}
+	return strings.Fields(builder.String())
+}
+
+// trigrams of the word with the marks of its beginning and end, so "kes" gives "$ke", "kes" and "es$"
+func wordTrigrams(word string) []string {
+	runes := []rune("$" + word + "$")
+	trigrams := make([]string, 0, len(runes))
+	seen := make(map[string]bool)
+	for i := 0; i+3 <= len(runes); i++ {
+		trigram := string(runes[i : i+3])
+		if !seen[trigram] {
+			seen[trigram] = true
+			trigrams = append(trigrams, trigram)
+		}
+	}
+	return trigrams
+}
+
+// the Levenshtein distance, where swapping two neighbour letters is one edit too
+func editDistance(a, b []rune) int {
+	previous2 := make([]int, len(b)+1)
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = minInt(current[j], previous2[j-2]+1)
+			}
+		}
+		previous2, previous, current = previous, current, previous2
+	}
+	return previous[len(b)]
+}
+
+// whether the words of the name start with the words of the query, the last one can be unfinished
+func startsWithWords(nameWords, queryWords []string) bool {
+	if len(queryWords) > len(nameWords) {
+		return false
+	}
+	for i, word := range queryWords {
+		last := i == len(queryWords)-1
+		if (last && !strings.HasPrefix(nameWords[i], word)) || (!last && nameWords[i] != word) {
+			return false
+		}
+	}
+	return true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,185 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/asdine/storm/q"
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+var benchmarkQueries = []string{"k", "kes", "keswick", "st ives", "peak", "edinburgh", "ben nevis", "windermere"}
+
+func TestSiteSearch(t *testing.T) {
+
+	// Given:
+	index := NewSiteSearchIndex(loadAllSites(t), nil)
+
+	for _, tt := range []struct {
+		query    string
+		expected []string // the first results
+	}{
+		{"keswick", []string{"Keswick", "Keswick", "Keswick Youth Hostel"}},
+		{"KESWICK", []string{"Keswick", "Keswick", "Keswick Youth Hostel"}},
+		{"keswik", []string{"Keswick", "Keswick", "Keswick Youth Hostel"}},
+		{"kesiwck", []string{"Keswick", "Keswick", "Keswick Youth Hostel"}},
+		{"amblesid", []string{"Ambleside", "Ambleside Youth Hostel"}},
+		{"st ives", []string{"St Ives (Cambridgeshire)", "St Ives (Cornwall)", "St Ives - Porthmeor (Beach)", "St Ives - Porthminster (Beach)", "St. Ives"}},
+		{"king's lynn", []string{"King's Lynn", "King's Lynn Youth Hostel"}},
+		{"kings lyn", []string{"King's Lynn", "King's Lynn Youth Hostel"}},
+		{"traigh mhor", []string{"Barra (Traigh Mhòr) Airport"}},
+		{"ben nevis", []string{"Ben Nevis"}},
+		{"edinburg", []string{"Edinburgh", "Edinburgh Airport"}},
+		{"(", nil},
+		{"ives (", []string{"St Ives (Cambridgeshire)"}},
+		{"[a-z]+", nil},
+		{"", nil},
+	} {
+		t.Run(tt.query, func(t *testing.T) {
+
+			// When:
+			found := index.Search(tt.query, len(tt.expected))
+
+			// Then:
+			var names []string
+			for _, site := range found {
+				names = append(names, site.Name)
+			}
+			assert.Equal(t, tt.expected, names)
+		})
+	}
+}
+
+func TestSiteSearchByAreaAndNationalPark(t *testing.T) {
+
+	// Given:
+	index := NewSiteSearchIndex(loadAllSites(t), nil)
+
+	// When:
+	derbyshire := index.Search("derbyshire", 20)
+	peakDistrict := index.Search("peak district", 20)
+
+	// Then:
+	assert.Equal(t, 20, len(derbyshire))
+	for _, site := range derbyshire {
+		assert.Contains(t, site.Name+" "+site.AuthArea, "Derbyshire")
+	}
+
+	assert.Equal(t, 20, len(peakDistrict))
+	for _, site := range peakDistrict {
+		assert.Equal(t, "Peak District National Park", site.NationalPark, site.Name)
+	}
+}
+
+func TestSiteSearchRanking(t *testing.T) {
+
+	// Given:
+	sites := []structs.SiteLocation{
+		{ID: "1", Name: "Keswick"},
+		{ID: "2", Name: "Keswick"},
+		{ID: "3", Name: "Derwent Water", AuthArea: "Keswick"},
+		{ID: "4", Name: "Keswick Youth Hostel"},
+	}
+
+	// When: the second Keswick is watched by bookmarks
+	popularity := sitePopularity([]structs.UsersLocationBookmark{
+		{LocationID: "2"},
+		{LocationID: "1", GroupLocationIDs: []string{"1", "2"}},
+		{LocationID: "2"},
+	})
+	found := NewSiteSearchIndex(sites, popularity).Search("keswick", 10)
+
+	// Then: exact names first, the popular one before the other, then longer names, then the area
+	var ids []string
+	for _, site := range found {
+		ids = append(ids, site.ID)
+	}
+	assert.Equal(t, []string{"2", "1", "4", "3"}, ids)
+}
+
+func TestNormaliseWords(t *testing.T) {
+	for _, tt := range []struct {
+		text     string
+		expected []string
+	}{
+		{"St. Ives", []string{"st", "ives"}},
+		{"King's Lynn", []string{"kings", "lynn"}},
+		{"Barra (Traigh Mhòr) Airport", []string{"barra", "traigh", "mhor", "airport"}},
+		{"Bowness-On-Windermere", []string{"bowness", "on", "windermere"}},
+		{"Ashover No 2", []string{"ashover", "no", "2"}},
+		{"  (  ) ", []string{}},
+	} {
+		t.Run(tt.text, func(t *testing.T) {
+			assert.Equal(t, tt.expected, normaliseWords(tt.text))
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tt := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"keswick", "keswick", 0},
+		{"keswik", "keswick", 1},
+		{"kesiwck", "keswick", 1},
+		{"kezwik", "keswick", 2},
+		{"", "abc", 3},
+	} {
+		t.Run(tt.a, func(t *testing.T) {
+			assert.Equal(t, tt.expected, editDistance([]rune(tt.a), []rune(tt.b)))
+		})
+	}
+}
+
+func BenchmarkBuildSiteSearchIndex(b *testing.B) {
+	sites := loadAllSites(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewSiteSearchIndex(sites, nil)
+	}
+}
+
+func BenchmarkSiteSearchIndex(b *testing.B) {
+	index := NewSiteSearchIndex(loadAllSites(b), nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Search(benchmarkQueries[i%len(benchmarkQueries)], 20)
+	}
+}
+
+// how the inline query searched before the index: three regular expressions over every site in the database
+func BenchmarkRegexSearch(b *testing.B) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "storm")
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDB(filepath.Join(dir, "weather.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	defer func(path string) { SiteListPath = path }(SiteListPath)
+	SiteListPath = filepath.Join("..", SiteListPath)
+	if _, err := Migrate(db); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pattern := "(?i)(^| )" + regexp.QuoteMeta(benchmarkQueries[i%len(benchmarkQueries)])
+
+		var sites []structs.SiteLocation
+		db.Select(q.Or(
+			q.Re("Name", pattern),
+			q.Re("AuthArea", pattern),
+			q.Re("NationalPark", pattern),
+		)).Limit(20).OrderBy("Name").Find(&sites)
+	}
+}
@@ -0,0 +1,133 @@
+package command
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	julianUnixEpoch = 2440587.5 // Julian date of 1970-01-01 00:00 UTC
+	julianJ2000     = 2451545.0 // Julian date of 2000-01-01 12:00 UTC
+	earthAxialTilt  = 23.4397
+
+	altitudeSunrise    = -0.833 // the upper edge of the sun touches the horizon, with refraction
+	altitudeGoldenHour = 6.0    // the light is warm and soft while the sun is lower than that
+)
+
+// time interval, for example one golden hour
+type timeRange struct {
+	from time.Time
+	to   time.Time
+}
+
+// sunrise, sunset and golden hours for one day at one place, all in UTC
+type sunTimes struct {
+	sunrise time.Time
+	sunset  time.Time
+	golden  []timeRange // morning and evening golden hours, or one range if the sun stays low all day
+}
+
+func (r timeRange) String() string {
+	return r.from.Format("15:04") + "–" + r.to.Format("15:04")
+}
+
+// whether the interval [from, to) has anything in common with the range
+func (r timeRange) overlaps(from, to time.Time) bool {
+	return from.Before(r.to) && to.After(r.from)
+}
+
+// golden hours as text, for example "06:52–07:40, 17:31–18:19"
+func (s sunTimes) goldenHours() string {
+	ranges := make([]string, len(s.golden))
+	for i, r := range s.golden {
+		ranges[i] = r.String()
+	}
+	return strings.Join(ranges, ", ")
+}
+
+// human readable solar times, for example "🌅 06:34, 🌇 17:23, golden hours 06:34–07:23, 16:34–17:23 (UTC)"
+func (s sunTimes) String() string {
+	return "🌅 " + s.sunrise.Format("15:04") + ", 🌇 " + s.sunset.Format("15:04") + ", golden hours " + s.goldenHours() + " (UTC)"
+}
+
+// calculates solar times for the date (only the year, month and day are used) using the sunrise equation,
+// which is accurate to a minute or two, more than enough for us. Latitude is positive to the north,
+// longitude is positive to the east. Returns false if the sun doesn't rise or set that day.
+// Please refer to unit tests
+func calculateSunTimes(date time.Time, latitude, longitude float64) (sunTimes, bool) {
+	transit, declination := solarTransit(date, longitude)
+
+	sunrise, sunset, ok := sunCrossing(transit, declination, latitude, altitudeSunrise)
+	if !ok {
+		return sunTimes{}, false
+	}
+
+	times := sunTimes{sunrise: sunrise, sunset: sunset}
+	if morning, evening, ok := sunCrossing(transit, declination, latitude, altitudeGoldenHour); ok {
+		times.golden = []timeRange{{sunrise, morning}, {evening, sunset}}
+	} else {
+		// winter in the far north, the sun never climbs high
+		times.golden = []timeRange{{sunrise, sunset}}
+	}
+	return times, true
+}
+
+// the same, but for coordinates as they are stored in the database and in MetOffice responses
+func sunTimesFor(date time.Time, latitude, longitude string) (sunTimes, bool) {
+	lat, err := strconv.ParseFloat(latitude, 64)
+	if err != nil {
+		return sunTimes{}, false
+	}
+	lon, err := strconv.ParseFloat(longitude, 64)
+	if err != nil {
+		return sunTimes{}, false
+	}
+	return calculateSunTimes(date, lat, lon)
+}
+
+// returns the Julian date of the solar noon and the declination of the sun (in radians)
+func solarTransit(date time.Time, longitude float64) (float64, float64) {
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	julianDay := float64(midnight.Unix())/86400 + julianUnixEpoch
+
+	// mean solar time
+	n := math.Ceil(julianDay - julianJ2000 + 0.0008)
+	meanSolarTime := n - longitude/360
+
+	// solar mean anomaly and the equation of the center
+	anomaly := math.Mod(357.5291+0.98560028*meanSolarTime, 360)
+	anomalyRad := toRadians(anomaly)
+	center := 1.9148*math.Sin(anomalyRad) + 0.0200*math.Sin(2*anomalyRad) + 0.0003*math.Sin(3*anomalyRad)
+
+	// ecliptic longitude
+	eclipticLongitude := toRadians(math.Mod(anomaly+center+180+102.9372, 360))
+
+	transit := julianJ2000 + meanSolarTime + 0.0053*math.Sin(anomalyRad) - 0.0069*math.Sin(2*eclipticLongitude)
+	declination := math.Asin(math.Sin(eclipticLongitude) * math.Sin(toRadians(earthAxialTilt)))
+
+	return transit, declination
+}
+
+// returns the times when the sun passes the altitude (in degrees) in the morning and in the evening
+func sunCrossing(transit, declination, latitude, altitude float64) (time.Time, time.Time, bool) {
+	latitudeRad := toRadians(latitude)
+	cosHourAngle := (math.Sin(toRadians(altitude)) - math.Sin(latitudeRad)*math.Sin(declination)) /
+		(math.Cos(latitudeRad) * math.Cos(declination))
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+	return fromJulian(transit - hourAngle/360), fromJulian(transit + hourAngle/360), true
+}
+
+func fromJulian(julian float64) time.Time {
+	seconds := (julian - julianUnixEpoch) * 86400
+	return time.Unix(int64(math.Round(seconds)), 0).UTC()
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
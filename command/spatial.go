@@ -0,0 +1,226 @@
+package command
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+const earthRadiusKm = 6371.0
+
+type (
+	// SiteIndex answers "which sites are near this point". It is a k-d tree over the points on the unit
+	// sphere, so the straight distance between points grows together with the distance over the Earth
+	// surface and there are no troubles around the poles or the 180th meridian
+	SiteIndex struct {
+		root *siteNode
+		size int
+	}
+
+	// SiteDistance is one found site with the distance to it
+	SiteDistance struct {
+		Site       structs.SiteLocation
+		DistanceKm float64
+	}
+
+	siteNode struct {
+		site        structs.SiteLocation
+		lat, lon    float64
+		point       [3]float64
+		axis        int
+		left, right *siteNode
+	}
+
+	// the max-heap of the nearest nodes found so far, the farthest one is on the top
+	nearestHeap []nearestCandidate
+
+	nearestCandidate struct {
+		node  *siteNode
+		chord float64
+	}
+)
+
+// NewSiteIndex builds the index; sites without valid coordinates are skipped
+func NewSiteIndex(sites []structs.SiteLocation) *SiteIndex {
+	nodes := make([]*siteNode, 0, len(sites))
+	for _, site := range sites {
+		lat, lon, ok := siteCoordinates(site)
+		if !ok {
+			continue
+		}
+		nodes = append(nodes, &siteNode{site: site, lat: lat, lon: lon, point: unitVector(lat, lon)})
+	}
+
+	return &SiteIndex{root: buildSiteTree(nodes, 0), size: len(nodes)}
+}
+
+// Size returns the number of indexed sites
+func (idx *SiteIndex) Size() int {
+	return idx.size
+}
+
+// Nearest returns n sites nearest to the point, the nearest first. If several sites are as far as the last one,
+// any of them can be returned
+func (idx *SiteIndex) Nearest(lat, lon float64, n int) []SiteDistance {
+	if n <= 0 || idx.root == nil {
+		return nil
+	}
+
+	target := unitVector(lat, lon)
+	found := make(nearestHeap, 0, n)
+	idx.root.nearest(target, n, &found)
+
+	result := make([]SiteDistance, len(found))
+	for i := len(found) - 1; i >= 0; i-- {
+		candidate := heap.Pop(&found).(nearestCandidate)
+		result[i] = candidate.node.distanceFrom(lat, lon)
+	}
+	return result
+}
+
+// Within returns all the sites not farther than radiusKm from the point, the nearest first
+func (idx *SiteIndex) Within(lat, lon, radiusKm float64) []SiteDistance {
+	if idx.root == nil || radiusKm < 0 {
+		return nil
+	}
+
+	// the radius along the surface is converted to the straight line between points of the unit sphere
+	chord := 2.0 // the diameter, for the radius of the half of the Earth or more
+	if angle := radiusKm / earthRadiusKm; angle < math.Pi {
+		chord = 2 * math.Sin(angle/2)
+	}
+
+	var nodes []*siteNode
+	idx.root.within(unitVector(lat, lon), chord*chord, &nodes)
+
+	result := make([]SiteDistance, len(nodes))
+	for i, node := range nodes {
+		result[i] = node.distanceFrom(lat, lon)
+	}
+	sortByDistance(result)
+	return result
+}
+
+// some sites share coordinates, so they are sorted by IDs too
+func sortByDistance(distances []SiteDistance) {
+	sort.Slice(distances, func(i, j int) bool {
+		if distances[i].DistanceKm == distances[j].DistanceKm {
+			return distances[i].Site.ID < distances[j].Site.ID
+		}
+		return distances[i].DistanceKm < distances[j].DistanceKm
+	})
+}
+
+func buildSiteTree(nodes []*siteNode, depth int) *siteNode {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	axis := depth % 3
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].point[axis] < nodes[j].point[axis]
+	})
+
+	median := len(nodes) / 2
+	node := nodes[median]
+	node.axis = axis
+	node.left = buildSiteTree(nodes[:median], depth+1)
+	node.right = buildSiteTree(nodes[median+1:], depth+1)
+	return node
+}
+
+func (node *siteNode) nearest(target [3]float64, n int, found *nearestHeap) {
+	if node == nil {
+		return
+	}
+
+	chord := squaredDistance(node.point, target)
+	if len(*found) < n {
+		heap.Push(found, nearestCandidate{node: node, chord: chord})
+	} else if chord < (*found)[0].chord {
+		(*found)[0] = nearestCandidate{node: node, chord: chord}
+		heap.Fix(found, 0)
+	}
+
+	diff := target[node.axis] - node.point[node.axis]
+	near, far := node.left, node.right
+	if diff > 0 {
+		near, far = far, near
+	}
+
+	near.nearest(target, n, found)
+
+	// the other side can have nearer sites only if the splitting plane is nearer than the farthest found one
+	if len(*found) < n || diff*diff < (*found)[0].chord {
+		far.nearest(target, n, found)
+	}
+}
+
+func (node *siteNode) within(target [3]float64, maxChord float64, found *[]*siteNode) {
+	if node == nil {
+		return
+	}
+
+	if squaredDistance(node.point, target) <= maxChord {
+		*found = append(*found, node)
+	}
+
+	diff := target[node.axis] - node.point[node.axis]
+	if diff <= 0 || diff*diff <= maxChord {
+		node.left.within(target, maxChord, found)
+	}
+	if diff >= 0 || diff*diff <= maxChord {
+		node.right.within(target, maxChord, found)
+	}
+}
+
+func (node *siteNode) distanceFrom(lat, lon float64) SiteDistance {
+	return SiteDistance{Site: node.site, DistanceKm: haversineKm(lat, lon, node.lat, node.lon)}
+}
+
+func (h nearestHeap) Len() int            { return len(h) }
+func (h nearestHeap) Less(i, j int) bool  { return h[i].chord > h[j].chord }
+func (h nearestHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nearestHeap) Push(x interface{}) { *h = append(*h, x.(nearestCandidate)) }
+func (h *nearestHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// the great-circle distance between two points
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1, phi2 := toRadians(lat1), toRadians(lat2)
+	dPhi := phi2 - phi1
+	dLambda := toRadians(lon2 - lon1)
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// MetOffice sends coordinates as strings, for example "54.6" and "-3.1"
+func siteCoordinates(site structs.SiteLocation) (float64, float64, bool) {
+	lat, err := strconv.ParseFloat(site.Latitude, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, false
+	}
+	lon, err := strconv.ParseFloat(site.Longitude, 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+func unitVector(lat, lon float64) [3]float64 {
+	phi, lambda := toRadians(lat), toRadians(lon)
+	return [3]float64{math.Cos(phi) * math.Cos(lambda), math.Cos(phi) * math.Sin(lambda), math.Sin(phi)}
+}
+
+func squaredDistance(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
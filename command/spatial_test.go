@@ -0,0 +1,187 @@
+package command
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+func TestHaversine(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		lat1     float64
+		lon1     float64
+		lat2     float64
+		lon2     float64
+		expected float64
+	}{
+		{"the same point", 54.6007, -3.1343, 54.6007, -3.1343, 0},
+		{"London to Edinburgh", 51.5074, -0.1278, 55.9533, -3.1883, 534},
+		{"Keswick to Ambleside", 54.6007, -3.1343, 54.4326, -2.9636, 22},
+		{"over the 180th meridian", 0, 179.9, 0, -179.9, 22},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.expected, haversineKm(tt.lat1, tt.lon1, tt.lat2, tt.lon2), 1)
+		})
+	}
+}
+
+func TestSiteIndex(t *testing.T) {
+
+	// Given:
+	index := NewSiteIndex([]structs.SiteLocation{
+		{ID: "1", Name: "Keswick", Latitude: "54.6007", Longitude: "-3.1343"},
+		{ID: "2", Name: "Ambleside", Latitude: "54.4326", Longitude: "-2.9636"},
+		{ID: "3", Name: "Buxton", Latitude: "53.2590", Longitude: "-1.9110"},
+		{ID: "4", Name: "London", Latitude: "51.5074", Longitude: "-0.1278"},
+		{ID: "5", Name: "Broken", Latitude: "north", Longitude: "-3.0"},
+	})
+
+	// Then: the site without coordinates is skipped
+	assert.Equal(t, 4, index.Size())
+
+	// and when:
+	nearest := index.Nearest(54.5, -3.0, 2)
+	within := index.Within(54.6007, -3.1343, 30)
+
+	// Then:
+	assert.Equal(t, []string{"Ambleside", "Keswick"}, siteNames(nearest))
+	assert.True(t, nearest[0].DistanceKm < nearest[1].DistanceKm)
+
+	assert.Equal(t, []string{"Keswick", "Ambleside"}, siteNames(within))
+	assert.Equal(t, 0.0, within[0].DistanceKm)
+
+	// and more sites than there are
+	assert.Equal(t, 4, len(index.Nearest(0, 0, 10)))
+	assert.Equal(t, 4, len(index.Within(0, 0, 20000)))
+	assert.Empty(t, index.Within(0, 0, 100))
+	assert.Empty(t, NewSiteIndex(nil).Nearest(0, 0, 5))
+}
+
+func TestSiteIndexOverThe180thMeridian(t *testing.T) {
+	index := NewSiteIndex([]structs.SiteLocation{
+		{ID: "1", Name: "West", Latitude: "0", Longitude: "179.9"},
+		{ID: "2", Name: "East", Latitude: "0", Longitude: "-179.9"},
+		{ID: "3", Name: "Far", Latitude: "0", Longitude: "170"},
+	})
+
+	assert.Equal(t, []string{"West", "East"}, siteNames(index.Within(0, 179.95, 20)))
+	assert.Equal(t, []string{"East", "West"}, siteNames(index.Nearest(0, -179.95, 2)))
+}
+
+// the index should find exactly the same sites as the check of every site does
+func TestSiteIndexMatchesBruteForce(t *testing.T) {
+
+	// Given:
+	sites := loadAllSites(t)
+	index := NewSiteIndex(sites)
+	random := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 30; i++ {
+		lat, lon := randomPointInUK(random)
+
+		// When:
+		nearest := index.Nearest(lat, lon, 5)
+		within := index.Within(lat, lon, 30)
+
+		// Then:
+		expected := bruteForceDistances(sites, lat, lon)
+		assert.Equal(t, 5, len(nearest))
+		for j := range nearest {
+			assert.InDelta(t, expected[j].DistanceKm, nearest[j].DistanceKm, 1e-9)
+		}
+
+		var expectedWithin []SiteDistance
+		for _, site := range expected {
+			if site.DistanceKm <= 30 {
+				expectedWithin = append(expectedWithin, site)
+			}
+		}
+		assert.Equal(t, siteIDs(expectedWithin), siteIDs(within))
+	}
+}
+
+func BenchmarkBuildSiteIndex(b *testing.B) {
+	sites := loadAllSites(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewSiteIndex(sites)
+	}
+}
+
+func BenchmarkSiteIndexNearest(b *testing.B) {
+	index := NewSiteIndex(loadAllSites(b))
+	random := rand.New(rand.NewSource(1))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lat, lon := randomPointInUK(random)
+		index.Nearest(lat, lon, 5)
+	}
+}
+
+func BenchmarkSiteIndexWithin(b *testing.B) {
+	index := NewSiteIndex(loadAllSites(b))
+	random := rand.New(rand.NewSource(1))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lat, lon := randomPointInUK(random)
+		index.Within(lat, lon, 30)
+	}
+}
+
+// what we would do without the index
+func BenchmarkBruteForceNearest(b *testing.B) {
+	sites := loadAllSites(b)
+	random := rand.New(rand.NewSource(1))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lat, lon := randomPointInUK(random)
+		bruteForceDistances(sites, lat, lon)
+	}
+}
+
+func loadAllSites(tb testing.TB) []structs.SiteLocation {
+	sites, err := readSiteList("../api-examples/site-list.json")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return sites
+}
+
+func randomPointInUK(random *rand.Rand) (float64, float64) {
+	return 50 + random.Float64()*8.5, -7.5 + random.Float64()*9.3
+}
+
+// distances to all the sites, sorted
+func bruteForceDistances(sites []structs.SiteLocation, lat, lon float64) []SiteDistance {
+	var distances []SiteDistance
+	for _, site := range sites {
+		if siteLat, siteLon, ok := siteCoordinates(site); ok {
+			distances = append(distances, SiteDistance{Site: site, DistanceKm: haversineKm(lat, lon, siteLat, siteLon)})
+		}
+	}
+	sortByDistance(distances)
+	return distances
+}
+
+func siteNames(distances []SiteDistance) []string {
+	var names []string
+	for _, distance := range distances {
+		names = append(names, distance.Site.Name)
+	}
+	return names
+}
+
+func siteIDs(distances []SiteDistance) []string {
+	var ids []string
+	for _, distance := range distances {
+		ids = append(ids, distance.Site.ID)
+	}
+	return ids
+}
@@ -9,18 +9,32 @@ import (
 
 	"github.com/w32blaster/bot-weather-watcher/structs"
 
-	"github.com/asdine/storm"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
 const (
-	StepEnterLocation     = 1
-	StepEnterMaxWindSpeed = 2
-	StepEnterMinTemp      = 3
-	StepSpecifyDays       = 4
-	FINISHED              = -1
-	onlyWeekends          = 0
-	allDays               = 1
+	StepEnterLocation      = 1
+	StepEnterMaxWindSpeed  = 2
+	StepEnterMinTemp       = 3
+	StepSpecifyDays        = 4
+	StepExcludeWeather     = 5
+	StepWindDirections     = 6
+	StepEnterMaxGust       = 7
+	StepEnterMaxTemp       = 8
+	StepEnterMaxHumidity   = 9
+	StepEnterMaxUV         = 10
+	StepEnterUVWarning     = 11
+	StepEnterMinVisibility = 12
+	StepChooseMode         = 13
+	StepEnterMinNightTemp  = 14
+	StepEnterGroupName     = 15
+	StepPickGroupLocations = 16
+	StepAddGroupMember     = 17
+	StepChooseHazards      = 18
+	StepChooseProfile      = 19
+	FINISHED               = -1
+	AllDays                = 1
+	OnlyWeekends           = 2 // not zero, so a bookmark without the choice is not a weekend one
 )
 
 type (
@@ -33,7 +47,8 @@ type (
 		UserID       int
 		UserName     string
 		currentState int
-		db           *storm.DB
+		bookmarkID   int // the saved bookmark that is being changed, if any
+		repos        Repositories
 		bot          *tgbotapi.BotAPI
 		chatID       int64
 	}
@@ -41,19 +56,47 @@ type (
 
 var states = map[int]state{
 
-	StepEnterLocation: {
-		next: StepEnterMaxWindSpeed,
+	StepChooseProfile: {
+		next: StepEnterLocation,
 		fnProcess: func(rawMessage string, sm *StateMachine) {
 
-			if !strings.HasPrefix(rawMessage, LocationIDPrefix) {
-				sendMsg(sm.bot, sm.chatID, "Error, wrong location ID format")
+			if rawMessage != answerCustomProfile {
+				profile, ok := findProfile(rawMessage)
+				if !ok {
+					sendMsg(sm.bot, sm.chatID, "Please click one of the buttons provided below")
+					return
+				}
+
+				bookmark := sm.GetUnfinishedBookmark()
+				if bookmark == nil {
+					sendMsg(sm.bot, sm.chatID, "Internal error: can't find the location you are adding, please start again with /add")
+					return
+				}
+
+				profile.applyTo(bookmark)
+				if err := sm.repos.Bookmarks.Save(bookmark); err != nil {
+					sentry.CaptureException(err)
+					sendMsg(sm.bot, sm.chatID, "Internal error: can't save the profile")
+					return
+				}
+			}
+
+			if err := sm.markNextStepState(StepEnterLocation); err != nil {
+				sentry.CaptureException(err)
+				sendMsg(sm.bot, sm.chatID, "Internal error: can't update state")
 				return
 			}
 
-			locaIDClean := strings.TrimPrefix(rawMessage, LocationIDPrefix)
-			if _, err := strconv.Atoi(locaIDClean); err != nil {
-				sendMsg(sm.bot, sm.chatID, fmt.Sprintf("hey, %s is not a number! Please send me only number which is max speed of wind acceptable for you."+
-					"Please ommit the 'mph' or other suffixes", rawMessage))
+			sm.askLocation()
+		},
+	},
+
+	StepEnterLocation: {
+		next: StepChooseMode,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+
+			locaIDClean, ok := sm.parseLocationID(rawMessage)
+			if !ok {
 				return
 			}
 
@@ -63,28 +106,224 @@ var states = map[int]state{
 				return
 			}
 
+			// the activity profile has set all the thresholds already
+			if bookmark := sm.GetUnfinishedBookmark(); bookmark != nil && len(bookmark.Profile) > 0 {
+				sm.finish()
+				return
+			}
+
 			// if correct, then move to the next step
-			if err := sm.markNextStepState(StepEnterMaxWindSpeed); err != nil {
+			if err := sm.markNextStepState(StepChooseMode); err != nil {
 				sentry.CaptureException(err)
 				sendMsg(sm.bot, sm.chatID, "Internal error: can't update state")
 				return
 			}
 
-			sendMsg(sm.bot, sm.chatID, "Ok, now enter the max wind speed that is comfortable for you in that location. \n\n Enter max wind speed (mph):")
+			sm.askMode()
+		},
+	},
+
+	StepEnterGroupName: {
+		next: StepPickGroupLocations,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+			name := strings.TrimSpace(rawMessage)
+			if len(name) == 0 || strings.HasPrefix(name, LocationIDPrefix) || len([]rune(name)) > 40 {
+				sendMsg(sm.bot, sm.chatID, "Please send me a short name of the group, for example Peak District")
+				return
+			}
+
+			sm.UpdateFieldInBookmark("GroupName", name)
+			sm.markNextStepState(StepPickGroupLocations)
+
+			if sm.bot == nil {
+				return // for unit tests
+			}
+			resp, _ := sendMsg(sm.bot, sm.chatID, "Great, now pick places of the group one by one. "+
+				"Start typing name following by the bot name, or click the button below")
+			renderButtonThatOpensInlineQuery(sm.bot, sm.chatID, resp.MessageID)
+		},
+	},
+
+	StepPickGroupLocations: {
+		next: StepChooseMode,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+			bookmark := sm.GetUnfinishedBookmark()
+			if bookmark == nil {
+				sendMsg(sm.bot, sm.chatID, "Internal error: can't find a group")
+				return
+			}
+
+			if rawMessage == ButtonDone {
+				if len(bookmark.GroupLocationIDs) < minGroupSize {
+					sendMsg(sm.bot, sm.chatID, "A group needs at least two places, please pick one more")
+					return
+				}
+
+				sm.markNextStepState(StepChooseMode)
+				sm.askMode()
+				return
+			}
+
+			locationID, ok := sm.parseLocationID(rawMessage)
+			if !ok {
+				return
+			}
+
+			if err := addGroupMember(sm.repos.Bookmarks, bookmark, locationID); err != nil {
+				sentry.CaptureException(err)
+				sendMsg(sm.bot, sm.chatID, "Internal error: can't update location")
+				return
+			}
+			sm.askMoreGroupMembers(bookmark)
+		},
+	},
+
+	StepAddGroupMember: {
+		next: FINISHED,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+			bookmark, err := sm.repos.Bookmarks.Get(sm.bookmarkID)
+			if err != nil || bookmark.UserID != sm.UserID {
+				sm.repos.States.DeleteState(sm.UserID)
+				sendMsg(sm.bot, sm.chatID, "Can't find a group")
+				return
+			}
+
+			if rawMessage == ButtonDone {
+				sm.currentState = FINISHED
+				sm.repos.States.DeleteState(sm.UserID)
+				sendMsg(sm.bot, sm.chatID, "✅ Saved. You can see all saved bookmarks using the command \n /locations")
+				return
+			}
+
+			locationID, ok := sm.parseLocationID(rawMessage)
+			if !ok {
+				return
+			}
+
+			if err := addGroupMember(sm.repos.Bookmarks, &bookmark, locationID); err != nil {
+				sentry.CaptureException(err)
+				sendMsg(sm.bot, sm.chatID, "Internal error: can't update location")
+				return
+			}
+			sm.askMoreGroupMembers(&bookmark)
+		},
+	},
+
+	StepChooseMode: {
+		next: StepEnterMaxWindSpeed,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+			if rawMessage == answerHazards {
+
+				// bad weather warnings don't need any thresholds, user picks from preset hazards
+				sm.UpdateFieldInBookmark("Polarity", PolarityHazard)
+				sm.markNextStepState(StepChooseHazards)
+
+				if sm.bot == nil {
+					return // for unit tests
+				}
+
+				if bookmark := sm.GetUnfinishedBookmark(); bookmark != nil {
+					askHazards(sm.bot, sm.chatID, bookmark)
+				}
+				return
+			}
+
+			intMode, err := strconv.Atoi(rawMessage)
+			if err != nil || (intMode != ModeDay && intMode != ModeNight) {
+				sendMsg(sm.bot, sm.chatID, "Please click one of three buttons provided below")
+				return
+			}
+
+			sm.UpdateFieldInBookmark("Mode", intMode)
+			sm.markNextStepState(StepEnterMaxWindSpeed)
+
+			if intMode == ModeNight {
+				sendMsg(sm.bot, sm.chatID, "Clear nights it is. Wind shakes a telescope, so what is the max wind speed you accept? \n\n Enter max wind speed (mph):")
+				return
+			}
+
+			sendMsg(sm.bot, sm.chatID, "Ok, now enter the max wind speed that is comfortable for you in that location. "+
+				"This is the sustained wind, gusts are asked separately. \n\n Enter max wind speed (mph):")
+		},
+	},
+
+	StepChooseHazards: {
+		next: StepSpecifyDays,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+
+			// hazards are toggled by buttons outside of the state machine, here we wait only for "done"
+			if rawMessage != ButtonDone {
+				sendMsg(sm.bot, sm.chatID, "Please tick the hazards using the buttons above and click Done")
+				return
+			}
+
+			if bookmark := sm.GetUnfinishedBookmark(); bookmark == nil || bookmark.Hazards == 0 {
+				sendMsg(sm.bot, sm.chatID, "Please tick at least one hazard, otherwise there is nothing to warn you about")
+				return
+			}
+
+			sm.markNextStepState(StepSpecifyDays)
+			sm.askDays()
 		},
 	},
 
 	StepEnterMaxWindSpeed: {
-		next: StepEnterMinTemp,
+		next: StepEnterMaxGust,
 		fnProcess: func(rawMessage string, sm *StateMachine) {
 			intMaxWindSpeed, err := strconv.Atoi(rawMessage)
 			if err != nil {
-				sendMsg(sm.bot, sm.chatID, fmt.Sprintf("hey, %s is not a number! Please send me only number which is min temperature acceptable for you."+
-					"Please ommit the 'degrees, ˚C or other suffixes; number only", rawMessage))
+				sendMsg(sm.bot, sm.chatID, fmt.Sprintf("hey, %s is not a number! Please send me only number which is max speed of wind acceptable for you."+
+					"Please ommit the 'mph' or other suffixes", rawMessage))
 				return
 			}
 
 			sm.UpdateFieldInBookmark("MaxWindSpeed", intMaxWindSpeed)
+
+			// at night we look at the sky only, so the rest of day questions are not needed
+			if bookmark := sm.GetUnfinishedBookmark(); bookmark != nil && bookmark.Mode == ModeNight {
+				sm.markNextStepState(StepEnterMinNightTemp)
+				sm.askOptional("Cold nights are hard to stay outside. \n\n Enter the lowest night temperature (in ˚C), or click Skip:")
+				return
+			}
+
+			sm.markNextStepState(StepEnterMaxGust)
+
+			sm.askOptional("And what about gusts? \n\n Enter max wind gust (mph), or click Skip if gusts don't bother you:")
+		},
+	},
+
+	StepEnterMinNightTemp: {
+		next: StepSpecifyDays,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+			minNightTemp, ok := parseOptionalNumber(rawMessage)
+			if !ok {
+				sendMsg(sm.bot, sm.chatID, fmt.Sprintf("hey, %s is not a number! Please send me only plain number which is min night temperature acceptable for you, "+
+					"or click Skip", rawMessage))
+				return
+			}
+
+			if minNightTemp != nil {
+				sm.UpdateFieldInBookmark("MinNightTemp", minNightTemp)
+			}
+			sm.markNextStepState(StepSpecifyDays)
+
+			sm.askDays()
+		},
+	},
+
+	StepEnterMaxGust: {
+		next: StepEnterMinTemp,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+			maxGust, ok := parseOptionalNumber(rawMessage)
+			if !ok {
+				sendMsg(sm.bot, sm.chatID, fmt.Sprintf("hey, %s is not a number! Please send me only number which is max wind gust acceptable for you, "+
+					"or click Skip", rawMessage))
+				return
+			}
+
+			if maxGust != nil {
+				sm.UpdateFieldInBookmark("MaxGust", maxGust)
+			}
 			sm.markNextStepState(StepEnterMinTemp)
 
 			sendMsg(sm.bot, sm.chatID, "Go it, now I'd like to know the lowest temperature that suits for you. \n\n Enter the lowest temperature (in ˚C):")
@@ -92,7 +331,7 @@ var states = map[int]state{
 	},
 
 	StepEnterMinTemp: {
-		next: StepSpecifyDays,
+		next: StepEnterMaxTemp,
 		fnProcess: func(rawMessage string, sm *StateMachine) {
 			intMinTemp, err := strconv.Atoi(rawMessage)
 			if err != nil {
@@ -102,29 +341,110 @@ var states = map[int]state{
 			}
 
 			sm.UpdateFieldInBookmark("LowestTemp", intMinTemp)
-			sm.markNextStepState(StepSpecifyDays)
+			sm.markNextStepState(StepEnterMaxTemp)
 
-			if sm.bot == nil {
-				return // for unit tests
+			sm.askOptional("Desired temperature is saved. Is there a temperature that is too hot for you? \n\n " +
+				"Enter the highest temperature (in ˚C), or click Skip:")
+		},
+	},
+
+	StepEnterMaxTemp: {
+		next: StepEnterMaxHumidity,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+			maxTemp, ok := parseOptionalNumber(rawMessage)
+			if !ok {
+				sendMsg(sm.bot, sm.chatID, fmt.Sprintf("hey, %s is not a number! Please send me only plain number which is max temperature acceptable for you, "+
+					"or click Skip", rawMessage))
+				return
 			}
 
-			msg, _ := sendMsg(sm.bot, sm.chatID, "Desired temperature is saved. The last step, what days do you want to observe? \n"+
-				" - only weekend (makes sense if you at work during weekdays) \n"+
-				" - all days (when you have a vacation or you have flexible time schedule)?")
+			if maxTemp != nil {
+				sm.UpdateFieldInBookmark("MaxTemp", maxTemp)
+			}
+			sm.markNextStepState(StepEnterMaxHumidity)
 
-			rowButtons := []tgbotapi.InlineKeyboardButton{
-				tgbotapi.NewInlineKeyboardButtonData("Only Weekend", ButtonChoiceAllDaysOrWeekends+Separator+strconv.Itoa(onlyWeekends)),
-				tgbotapi.NewInlineKeyboardButtonData("All days", ButtonChoiceAllDaysOrWeekends+Separator+strconv.Itoa(allDays)),
+			sm.askOptional("Humid days could be hard for hiking. \n\n Enter the max relative humidity (in %), or click Skip:")
+		},
+	},
+
+	StepEnterMaxHumidity: {
+		next: StepEnterMaxUV,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+			maxHumidity, ok := parseOptionalNumber(rawMessage)
+			if !ok {
+				sendMsg(sm.bot, sm.chatID, fmt.Sprintf("hey, %s is not a number! Please send me only plain number which is max humidity acceptable for you, "+
+					"or click Skip", rawMessage))
+				return
 			}
 
-			keyboard := tgbotapi.NewInlineKeyboardMarkup(rowButtons)
-			keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(sm.chatID, msg.MessageID, keyboard)
-			sm.bot.Send(keyboardMsg)
+			if maxHumidity != nil {
+				sm.UpdateFieldInBookmark("MaxHumidity", maxHumidity)
+			}
+			sm.markNextStepState(StepEnterMaxUV)
+
+			sm.askOptional("If you are sensitive to the sun, the day with high UV index is not a good one. \n\n " +
+				"Enter the max UV index (1-11), or click Skip:")
+		},
+	},
+
+	StepEnterMaxUV: {
+		next: StepEnterUVWarning,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+			maxUV, ok := parseOptionalNumber(rawMessage)
+			if !ok {
+				sendMsg(sm.bot, sm.chatID, fmt.Sprintf("hey, %s is not a number! Please send me only plain number which is max UV index acceptable for you, "+
+					"or click Skip", rawMessage))
+				return
+			}
+
+			if maxUV != nil {
+				sm.UpdateFieldInBookmark("MaxUV", maxUV)
+			}
+			sm.markNextStepState(StepEnterUVWarning)
+
+			sm.askOptional("I can also warn you separately when a good day has strong sun, with the hours of peak UV. \n\n " +
+				"Enter the UV index to warn you from (for example 6 is high), or click Skip:")
+		},
+	},
+
+	StepEnterUVWarning: {
+		next: StepEnterMinVisibility,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+			uvWarnLevel, ok := parseOptionalNumber(rawMessage)
+			if !ok {
+				sendMsg(sm.bot, sm.chatID, fmt.Sprintf("hey, %s is not a number! Please send me only plain number which is UV index, "+
+					"or click Skip", rawMessage))
+				return
+			}
+
+			if uvWarnLevel != nil {
+				sm.UpdateFieldInBookmark("UVWarnLevel", uvWarnLevel)
+			}
+			sm.markNextStepState(StepEnterMinVisibility)
+
+			sm.askMinVisibility()
+		},
+	},
+
+	StepEnterMinVisibility: {
+		next: StepSpecifyDays,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+			if rawMessage != ButtonSkip {
+				minVisibility, ok := visibilityScale[rawMessage]
+				if !ok {
+					sendMsg(sm.bot, sm.chatID, "Please click one of the buttons with visibility")
+					return
+				}
+				sm.UpdateFieldInBookmark("MinVisibility", minVisibility)
+			}
+			sm.markNextStepState(StepSpecifyDays)
+
+			sm.askDays()
 		},
 	},
 
 	StepSpecifyDays: {
-		next: FINISHED,
+		next: StepExcludeWeather,
 		fnProcess: func(rawMessage string, sm *StateMachine) {
 
 			intChoice, err := strconv.Atoi(rawMessage)
@@ -134,35 +454,75 @@ var states = map[int]state{
 				return
 			}
 
-			if intChoice != allDays && intChoice != onlyWeekends {
+			if intChoice != AllDays && intChoice != OnlyWeekends {
 				sendMsg(sm.bot, sm.chatID, "Please click one of two buttons provided below")
-				sentry.CaptureException(errors.Wrap(err, "State machine: step for days specifying; we waited for a response only 1 or 0"))
+				sentry.CaptureException(errors.Wrap(err, "State machine: step for days specifying; we waited for a response only 1 or 2"))
 				return
 			}
 
 			sm.UpdateFieldInBookmark("CheckPeriod", intChoice)
-			sm.UpdateFieldInBookmark("IsReady", true)
 
-			sm.currentState = FINISHED
-			DeleteStateForUser(sm.db, sm.UserID)
+			// weather types and wind directions are for good days only
+			if bookmark := sm.GetUnfinishedBookmark(); bookmark != nil && (bookmark.Mode == ModeNight || bookmark.Polarity == PolarityHazard) {
+				sm.finish()
+				return
+			}
+
+			sm.markNextStepState(StepExcludeWeather)
 
-			// send message and hide keyboard shown on the last step
 			if sm.bot == nil {
+				return // for unit tests
+			}
+
+			if bookmark := sm.GetUnfinishedBookmark(); bookmark != nil {
+				askExcludedWeather(sm.bot, sm.chatID, bookmark)
+			}
+		},
+	},
+
+	StepExcludeWeather: {
+		next: StepWindDirections,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+
+			// categories are toggled by buttons outside of the state machine, here we wait only for "done"
+			if rawMessage != ButtonDone {
+				sendMsg(sm.bot, sm.chatID, "Please tick the weather you want to exclude using the buttons above and click Done")
 				return
 			}
-			msg := tgbotapi.NewMessage(sm.chatID, "All done, this location was saved for you")
-			msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-			sm.bot.Send(msg)
+
+			sm.markNextStepState(StepWindDirections)
+
+			if sm.bot == nil {
+				return // for unit tests
+			}
+
+			if bookmark := sm.GetUnfinishedBookmark(); bookmark != nil {
+				askWindDirections(sm.bot, sm.chatID, bookmark)
+			}
+		},
+	},
+
+	StepWindDirections: {
+		next: FINISHED,
+		fnProcess: func(rawMessage string, sm *StateMachine) {
+
+			// directions are toggled by buttons outside of the state machine, here we wait only for "done"
+			if rawMessage != ButtonDone {
+				sendMsg(sm.bot, sm.chatID, "Please tick the wind directions using the compass above and click Done")
+				return
+			}
+
+			sm.finish()
 		},
 	},
 }
 
-func LoadStateMachineFor(botApi *tgbotapi.BotAPI, chatID int64, userID int, userName string, stormDb *storm.DB) (*StateMachine, error) {
+func LoadStateMachineFor(botApi *tgbotapi.BotAPI, chatID int64, userID int, userName string, repos Repositories) (*StateMachine, error) {
 
 	sm := StateMachine{
 		UserID:   userID,
 		UserName: userName,
-		db:       stormDb,
+		repos:    repos,
 		bot:      botApi,
 		chatID:   chatID,
 	}
@@ -172,7 +532,8 @@ func LoadStateMachineFor(botApi *tgbotapi.BotAPI, chatID int64, userID int, user
 		return nil, err
 	}
 
-	sm.currentState = currState
+	sm.currentState = currState.CurrentState
+	sm.bookmarkID = currState.BookmarkID
 	return &sm, nil
 }
 
@@ -183,16 +544,191 @@ func (sm *StateMachine) ProcessNextState(rawMessage string) {
 	state.fnProcess(rawMessage, sm)
 }
 
-func (sm *StateMachine) markNextStepState(newState int) error {
+// parses the location picked from the inline query, and complains if it is something else
+func (sm *StateMachine) parseLocationID(rawMessage string) (string, bool) {
+	if !strings.HasPrefix(rawMessage, LocationIDPrefix) {
+		sendMsg(sm.bot, sm.chatID, "Error, wrong location ID format")
+		return "", false
+	}
 
-	// get current state
-	var currState structs.UserState
-	if err := sm.db.One("UserID", sm.UserID, &currState); err != nil {
-		return err
+	locaIDClean := strings.TrimPrefix(rawMessage, LocationIDPrefix)
+	if _, err := strconv.Atoi(locaIDClean); err != nil {
+		sendMsg(sm.bot, sm.chatID, fmt.Sprintf("hey, %s is not a valid location! Please pick a location from the suggestions", rawMessage))
+		return "", false
+	}
+	return locaIDClean, true
+}
+
+// asks which activity the location is for, so thresholds are taken from its profile
+func (sm *StateMachine) askProfile() {
+	if sm.bot == nil {
+		return // for unit tests
+	}
+
+	msg, err := sendMsg(sm.bot, sm.chatID, "Ok, let's add a location where you want to monitor a weather. "+
+		"What are you going to do there? Pick an activity and I'll use typical limits for it, or choose Custom to enter your own numbers")
+	if err != nil {
+		return
+	}
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(sm.chatID, msg.MessageID, renderProfilesKeyboard())
+	sm.bot.Send(keyboardMsg)
+}
+
+// asks to pick the location using the inline query
+func (sm *StateMachine) askLocation() {
+	if sm.bot == nil {
+		return // for unit tests
+	}
+
+	resp, _ := sendMsg(sm.bot, sm.chatID, "Now choose the location. "+
+		"Start typing name following by the bot name and suggestions will appear. \n"+
+		"Example: @WeatherObserverBot London \n\n Or, click the button below")
+
+	renderButtonThatOpensInlineQuery(sm.bot, sm.chatID, resp.MessageID)
+}
+
+// tells how many places are in the group already, and asks to pick one more or click Done
+func (sm *StateMachine) askMoreGroupMembers(bookmark *structs.UsersLocationBookmark) {
+	if sm.bot == nil {
+		return // for unit tests
+	}
+
+	msg, err := sendMsg(sm.bot, sm.chatID, fmt.Sprintf("📍 Added, there are %d places in %s now. Pick one more, or click Done",
+		len(bookmark.GroupLocationIDs), escapeMarkdown(bookmark.GroupName)))
+	if err != nil {
+		return
+	}
+
+	keyboard := newInlineKeyboard([]tgbotapi.InlineKeyboardButton{
+		*renderKeyboardButtonActivateQuery(" 🔍 Search for location"),
+		tgbotapi.NewInlineKeyboardButtonData("✅ Done", callbackData(ButtonWizardAnswer, ButtonDone)),
+	})
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(sm.chatID, msg.MessageID, keyboard)
+	sm.bot.Send(keyboardMsg)
+}
+
+// asks what to look for: good days, clear nights or bad weather
+func (sm *StateMachine) askMode() {
+	if sm.bot == nil {
+		return // for unit tests
+	}
+
+	msg, err := sendMsg(sm.bot, sm.chatID, "What are you looking for in this place: good days for outdoor activities, "+
+		"clear nights for stargazing, or warnings about bad weather?")
+	if err != nil {
+		return
+	}
+
+	rowButtons := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("☀ Good days", callbackData(ButtonWizardAnswer, strconv.Itoa(ModeDay))),
+		tgbotapi.NewInlineKeyboardButtonData("🌌 Clear nights", callbackData(ButtonWizardAnswer, strconv.Itoa(ModeNight))),
+	}
+	rowWarnings := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("⚠ Bad weather warnings", callbackData(ButtonWizardAnswer, answerHazards)),
+	}
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(sm.chatID, msg.MessageID, newInlineKeyboard(rowButtons, rowWarnings))
+	sm.bot.Send(keyboardMsg)
+}
+
+// asks what days to observe: only weekends or all days
+func (sm *StateMachine) askDays() {
+	if sm.bot == nil {
+		return // for unit tests
+	}
+
+	msg, _ := sendMsg(sm.bot, sm.chatID, "Got it. Now, what days do you want to observe? \n"+
+		" - only weekend (makes sense if you at work during weekdays) \n"+
+		" - all days (when you have a vacation or you have flexible time schedule)?")
+
+	rowButtons := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("Only Weekend", callbackData(ButtonChoiceAllDaysOrWeekends, strconv.Itoa(OnlyWeekends))),
+		tgbotapi.NewInlineKeyboardButtonData("All days", callbackData(ButtonChoiceAllDaysOrWeekends, strconv.Itoa(AllDays))),
+	}
+
+	keyboard := newInlineKeyboard(rowButtons)
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(sm.chatID, msg.MessageID, keyboard)
+	sm.bot.Send(keyboardMsg)
+}
+
+// asks the minimum visibility, every button is one level of the scale
+func (sm *StateMachine) askMinVisibility() {
+	if sm.bot == nil {
+		return // for unit tests
 	}
 
-	// and update its value
-	if err := sm.db.UpdateField(&currState, "CurrentState", newState); err != nil {
+	msg, err := sendMsg(sm.bot, sm.chatID, "Photographers and pilots care about visibility. What is the minimum acceptable for you?")
+	if err != nil {
+		return
+	}
+
+	var buttonRows [][]tgbotapi.InlineKeyboardButton
+	for _, code := range []string{"MO", "GO", "VG", "EX"} {
+		buttonRows = append(buttonRows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(visibilityNames[code]+" or better", callbackData(ButtonWizardAnswer, code)),
+		})
+	}
+	buttonRows = append(buttonRows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("⏭ Any, skip", callbackData(ButtonWizardAnswer, ButtonSkip)),
+	})
+
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(sm.chatID, msg.MessageID, newInlineKeyboard(buttonRows...))
+	sm.bot.Send(keyboardMsg)
+}
+
+// sends the question of an optional step, with the button "Skip"
+func (sm *StateMachine) askOptional(question string) {
+	msg, err := sendMsg(sm.bot, sm.chatID, question)
+	if err != nil {
+		return
+	}
+
+	keyboard := newInlineKeyboard([]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("⏭ Skip", callbackData(ButtonWizardAnswer, ButtonSkip)),
+	})
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(sm.chatID, msg.MessageID, keyboard)
+	sm.bot.Send(keyboardMsg)
+}
+
+// parses the answer of an optional step: a number, or nil if user skipped the step.
+// Returns false if the answer is neither of them
+func parseOptionalNumber(rawMessage string) (*int, bool) {
+	if rawMessage == ButtonSkip {
+		return nil, true
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(rawMessage))
+	if err != nil {
+		return nil, false
+	}
+	return &value, true
+}
+
+// marks the bookmark as ready and forgets the state, so the bot starts checking weather for it
+func (sm *StateMachine) finish() {
+	if bookmark := sm.GetUnfinishedBookmark(); bookmark != nil {
+		if err := sm.repos.Bookmarks.UpdateField(bookmark, "IsReady", true); err == nil {
+			RecordEvent(sm.repos, structs.Event{Type: EventBookmarkCreated, UserID: sm.UserID, BookmarkID: bookmark.ID,
+				LocationID: bookmark.LocationID, Details: bookmark.GroupName})
+		}
+	}
+
+	sm.currentState = FINISHED
+	sm.repos.States.DeleteState(sm.UserID)
+
+	// send message and hide keyboard shown on the last step
+	if sm.bot == nil {
+		return
+	}
+	msg := tgbotapi.NewMessage(sm.chatID, "All done, this location was saved for you")
+	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	sm.bot.Send(msg)
+}
+
+func (sm *StateMachine) markNextStepState(newState int) error {
+
+	if err := sm.repos.States.UpdateCurrentState(sm.UserID, newState); err != nil {
 		return err
 	}
 
@@ -200,22 +736,22 @@ func (sm *StateMachine) markNextStepState(newState int) error {
 	return nil
 }
 
-func (sm *StateMachine) loadState(userID int) (int, error) {
+func (sm *StateMachine) loadState(userID int) (structs.UserState, error) {
 
 	// load state from DB
-	var state structs.UserState
-	if err := sm.db.One("UserID", userID, &state); err != nil {
+	state, err := sm.repos.States.GetState(userID)
+	if err != nil {
 
 		// existing state is not found, create a new one
 		state = structs.UserState{
 			UserID:       userID,
 			CurrentState: StepEnterLocation,
 		}
-		if err := sm.db.Save(&state); err != nil {
+		if err := sm.repos.States.SaveState(&state); err != nil {
 			sentry.CaptureException(errors.Wrap(err, "attempt to create a new state and persist it to the database"))
-			return -1, err
+			return state, err
 		}
 	}
 
-	return state.CurrentState, nil
+	return state, nil
 }
@@ -1,32 +1,28 @@
 package command
 
 import (
-	"github.com/asdine/storm"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/stretchr/testify/assert"
 	"github.com/w32blaster/bot-weather-watcher/structs"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"strconv"
 	"testing"
 )
 
 const (
-	TestLocationID = "111"
-	UserID         = 111
-	User2ID        = 222
-	UserName       = "username"
+	TestLocationID  = "111"
+	TestLocation2ID = "222"
+	UserID          = 111
+	User2ID         = 222
+	UserName        = "username"
 )
 
 func TestStateMachineStepByStep(t *testing.T) {
 
 	// Given:
-	dir, db := prepareDB()
-	defer os.RemoveAll(dir)
-	defer db.Close()
+	repos := prepareRepositories()
 
 	// When:
-	sm, err := LoadStateMachineFor(nil, 1, UserID, UserName, db)
+	sm, err := LoadStateMachineFor(nil, 1, UserID, UserName, repos)
 	assert.Nil(t, err)
 	assert.NotNil(t, sm)
 
@@ -42,6 +38,12 @@ func TestStateMachineStepByStep(t *testing.T) {
 	// When:
 	sm.ProcessNextState(LocationIDPrefix + TestLocationID)
 
+	// then:
+	assert.Equal(t, StepChooseMode, sm.currentState)
+
+	// When:
+	sm.ProcessNextState(strconv.Itoa(ModeDay))
+
 	// then:
 	assert.Equal(t, StepEnterMaxWindSpeed, sm.currentState)
 
@@ -49,6 +51,12 @@ func TestStateMachineStepByStep(t *testing.T) {
 	// When:
 	sm.ProcessNextState("20")
 
+	// then:
+	assert.Equal(t, StepEnterMaxGust, sm.currentState)
+
+	// When:
+	sm.ProcessNextState("25")
+
 	// then:
 	assert.Equal(t, StepEnterMinTemp, sm.currentState)
 
@@ -56,19 +64,74 @@ func TestStateMachineStepByStep(t *testing.T) {
 	// When:
 	sm.ProcessNextState("10")
 
+	// then:
+	assert.Equal(t, StepEnterMaxTemp, sm.currentState)
+
+	// When:
+	sm.ProcessNextState("too hot") // wrong value, nothing changes
+
+	// then:
+	assert.Equal(t, StepEnterMaxTemp, sm.currentState)
+
+	// When:
+	sm.ProcessNextState(ButtonSkip)
+
+	// then:
+	assert.Equal(t, StepEnterMaxHumidity, sm.currentState)
+
+	// When:
+	sm.ProcessNextState("70")
+
+	// then:
+	assert.Equal(t, StepEnterMaxUV, sm.currentState)
+
+	// When:
+	sm.ProcessNextState(ButtonSkip)
+
+	// then:
+	assert.Equal(t, StepEnterUVWarning, sm.currentState)
+
+	// When:
+	sm.ProcessNextState("6")
+
+	// then:
+	assert.Equal(t, StepEnterMinVisibility, sm.currentState)
+
+	// When:
+	sm.ProcessNextState("GO")
+
 	// then:
 	assert.Equal(t, StepSpecifyDays, sm.currentState)
 
 	// Step 5
 	// When:
-	sm.ProcessNextState(strconv.Itoa(onlyWeekends))
+	sm.ProcessNextState(strconv.Itoa(OnlyWeekends))
+
+	// then:
+	assert.Equal(t, StepExcludeWeather, sm.currentState)
+
+	// Step 6
+	// When:
+	sm.ProcessNextState("random text") // categories are chosen by buttons, so the text is ignored
+
+	// then:
+	assert.Equal(t, StepExcludeWeather, sm.currentState)
+
+	// When:
+	sm.ProcessNextState(ButtonDone)
+
+	// then:
+	assert.Equal(t, StepWindDirections, sm.currentState)
+
+	// Step 7
+	// When:
+	sm.ProcessNextState(ButtonDone)
 
 	// then:
 	assert.Equal(t, FINISHED, sm.currentState)
 
 	// make sure we have only one saved (bookmarked) location in the database
-	var bookmarks []structs.UsersLocationBookmark
-	assert.Nil(t, db.All(&bookmarks))
+	bookmarks := allBookmarks(t, repos)
 	assert.Equal(t, 1, len(bookmarks))
 
 	// and the bookmark contains valid information
@@ -77,53 +140,312 @@ func TestStateMachineStepByStep(t *testing.T) {
 	assert.Equal(t, 20, bookmarks[0].MaxWindSpeed)
 	assert.Equal(t, 10, bookmarks[0].LowestTemp)
 	assert.True(t, bookmarks[0].IsReady)
+
+	// optional bounds are saved only if user entered them
+	assert.Equal(t, 25, *bookmarks[0].MaxGust)
+	assert.Nil(t, bookmarks[0].MaxTemp)
+	assert.Equal(t, 70, *bookmarks[0].MaxHumidity)
+	assert.Nil(t, bookmarks[0].MaxUV)
+	assert.Equal(t, 6, *bookmarks[0].UVWarnLevel)
+	assert.Equal(t, 4, bookmarks[0].MinVisibility)
+}
+
+// scenario: the bookmark looks for clear nights, so day questions are not asked
+func TestStateMachineNightMode(t *testing.T) {
+
+	// Given:
+	repos := prepareRepositories()
+
+	sm, _ := LoadStateMachineFor(nil, 1, UserID, UserName, repos)
+	sm.CreateNewBookmark(-1)
+	sm.ProcessNextState(LocationIDPrefix + TestLocationID)
+	assert.Equal(t, StepChooseMode, sm.currentState)
+
+	// When:
+	sm.ProcessNextState("3") // no such mode, nothing changes
+
+	// then:
+	assert.Equal(t, StepChooseMode, sm.currentState)
+
+	// When:
+	sm.ProcessNextState(strconv.Itoa(ModeNight))
+	sm.ProcessNextState("15")
+
+	// then:
+	assert.Equal(t, StepEnterMinNightTemp, sm.currentState)
+
+	// When:
+	sm.ProcessNextState("-2")
+
+	// then:
+	assert.Equal(t, StepSpecifyDays, sm.currentState)
+
+	// When:
+	sm.ProcessNextState(strconv.Itoa(AllDays))
+
+	// then:
+	assert.Equal(t, FINISHED, sm.currentState)
+
+	bookmarks := allBookmarks(t, repos)
+	assert.Equal(t, 1, len(bookmarks))
+	assert.Equal(t, ModeNight, bookmarks[0].Mode)
+	assert.Equal(t, 15, bookmarks[0].MaxWindSpeed)
+	assert.Equal(t, -2, *bookmarks[0].MinNightTemp)
+	assert.Nil(t, bookmarks[0].MaxGust)
+	assert.True(t, bookmarks[0].IsReady)
+}
+
+// scenario: user picks the hiking profile, so only the location is asked
+func TestStateMachineWithProfile(t *testing.T) {
+
+	// Given:
+	repos := prepareRepositories()
+
+	sm, _ := LoadStateMachineFor(nil, 1, UserID, UserName, repos)
+	sm.CreateNewBookmark(-1)
+	sm.markNextStepState(StepChooseProfile)
+
+	// When:
+	sm.ProcessNextState("no-such-profile")
+
+	// then:
+	assert.Equal(t, StepChooseProfile, sm.currentState)
+
+	// When:
+	sm.ProcessNextState("hiking")
+
+	// then:
+	assert.Equal(t, StepEnterLocation, sm.currentState)
+
+	// When:
+	sm.ProcessNextState(LocationIDPrefix + TestLocationID)
+
+	// then:
+	assert.Equal(t, FINISHED, sm.currentState)
+
+	bookmarks := allBookmarks(t, repos)
+	assert.Equal(t, 1, len(bookmarks))
+	assert.Equal(t, "hiking", bookmarks[0].Profile)
+	assert.Equal(t, TestLocationID, bookmarks[0].LocationID)
+	assert.Equal(t, 25, bookmarks[0].MaxWindSpeed)
+	assert.Equal(t, 40, *bookmarks[0].MaxGust)
+	assert.Equal(t, OnlyWeekends, bookmarks[0].CheckPeriod)
+	assert.True(t, bookmarks[0].IsReady)
+}
+
+// scenario: user chooses custom profile and answers all the questions
+func TestStateMachineWithCustomProfile(t *testing.T) {
+
+	// Given:
+	repos := prepareRepositories()
+
+	sm, _ := LoadStateMachineFor(nil, 1, UserID, UserName, repos)
+	sm.CreateNewBookmark(-1)
+	sm.markNextStepState(StepChooseProfile)
+
+	// When:
+	sm.ProcessNextState(answerCustomProfile)
+	sm.ProcessNextState(LocationIDPrefix + TestLocationID)
+
+	// then:
+	assert.Equal(t, StepChooseMode, sm.currentState)
+	assert.Empty(t, sm.GetUnfinishedBookmark().Profile)
+}
+
+// scenario: user wants to be warned about frost and gales
+func TestStateMachineHazardWarnings(t *testing.T) {
+
+	// Given:
+	repos := prepareRepositories()
+
+	sm, _ := LoadStateMachineFor(nil, 1, UserID, UserName, repos)
+	sm.CreateNewBookmark(-1)
+	sm.ProcessNextState(LocationIDPrefix + TestLocationID)
+
+	// When:
+	sm.ProcessNextState(answerHazards)
+	sm.ProcessNextState(ButtonDone) // nothing is ticked yet
+
+	// then:
+	assert.Equal(t, StepChooseHazards, sm.currentState)
+
+	// When:
+	sm.UpdateFieldInBookmark("Hazards", hazardFrost|hazardGale) // the same as toggle buttons do
+	sm.ProcessNextState(ButtonDone)
+
+	// then:
+	assert.Equal(t, StepSpecifyDays, sm.currentState)
+
+	// When:
+	sm.ProcessNextState(strconv.Itoa(AllDays))
+
+	// then:
+	assert.Equal(t, FINISHED, sm.currentState)
+
+	bookmarks := allBookmarks(t, repos)
+	assert.Equal(t, 1, len(bookmarks))
+	assert.Equal(t, PolarityHazard, bookmarks[0].Polarity)
+	assert.Equal(t, hazardFrost|hazardGale, bookmarks[0].Hazards)
+	assert.Equal(t, AllDays, bookmarks[0].CheckPeriod)
+	assert.True(t, bookmarks[0].IsReady)
+}
+
+// scenario: user creates a group of two places, and later adds one more
+func TestStateMachineLocationGroup(t *testing.T) {
+
+	// Given:
+	repos := prepareRepositories()
+
+	sm, _ := LoadStateMachineFor(nil, 1, UserID, UserName, repos)
+	sm.CreateNewBookmark(-1)
+	sm.markNextStepState(StepEnterGroupName)
+
+	// When:
+	sm.ProcessNextState("  ")
+
+	// then: the name can't be empty
+	assert.Equal(t, StepEnterGroupName, sm.currentState)
+
+	// When:
+	sm.ProcessNextState("Peak District")
+
+	// then:
+	assert.Equal(t, StepPickGroupLocations, sm.currentState)
+
+	// When:
+	sm.ProcessNextState(LocationIDPrefix + TestLocationID)
+	sm.ProcessNextState(LocationIDPrefix + TestLocationID) // the same place twice is ignored
+	sm.ProcessNextState(ButtonDone)
+
+	// then: one place is not a group yet
+	assert.Equal(t, StepPickGroupLocations, sm.currentState)
+
+	// When:
+	sm.ProcessNextState(LocationIDPrefix + TestLocation2ID)
+	sm.ProcessNextState(ButtonDone)
+
+	// then: the rest of steps are the same as for one place
+	assert.Equal(t, StepChooseMode, sm.currentState)
+
+	bookmark := sm.GetUnfinishedBookmark()
+	assert.Equal(t, "Peak District", bookmark.GroupName)
+	assert.Equal(t, TestLocationID, bookmark.LocationID)
+	assert.Equal(t, []string{TestLocationID, TestLocation2ID}, bookmark.GroupLocationIDs)
+
+	// When: the group is saved and later user adds one more place
+	sm.UpdateFieldInBookmark("IsReady", true)
+	repos.States.DeleteState(UserID)
+	assert.Nil(t, repos.States.SaveState(&structs.UserState{UserID: UserID, CurrentState: StepAddGroupMember, BookmarkID: bookmark.ID}))
+
+	sm, _ = LoadStateMachineFor(nil, 1, UserID, UserName, repos)
+	sm.ProcessNextState(LocationIDPrefix + "333")
+	sm.ProcessNextState(ButtonDone)
+
+	// then:
+	assert.Equal(t, FINISHED, sm.currentState)
+
+	saved, err := repos.Bookmarks.Get(bookmark.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{TestLocationID, TestLocation2ID, "333"}, saved.GroupLocationIDs)
+	assert.True(t, saved.IsReady)
 }
 
 // scenario: two users, two bookmarks
 func TestStateMachineForTwoUsers(t *testing.T) {
 
 	// Given:
-	dir, db := prepareDB()
-	defer os.RemoveAll(dir)
-	defer db.Close()
+	repos := prepareRepositories()
 
 	// State machine flow for user 1
-	sm, _ := LoadStateMachineFor(nil, 0, UserID, UserName, db)
+	sm, _ := LoadStateMachineFor(nil, 0, UserID, UserName, repos)
 	sm.CreateNewBookmark(-1)
 	assert.Equal(t, StepEnterLocation, sm.currentState)
 
 	sm.ProcessNextState(LocationIDPrefix + TestLocationID)
+	assert.Equal(t, StepChooseMode, sm.currentState)
+
+	sm.ProcessNextState(strconv.Itoa(ModeDay))
 	assert.Equal(t, StepEnterMaxWindSpeed, sm.currentState)
 
 	sm.ProcessNextState("20")
+	assert.Equal(t, StepEnterMaxGust, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
 	assert.Equal(t, StepEnterMinTemp, sm.currentState)
 
 	sm.ProcessNextState("10")
+	assert.Equal(t, StepEnterMaxTemp, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterMaxHumidity, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterMaxUV, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterUVWarning, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterMinVisibility, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
 	assert.Equal(t, StepSpecifyDays, sm.currentState)
 
-	sm.ProcessNextState(strconv.Itoa(onlyWeekends))
+	sm.ProcessNextState(strconv.Itoa(OnlyWeekends))
+	assert.Equal(t, StepExcludeWeather, sm.currentState)
+
+	sm.ProcessNextState(ButtonDone)
+	assert.Equal(t, StepWindDirections, sm.currentState)
+
+	sm.ProcessNextState(ButtonDone)
 	assert.Equal(t, FINISHED, sm.currentState)
 
 	// Repeat the same for User 2
-	sm, _ = LoadStateMachineFor(nil, 0, User2ID, UserName, db)
+	sm, _ = LoadStateMachineFor(nil, 0, User2ID, UserName, repos)
 	sm.CreateNewBookmark(-1)
 	assert.Equal(t, StepEnterLocation, sm.currentState)
 
 	sm.ProcessNextState(LocationIDPrefix + TestLocationID)
+	assert.Equal(t, StepChooseMode, sm.currentState)
+
+	sm.ProcessNextState(strconv.Itoa(ModeDay))
 	assert.Equal(t, StepEnterMaxWindSpeed, sm.currentState)
 
 	sm.ProcessNextState("30")
+	assert.Equal(t, StepEnterMaxGust, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
 	assert.Equal(t, StepEnterMinTemp, sm.currentState)
 
 	sm.ProcessNextState("5")
+	assert.Equal(t, StepEnterMaxTemp, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterMaxHumidity, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterMaxUV, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterUVWarning, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterMinVisibility, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
 	assert.Equal(t, StepSpecifyDays, sm.currentState)
 
-	sm.ProcessNextState(strconv.Itoa(onlyWeekends))
+	sm.ProcessNextState(strconv.Itoa(OnlyWeekends))
+	assert.Equal(t, StepExcludeWeather, sm.currentState)
+
+	sm.ProcessNextState(ButtonDone)
+	assert.Equal(t, StepWindDirections, sm.currentState)
+
+	sm.ProcessNextState(ButtonDone)
 	assert.Equal(t, FINISHED, sm.currentState)
 
 	// make sure we have two bookmarks in the database
-	var bookmarks []structs.UsersLocationBookmark
-	assert.Nil(t, db.All(&bookmarks))
+	bookmarks := allBookmarks(t, repos)
 	assert.Equal(t, 2, len(bookmarks))
 
 	// and the bookmark contains valid information
@@ -145,41 +467,68 @@ func TestStateMachineForTwoUsers(t *testing.T) {
 func TestStateMachineForTwoUsersNotFinished(t *testing.T) {
 
 	// Given:
-	dir, db := prepareDB()
-	defer os.RemoveAll(dir)
-	defer db.Close()
+	repos := prepareRepositories()
 
 	// State machine flow for user 1
-	sm, _ := LoadStateMachineFor(nil, 1, UserID, UserName, db)
+	sm, _ := LoadStateMachineFor(nil, 1, UserID, UserName, repos)
 	sm.CreateNewBookmark(-1)
 	assert.Equal(t, StepEnterLocation, sm.currentState)
 
 	sm.ProcessNextState(LocationIDPrefix + TestLocationID)
+	assert.Equal(t, StepChooseMode, sm.currentState)
+
+	sm.ProcessNextState(strconv.Itoa(ModeDay))
 	assert.Equal(t, StepEnterMaxWindSpeed, sm.currentState)
 
 	sm.ProcessNextState("20")
+	assert.Equal(t, StepEnterMaxGust, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
 	assert.Equal(t, StepEnterMinTemp, sm.currentState)
 
 	sm.ProcessNextState("10")
+	assert.Equal(t, StepEnterMaxTemp, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterMaxHumidity, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterMaxUV, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterUVWarning, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
+	assert.Equal(t, StepEnterMinVisibility, sm.currentState)
+
+	sm.ProcessNextState(ButtonSkip)
 	assert.Equal(t, StepSpecifyDays, sm.currentState)
 
-	sm.ProcessNextState(strconv.Itoa(onlyWeekends))
+	sm.ProcessNextState(strconv.Itoa(OnlyWeekends))
+	assert.Equal(t, StepExcludeWeather, sm.currentState)
+
+	sm.ProcessNextState(ButtonDone)
+	assert.Equal(t, StepWindDirections, sm.currentState)
+
+	sm.ProcessNextState(ButtonDone)
 	assert.Equal(t, FINISHED, sm.currentState)
 
 	// Repeat the same for User 2
-	sm, _ = LoadStateMachineFor(nil, 1, User2ID, UserName, db)
+	sm, _ = LoadStateMachineFor(nil, 1, User2ID, UserName, repos)
 	sm.CreateNewBookmark(-1)
 	assert.Equal(t, StepEnterLocation, sm.currentState)
 
 	sm.ProcessNextState(LocationIDPrefix + TestLocationID)
+	assert.Equal(t, StepChooseMode, sm.currentState)
+
+	sm.ProcessNextState(strconv.Itoa(ModeDay))
 	assert.Equal(t, StepEnterMaxWindSpeed, sm.currentState)
 
 	sm.ProcessNextState("30")
-	assert.Equal(t, StepEnterMinTemp, sm.currentState)
+	assert.Equal(t, StepEnterMaxGust, sm.currentState)
 
 	// make sure we have two bookmarks in the database
-	var bookmarks []structs.UsersLocationBookmark
-	assert.Nil(t, db.All(&bookmarks))
+	bookmarks := allBookmarks(t, repos)
 	assert.Equal(t, 2, len(bookmarks))
 
 	// but the first bookmark is ready
@@ -197,20 +546,65 @@ func TestStateMachineForTwoUsersNotFinished(t *testing.T) {
 	assert.False(t, bookmarks[1].IsReady)       // <- False!
 }
 
-func prepareDB() (string, *storm.DB) {
-	dir, _ := ioutil.TempDir(os.TempDir(), "storm")
-	db, _ := storm.Open(filepath.Join(dir, "storm.db"))
-
-	db.Save(&structs.SiteLocation{
-		ID:           TestLocationID,
-		Elevation:    "10.0",
-		Latitude:     "10.0",
-		Longitude:    "20.0",
-		Name:         "London",
-		Region:       "SW1",
-		AuthArea:     "Some testing area",
-		NationalPark: "",
-	})
-
-	return dir, db
+// the repositories kept in memory, with two sites to pick and one more to add to a group later
+func prepareRepositories() Repositories {
+	return NewMemoryRepositories(
+		structs.SiteLocation{
+			ID:           TestLocationID,
+			Elevation:    "10.0",
+			Latitude:     "10.0",
+			Longitude:    "20.0",
+			Name:         "London",
+			Region:       "SW1",
+			AuthArea:     "Some testing area",
+			NationalPark: "",
+		},
+		structs.SiteLocation{
+			ID:        TestLocation2ID,
+			Elevation: "20.0",
+			Latitude:  "11.0",
+			Longitude: "21.0",
+			Name:      "Richmond",
+			Region:    "SW14",
+			AuthArea:  "Another testing area",
+		},
+		structs.SiteLocation{ID: "333", Name: "Buxton"},
+	)
+}
+
+// all the saved bookmarks of both testing users, in the order they were created
+func allBookmarks(t *testing.T, repos Repositories) []structs.UsersLocationBookmark {
+	bookmarks, err := repos.Bookmarks.FindByUser(UserID)
+	assert.Nil(t, err)
+
+	others, err := repos.Bookmarks.FindByUser(User2ID)
+	assert.Nil(t, err)
+
+	return append(bookmarks, others...)
+}
+
+func TestPlainTextWithoutWizard(t *testing.T) {
+
+	// Given: the wizard was cancelled by the janitor
+	repos := prepareRepositories()
+	app := &App{Repos: repos}
+	message := &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}, From: &tgbotapi.User{ID: UserID, UserName: UserName}, Text: "20"}
+
+	// When: user answers it later
+	ProcessPlainText(app, message)
+
+	// Then: the text doesn't start a new wizard
+	_, err := repos.States.GetState(UserID)
+	assert.Equal(t, ErrNotFound, err)
+	bookmarks, _ := repos.Bookmarks.FindByUser(UserID)
+	assert.Empty(t, bookmarks)
+
+	// and when: the wizard is started with the command
+	assert.Nil(t, repos.States.SaveState(&structs.UserState{UserID: UserID, CurrentState: StepEnterMaxWindSpeed}))
+	ProcessPlainText(app, message)
+
+	// Then: the text is the answer
+	state, err := repos.States.GetState(UserID)
+	assert.Nil(t, err)
+	assert.NotEqual(t, StepEnterMaxWindSpeed, state.CurrentState)
 }
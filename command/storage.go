@@ -0,0 +1,167 @@
+package command
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/asdine/storm"
+	"github.com/pkg/errors"
+)
+
+// the values of structs.Opts.Storage
+const (
+	StorageStorm  = "storm"
+	StorageSQLite = "sqlite"
+)
+
+// CopyStats is the number of records copied by CopyToSQLite
+type CopyStats struct {
+	Sites     int
+	Bookmarks int
+	States    int
+	Settings  int
+	Audit     int
+	Events    int
+}
+
+// CopyToSQLite copies everything from the storm database to the SQLite one, that should have no users data yet.
+// The storm database is migrated first, so the data has the meaning of the current structs. IDs are kept, so
+// the states still point to their bookmarks
+func CopyToSQLite(src *storm.DB, dst *sql.DB) (CopyStats, error) {
+	var stats CopyStats
+
+	if _, err := Migrate(src); err != nil {
+		return stats, err
+	}
+
+	for _, table := range []string{"bookmarks", "user_states", "user_settings", "audit", "events"} {
+		var count int
+		if err := dst.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			return stats, err
+		}
+		if count > 0 {
+			return stats, errors.New("the SQLite database is not empty, please copy to a new one")
+		}
+	}
+
+	var sites []structs.SiteLocation
+	var bookmarks []structs.UsersLocationBookmark
+	var states []structs.UserState
+	var settings []structs.UserSettings
+	var audit []structs.AuditEntry
+	var events []structs.Event
+	for _, records := range []interface{}{&sites, &bookmarks, &states, &settings, &audit, &events} {
+		if err := src.All(records); err != nil {
+			return stats, err
+		}
+	}
+
+	if err := saveSQLiteSites(dst, sites); err != nil {
+		return stats, err
+	}
+
+	err := withSQLiteTx(dst, func(tx *sql.Tx) error {
+		for i := range bookmarks {
+			if err := saveSQLiteBookmark(tx, &bookmarks[i]); err != nil {
+				return errors.Wrapf(err, "can't copy the bookmark %d", bookmarks[i].ID)
+			}
+		}
+		for _, state := range states {
+			_, err := sqliteUpsert(tx, "user_states", stateColumns, state.ID, state.UserID, state.CurrentState, state.BookmarkID,
+				state.UpdatedAt.UTC().Format(sqliteTimeLayout))
+			if err != nil {
+				return errors.Wrapf(err, "can't copy the state %d", state.ID)
+			}
+		}
+		for _, s := range settings {
+			if _, err := sqliteUpsert(tx, "user_settings", settingsColumns, s.ID, s.UserID, s.DigestGroup); err != nil {
+				return errors.Wrapf(err, "can't copy the settings %d", s.ID)
+			}
+		}
+		for _, entry := range audit {
+			_, err := sqliteUpsert(tx, "audit", auditColumns, entry.ID, entry.Action, entry.Time.UTC().Format(sqliteTimeLayout),
+				entry.Bookmarks, entry.States, entry.Settings, entry.Events)
+			if err != nil {
+				return errors.Wrapf(err, "can't copy the audit entry %d", entry.ID)
+			}
+		}
+		for i := range events {
+			if err := saveSQLiteEvent(tx, &events[i]); err != nil {
+				return errors.Wrapf(err, "can't copy the event %d", events[i].ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	return CopyStats{Sites: len(sites), Bookmarks: len(bookmarks), States: len(states), Settings: len(settings),
+		Audit: len(audit), Events: len(events)}, nil
+}
+
+// BackupSQLite saves the copy of the SQLite database to the directory as "weather-<time>.sqlite" and removes old
+// ones, so only the last keep backups stay. Returns the path of the new backup
+func BackupSQLite(db *sql.DB, dir string, keep int, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// VACUUM INTO writes a consistent copy while the bot keeps working, but needs a file that doesn't exist yet
+	path := filepath.Join(dir, backupPrefix+now.UTC().Format(backupTimeLayout)+".sqlite")
+	tmp := filepath.Join(dir, "backup-"+now.UTC().Format(backupTimeLayout)+".tmp")
+	os.Remove(tmp)
+	defer os.Remove(tmp)
+
+	if _, err := db.Exec("VACUUM INTO ?", tmp); err != nil {
+		return "", errors.Wrap(err, "can't write the copy")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+
+	return path, rotateBackups(dir, ".sqlite", keep)
+}
+
+// ExportSQLite writes the same JSON export as Export does. The SQLite database is copied from the migrated storm
+// one, so its data has the meaning of the latest storm schema version
+func ExportSQLite(db *sql.DB, w io.Writer, now time.Time) error {
+	export := DatabaseExport{SchemaVersion: migrations[len(migrations)-1].Version, ExportedAt: now.UTC()}
+
+	var err error
+	if export.Bookmarks, err = findSQLiteBookmarks(db, "1"); err != nil {
+		return err
+	}
+	if export.States, err = findSQLiteStates(db, "1"); err != nil {
+		return err
+	}
+	if export.Audit, err = findSQLiteAudit(db, "1"); err != nil {
+		return err
+	}
+	if export.Events, err = findSQLiteEvents(db, "SELECT "+eventColumns+" FROM events ORDER BY id"); err != nil {
+		return err
+	}
+
+	rows, err := db.Query("SELECT " + settingsColumns + " FROM user_settings ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var settings structs.UserSettings
+		if err := rows.Scan(&settings.ID, &settings.UserID, &settings.DigestGroup); err != nil {
+			return err
+		}
+		export.Settings = append(export.Settings, settings)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return writeExport(w, export)
+}
@@ -0,0 +1,227 @@
+package command
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w32blaster/bot-weather-watcher/structs"
+)
+
+func TestCopyToSQLite(t *testing.T) {
+
+	// Given: the storm database with a group getting new members and a removed user
+	dir, src := prepareBackupDB(t)
+	defer os.RemoveAll(dir)
+	defer src.Close()
+
+	repos := NewStormRepositories(src)
+	bookmarks, _ := repos.Bookmarks.FindByUser(UserID)
+	assert.Nil(t, repos.States.SaveState(&structs.UserState{UserID: UserID, CurrentState: StepAddGroupMember, BookmarkID: bookmarks[1].ID}))
+	assert.Nil(t, repos.Audit.Record(&structs.AuditEntry{Action: auditForgetMe, Time: time.Date(2019, 11, 8, 10, 0, 0, 0, time.UTC), Bookmarks: 3}))
+
+	dst := prepareSQLiteDB(t, dir)
+	defer dst.Close()
+
+	// When:
+	stats, err := CopyToSQLite(src, dst)
+
+	// Then:
+	assert.Nil(t, err)
+	assert.Equal(t, CopyStats{Sites: 1, Bookmarks: 2, States: 1, Settings: 1, Audit: 1}, stats)
+
+	copied := NewSQLiteRepositories(dst)
+	copiedBookmarks, err := copied.Bookmarks.FindByUser(UserID)
+	assert.Nil(t, err)
+	assert.Equal(t, bookmarks, copiedBookmarks)
+
+	state, err := copied.States.GetState(UserID)
+	assert.Nil(t, err)
+	assert.Equal(t, bookmarks[1].ID, state.BookmarkID)
+	assert.Equal(t, groupByLocation, GetUserSettings(copied.States, UserID).DigestGroup)
+
+	entries, _ := copied.Audit.FindByAction(auditForgetMe)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "2019-11-08T10:00:00Z", entries[0].Time.Format(time.RFC3339))
+
+	site, err := copied.Sites.Get(TestLocationID)
+	assert.Nil(t, err)
+	assert.Equal(t, "London", site.Name)
+
+	// and new bookmarks don't take the copied IDs
+	bookmark := structs.UsersLocationBookmark{UserID: User2ID, LocationID: TestLocationID}
+	assert.Nil(t, copied.Bookmarks.Save(&bookmark))
+	assert.True(t, bookmark.ID > bookmarks[1].ID)
+
+	// and when: it is copied again
+	_, err = CopyToSQLite(src, dst)
+
+	// Then: nothing is overwritten
+	assert.NotNil(t, err)
+}
+
+func TestOpenSQLiteTwice(t *testing.T) {
+
+	// Given:
+	dir, _ := ioutil.TempDir(os.TempDir(), "sqlite")
+	defer os.RemoveAll(dir)
+
+	db := prepareSQLiteDB(t, dir)
+	bookmark := structs.UsersLocationBookmark{UserID: UserID, LocationID: TestLocationID}
+	assert.Nil(t, NewSQLiteRepositories(db).Bookmarks.Save(&bookmark))
+	db.Close()
+
+	// When: the tables exist already
+	db = prepareSQLiteDB(t, dir)
+	defer db.Close()
+
+	// Then: they are kept
+	var version int
+	assert.Nil(t, db.QueryRow("PRAGMA user_version").Scan(&version))
+	assert.Equal(t, len(sqliteMigrations), version)
+
+	_, err := NewSQLiteRepositories(db).Bookmarks.Get(bookmark.ID)
+	assert.Nil(t, err)
+}
+
+func TestBackupSQLite(t *testing.T) {
+
+	// Given:
+	dir, _ := ioutil.TempDir(os.TempDir(), "sqlite")
+	defer os.RemoveAll(dir)
+
+	db := prepareSQLiteDB(t, dir)
+	defer db.Close()
+	assert.Nil(t, NewSQLiteRepositories(db).Bookmarks.Save(&structs.UsersLocationBookmark{UserID: UserID, LocationID: TestLocationID}))
+
+	backupDir := filepath.Join(dir, "backups")
+	start := time.Date(2019, 11, 8, 0, 40, 0, 0, time.UTC)
+
+	// When: three nightly backups, but only two are kept
+	var path string
+	for day := 0; day < 3; day++ {
+		var err error
+		path, err = BackupSQLite(db, backupDir, 2, start.AddDate(0, 0, day))
+		assert.Nil(t, err)
+	}
+
+	// Then:
+	files, _ := ioutil.ReadDir(backupDir)
+	assert.Equal(t, 2, len(files))
+	assert.Equal(t, "weather-20191109-004000.sqlite", files[0].Name())
+	assert.Equal(t, "weather-20191110-004000.sqlite", files[1].Name())
+
+	// and the backup is the database itself
+	backup, err := OpenSQLite(path)
+	assert.Nil(t, err)
+	defer backup.Close()
+
+	bookmarks, _ := NewSQLiteRepositories(backup).Bookmarks.FindByUser(UserID)
+	assert.Equal(t, 1, len(bookmarks))
+}
+
+func TestExportSQLite(t *testing.T) {
+
+	// Given: the SQLite database copied from the storm one, where user is changing a group
+	dir, src := prepareBackupDB(t)
+	defer os.RemoveAll(dir)
+	defer src.Close()
+
+	repos := NewStormRepositories(src)
+	bookmarks, _ := repos.Bookmarks.FindByUser(UserID)
+	assert.Nil(t, repos.States.SaveState(&structs.UserState{UserID: UserID, CurrentState: StepAddGroupMember, BookmarkID: bookmarks[1].ID}))
+	assert.Nil(t, repos.Audit.Record(&structs.AuditEntry{Action: auditForgetMe, Time: time.Now().UTC(), Bookmarks: 3}))
+	assert.Nil(t, repos.Events.Record(&structs.Event{Type: EventAlertSent, Time: time.Now().UTC(), UserID: UserID, BookmarkID: bookmarks[1].ID}))
+
+	db := prepareSQLiteDB(t, dir)
+	defer db.Close()
+	_, err := CopyToSQLite(src, db)
+	assert.Nil(t, err)
+
+	defer func(path string) { SiteListPath = path }(SiteListPath)
+	SiteListPath = filepath.Join(dir, "site-list.json")
+	ioutil.WriteFile(SiteListPath, []byte(`{"Locations": {"Location": [{"id": "111", "name": "London"}]}}`), 0644)
+
+	// When:
+	exportPath := filepath.Join(dir, "export.json")
+	f, _ := os.Create(exportPath)
+	assert.Nil(t, ExportSQLite(db, f, time.Now()))
+	f.Close()
+
+	// Then: it is restored like the export of storm
+	restoredPath := filepath.Join(dir, "restored.db")
+	assert.Nil(t, Restore(exportPath, restoredPath))
+
+	restored, err := OpenDB(restoredPath)
+	assert.Nil(t, err)
+	defer restored.Close()
+	restoredRepos := NewStormRepositories(restored)
+
+	restoredBookmarks, _ := restoredRepos.Bookmarks.FindByUser(UserID)
+	assert.Equal(t, 2, len(restoredBookmarks))
+	assert.Equal(t, bookmarks[1].GroupLocationIDs, restoredBookmarks[1].GroupLocationIDs)
+
+	state, err := restoredRepos.States.GetState(UserID)
+	assert.Nil(t, err)
+	assert.Equal(t, restoredBookmarks[1].ID, state.BookmarkID)
+	assert.Equal(t, groupByLocation, GetUserSettings(restoredRepos.States, UserID).DigestGroup)
+
+	entries, err := restoredRepos.Audit.FindByAction(auditForgetMe)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+
+	events, err := restoredRepos.Events.Find(EventFilter{Type: EventAlertSent})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, restoredBookmarks[1].ID, events[0].BookmarkID)
+
+	// and no migration is applied again
+	pending, err := PendingMigrations(restored)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(pending))
+}
+
+func TestNewAppWithSQLite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("imports the whole site list")
+	}
+
+	// Given:
+	dir, _ := ioutil.TempDir(os.TempDir(), "sqlite")
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { SiteListPath = path }(SiteListPath)
+	SiteListPath = filepath.Join("..", SiteListPath)
+
+	opts := &structs.Opts{Storage: StorageSQLite, SQLitePath: filepath.Join(dir, "weather.sqlite")}
+
+	// When:
+	app, err := NewApp(nil, opts, filepath.Join(dir, "weather.db"))
+
+	// Then: the sites are imported, but the storm database is not created
+	assert.Nil(t, err)
+	defer app.Close()
+
+	assert.Nil(t, app.DB)
+	site, err := app.Repos.Sites.Get("3066")
+	assert.Nil(t, err)
+	assert.Equal(t, "Kinloss", site.Name)
+	assert.True(t, app.Sites.Size() > 5000)
+
+	_, err = os.Stat(filepath.Join(dir, "weather.db"))
+	assert.True(t, os.IsNotExist(err))
+
+	// and the unknown storage
+	_, err = NewApp(nil, &structs.Opts{Storage: "mysql"}, filepath.Join(dir, "weather.db"))
+	assert.NotNil(t, err)
+}
+
+func prepareSQLiteDB(t *testing.T, dir string) *sql.DB {
+	db, err := OpenSQLite(filepath.Join(dir, "weather.sqlite"))
+	assert.Nil(t, err)
+	return db
+}
@@ -0,0 +1,80 @@
+package command
+
+import (
+	"strconv"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// toggleSetting is a bit mask field of a bookmark, that user chooses by ticking buttons, such as
+// excluded weather or wind directions. The buttons are expected to send "<prefix>#<bookmark id>#<flag or done>"
+type toggleSetting struct {
+	field    string
+	value    func(bookmark *structs.UsersLocationBookmark) *int
+	keyboard func(bookmark *structs.UsersLocationBookmark) tgbotapi.InlineKeyboardMarkup
+}
+
+// processes a click on the toggle keyboard: either toggles one flag or finishes the choice
+func processToggleButton(bot *tgbotapi.BotAPI, repos Repositories, callbackQuery *tgbotapi.CallbackQuery, setting toggleSetting, bookmarkID, choice string) {
+	chatID := callbackQuery.Message.Chat.ID
+
+	intBookmarkID, err := strconv.Atoi(bookmarkID)
+	if err != nil {
+		sentry.CaptureException(errors.Wrap(err, "Can't parse bookmarkID, expected valid number, but got: "+bookmarkID))
+		return
+	}
+
+	bookmark, err := repos.Bookmarks.Get(intBookmarkID)
+	if err != nil || bookmark.UserID != callbackQuery.From.ID {
+		sendMsg(bot, chatID, "Can't find a bookmark")
+		return
+	}
+
+	if choice == ButtonDone {
+
+		// remove the keyboard, the choice is made
+		removeButtons(bot, chatID, callbackQuery.Message.MessageID)
+
+		if !bookmark.IsReady {
+
+			// this is part of the adding new location steps, so use state machine
+			if stateMachine := resumeWizard(bot, repos, chatID, callbackQuery.From); stateMachine != nil {
+				stateMachine.ProcessNextState(ButtonDone)
+			}
+			return
+		}
+
+		sendMsg(bot, chatID, "✅ Saved. You can see all saved bookmarks using the command \n /locations")
+		return
+	}
+
+	flag, err := strconv.Atoi(choice)
+	if err != nil {
+		sentry.CaptureException(errors.Wrap(err, "Can't parse toggle button value, expected valid number, but got: "+choice))
+		return
+	}
+
+	value := setting.value(&bookmark)
+	*value ^= flag
+	if err := repos.Bookmarks.UpdateField(&bookmark, setting.field, *value); err != nil {
+		sentry.CaptureException(err)
+		sendMsg(bot, chatID, "Sorry, internal error occurred, can't save your choice. Please try again later.")
+		return
+	}
+
+	// re-render the keyboard with the new ticks
+	keyboardMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, callbackQuery.Message.MessageID, setting.keyboard(&bookmark))
+	bot.Send(keyboardMsg)
+}
+
+// the label of the toggle button, ticked or not
+func toggleLabel(isOn bool, label string) string {
+	if isOn {
+		return "✅ " + label
+	}
+	return "⬜ " + label
+}
@@ -0,0 +1,150 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/w32blaster/bot-weather-watcher/structs"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// UV warning for one good day
+type uvWarning struct {
+	day       goodDay
+	level     int    // the level chosen by user
+	peakHours string // for example "12:00–15:00"
+}
+
+// returns the name of the UV index band according to the WHO scale
+// Please refer to unit tests
+func uvBand(uv int) string {
+	switch {
+	case uv >= 11:
+		return "extreme"
+	case uv >= 8:
+		return "very high"
+	case uv >= 6:
+		return "high"
+	case uv >= 3:
+		return "moderate"
+	default:
+		return "low"
+	}
+}
+
+// finds hours in the 3-hourly forecast for one day when UV index is at or above the level,
+// and returns them as time ranges, for example "09:00–12:00, 15:00–18:00".
+// Please refer to unit tests
+func peakUVHours(day structs.Period, level int) string {
+	var ranges []string
+	start, end := -1, -1
+
+	for _, rep := range day.Rep {
+		minutes, err := strconv.Atoi(rep["$"])
+		if err != nil {
+			continue
+		}
+		uv, err := strconv.Atoi(rep["U"])
+		if err != nil || uv < level {
+			if start != -1 {
+				ranges = append(ranges, formatMinutes(start)+"–"+formatMinutes(end))
+				start = -1
+			}
+			continue
+		}
+
+		// each value covers three hours, starting from the given minute
+		if start == -1 {
+			start = minutes
+		}
+		end = minutes + 180
+	}
+	if start != -1 {
+		ranges = append(ranges, formatMinutes(start)+"–"+formatMinutes(end))
+	}
+
+	return strings.Join(ranges, ", ")
+}
+
+func formatMinutes(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", (minutes/60)%24, minutes%60)
+}
+
+// collects UV warnings for the good days where UV index reaches the level chosen by user.
+// The peak hours are taken from the 3-hourly forecast, one request per location
+func collectUVWarnings(days []goodDay, provider ForecastProvider) []uvWarning {
+	var warnings []uvWarning
+	forecasts := make(map[string]*structs.RootSiteRep)
+
+	for _, day := range days {
+		if day.bookmark.UVWarnLevel == nil || day.uv < *day.bookmark.UVWarnLevel {
+			continue
+		}
+
+		level := *day.bookmark.UVWarnLevel
+		forecast, ok := forecasts[day.bookmark.LocationID]
+		if !ok {
+			var err error
+			if forecast, err = provider.ThreeHourlyForecast(day.bookmark.LocationID); err != nil {
+				sentry.CaptureException(err)
+			}
+			forecasts[day.bookmark.LocationID] = forecast
+		}
+
+		warning := uvWarning{day: day, level: level}
+		if forecast != nil {
+			strDate := day.date.Format(layoutMetofficeDate)
+			for _, period := range forecast.SiteRep.Dv.Location.Periods {
+				if period.Value == strDate {
+					warning.peakHours = peakUVHours(period, level)
+				}
+			}
+		}
+		warnings = append(warnings, warning)
+	}
+
+	return warnings
+}
+
+// sends UV warnings, one message per chat
+func sendUVWarnings(bot *tgbotapi.BotAPI, warnings []uvWarning) {
+	var chats []int64
+	mapWarnings := make(map[int64][]uvWarning)
+	for _, warning := range warnings {
+		chatID := warning.day.bookmark.ChatID
+		if _, ok := mapWarnings[chatID]; !ok {
+			chats = append(chats, chatID)
+		}
+		mapWarnings[chatID] = append(mapWarnings[chatID], warning)
+	}
+
+	for _, chatID := range chats {
+		chatWarnings := mapWarnings[chatID]
+		sort.SliceStable(chatWarnings, func(i, j int) bool {
+			return chatWarnings[i].day.date.Before(chatWarnings[j].day.date)
+		})
+
+		var buffer bytes.Buffer
+		buffer.WriteString("🧴 UV warning, don't forget sunscreen: \n\n")
+		for _, warning := range chatWarnings {
+			buffer.WriteString(fmt.Sprintf(" - %s on %s: UV index %d (%s)",
+				escapeMarkdown(siteTitle(warning.day)),
+				warning.day.date.Format("02 Jan, Mon"),
+				warning.day.uv,
+				uvBand(warning.day.uv)))
+			if len(warning.peakHours) > 0 {
+				buffer.WriteString(", peak at " + warning.peakHours)
+			}
+			buffer.WriteString("\n")
+		}
+
+		for _, chunk := range splitMessage(buffer.String(), maxMessageLength) {
+			sendMsg(bot, chatID, chunk)
+		}
+	}
+}
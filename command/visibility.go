@@ -0,0 +1,78 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// MetOffice visibility codes in the ascending order, so they could be compared
+var visibilityScale = map[string]int{
+	"UN": 0,
+	"VP": 1,
+	"PO": 2,
+	"MO": 3,
+	"GO": 4,
+	"VG": 5,
+	"EX": 6,
+}
+
+var visibilityNames = map[string]string{
+	"UN": "Unknown",
+	"VP": "Very poor (<1 km)",
+	"PO": "Poor (1-4 km)",
+	"MO": "Moderate (4-10 km)",
+	"GO": "Good (10-20 km)",
+	"VG": "Very good (20-40 km)",
+	"EX": "Excellent (>40 km)",
+}
+
+// returns the level on the scale, unknown codes are treated as "UN"
+func visibilityLevel(code string) int {
+	return visibilityScale[code]
+}
+
+// returns the visibility code for the level on the scale
+func visibilityCode(level int) string {
+	for code, l := range visibilityScale {
+		if l == level {
+			return code
+		}
+	}
+	return "UN"
+}
+
+// prints visibility codes for every 3 hours as a text band, for example
+//
+//	0am  3am  6am  9am 12pm  3pm  6pm  9pm
+//	 VG   VG   GO   MO   GO   VG   EX   EX
+func printVisibilityBand(data []map[string]string) string {
+	var bufferHours bytes.Buffer
+	var bufferCodes bytes.Buffer
+
+	for _, mapHour := range data {
+		minutes, err := strconv.Atoi(mapHour["$"])
+		if err != nil {
+			continue
+		}
+
+		hour := minutes / 60
+		suffix := "am"
+		if hour >= 12 {
+			suffix = "pm"
+		}
+		if hour > 12 {
+			hour = hour - 12
+		}
+
+		code := mapHour["V"]
+		if _, ok := visibilityScale[code]; !ok {
+			code = "UN"
+		}
+
+		bufferHours.WriteString(fmt.Sprintf("%5s", strconv.Itoa(hour)+suffix))
+		bufferCodes.WriteString(fmt.Sprintf("%5s", code))
+	}
+
+	return "```\n" + bufferHours.String() + "\n" + bufferCodes.String() + "\n```\n"
+}
@@ -2,10 +2,23 @@ package structs
 
 // Opts command line arguments
 type Opts struct {
-	Port           int    `env:"PORT" envDefault:"8444"`
-	Host           string `env:"HOST" envDefault:"localhost"`
-	IsDebug        bool   `env:"IS_DEBUG"`
-	BotToken       string `env:"BOT_TOKEN,required"`
-	MetofficeAppID string `env:"METOFFICE_APP_ID"`
-	SentryDSN      string `env:"SENTRY_DSN"`
+	Port                int    `env:"PORT" envDefault:"8444"`
+	Host                string `env:"HOST" envDefault:"localhost"`
+	IsDebug             bool   `env:"IS_DEBUG"`
+	BotToken            string `env:"BOT_TOKEN,required"`
+	MetofficeAppID      string `env:"METOFFICE_APP_ID"`
+	SentryDSN           string `env:"SENTRY_DSN"`
+	ProfilesPath        string `env:"PROFILES_PATH"` // YAML file with more activity profiles, optional
+	AdminIDs            []int  `env:"ADMIN_IDS"`     // Telegram users allowed to ask for backups and events, comma separated
+	BackupDir           string `env:"BACKUP_DIR" envDefault:"storage/backups"`
+	BackupKeep          int    `env:"BACKUP_KEEP" envDefault:"7"` // how many daily backups to keep, 0 keeps all
+	CallbackSecret      string `env:"CALLBACK_SECRET"`            // signs the data of inline buttons, derived from the token if empty
+	Storage             string `env:"STORAGE" envDefault:"storm"` // "storm" or "sqlite"
+	SQLitePath          string `env:"SQLITE_PATH" envDefault:"storage/weather.sqlite"`
+	SiteListPath        string `env:"SITE_LIST_PATH" envDefault:"api-examples/site-list.json"` // imported into a new database
+	EventRetentionDays  int    `env:"EVENT_RETENTION_DAYS" envDefault:"90"`                    // how long the event log is kept, 0 is forever
+	WizardIdleHours     int    `env:"WIZARD_IDLE_HOURS" envDefault:"24"`                       // unanswered wizards are cancelled after it, 0 never
+	WizardExpiredNotice bool   `env:"WIZARD_EXPIRED_NOTICE" envDefault:"true"`                 // tell users that their wizard was cancelled
+	EventCompactDays    int    `env:"EVENT_COMPACT_DAYS" envDefault:"30"`                      // older alerts and checked days are summarized per day, 0 never
+	BackupRetentionDays int    `env:"BACKUP_RETENTION_DAYS" envDefault:"90"`                   // older backups are removed by the janitor, 0 keeps all
 }
@@ -1,21 +1,80 @@
 package structs
 
+import "time"
+
 type (
 	UsersLocationBookmark struct {
-		ID           int    `storm:"id,increment"` // primary key
-		LocationID   string `storm:"index"`        // this field will be indexed
-		UserID       int    `storm:"index"`
-		UserName     string // just for logging :)
-		ChatID       int64  // chat ID where to send notifications
-		MaxWindSpeed int
-		LowestTemp   int
-		IsReady      bool `storm:"index"`
-		CheckPeriod  int
+		ID              int    `storm:"id,increment"` // primary key
+		LocationID      string `storm:"index"`        // this field will be indexed
+		UserID          int    `storm:"index"`
+		UserName        string // just for logging :)
+		ChatID          int64  // chat ID where to send notifications
+		MaxWindSpeed    int
+		LowestTemp      int
+		IsReady         bool `storm:"index"`
+		CheckPeriod     int
+		ExcludedWeather int // bit mask of weather categories that spoil the day, such as fog or snow
+		WindDirections  int // bit mask of acceptable wind direction sectors, zero means any direction
+
+		// optional bounds, nil means user didn't set them
+		MaxGust       *int
+		MaxTemp       *int
+		MaxHumidity   *int
+		MaxUV         *int
+		MaxPrecipProb *int // the precipitation probability that is still fine, the default one is below 40%
+		UVWarnLevel   *int // send UV warning when a good day has UV index at or above this level
+
+		MinVisibility int // level on the visibility scale, from 0 (any) to 6 (excellent)
+
+		Mode         int  // what to look for: good days or clear nights
+		MinNightTemp *int // for clear nights only
+
+		GoldenHourOnly bool // check only the 3-hour steps around sunrise and sunset, for photographers
+		MinRunLength   int  // how many good days in a row are needed, 0 or 1 means any single good day
+
+		// location group: a named set of sites sharing the thresholds above; LocationID is the first of them
+		GroupName        string
+		GroupLocationIDs []string
+
+		Polarity int // notify about good weather (default) or warn about hazards
+		Hazards  int // bit mask of hazards to warn about, such as frost or gale
+
+		Profile string // the key of the activity profile that thresholds were taken from, empty for custom ones
 	}
 
 	UserState struct {
 		ID           int `storm:"id,increment"`
 		UserID       int `storm:"unique"` // one user can have only one state
 		CurrentState int
+		BookmarkID   int       // the saved bookmark that is being changed, for steps outside of adding a new one
+		UpdatedAt    time.Time `storm:"index"` // the last step, the janitor expires the idle wizards
+	}
+
+	UserSettings struct {
+		ID          int `storm:"id,increment"`
+		UserID      int `storm:"unique"` // one user can have only one settings record
+		DigestGroup int // how to group good days in the digest: by date or by location
+	}
+
+	// AuditEntry records what was done with users data, without the data itself: no user IDs or names
+	AuditEntry struct {
+		ID        int       `storm:"id,increment"`
+		Action    string    `storm:"index"`
+		Time      time.Time // UTC
+		Bookmarks int       // how many records were removed
+		States    int
+		Settings  int
+		Events    int
+	}
+
+	// Event is one record of the append-only log of what users and the bot did, such as a command or a sent alert
+	Event struct {
+		ID         int       `storm:"id,increment"`
+		Type       string    `storm:"index"`
+		Time       time.Time `storm:"index"` // UTC
+		UserID     int       `storm:"index"` // zero for events of the bot itself
+		BookmarkID int       // zero if the event is not about a bookmark
+		LocationID string
+		Details    string // depends on the type, for example the name of the command
 	}
 )